@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// exportUsers は保存先の全ての状態をJSONとして返すエンドポイントのハンドラ
+// 移行やバックアップのためのもので、返した内容はPOST /admin/importでそのまま読み込める
+// 削除済みのユーザーや整数のIDも返すため、allowExportが有効な場合（ALLOW_EXPORT=true）だけ受け付ける
+func (a *App) exportUsers(w http.ResponseWriter, r *http.Request) {
+	if !a.allowExport {
+		writeError(w, http.StatusForbidden, "forbidden", "export is disabled")
+		return
+	}
+	b, err := a.store.Export()
+	if err != nil {
+		log.Printf("failed to export users: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to export users")
+		return
+	}
+	// IDの割り当て方がuuidの場合は、他のレスポンスと同じく整数のIDを含めない
+	if a.idStrategy == idStrategyUUID {
+		b.Users = a.publicUsers(b.Users)
+		b.NextID = 0
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// importUsers はGET /admin/exportで書き出した内容で保存先の全ての状態を置き換えるエンドポイントのハンドラ
+// 一部のユーザーだけが置き換わった状態にならないよう、全件を検証してからまとめて置き換える
+// 空のバックアップで全てのユーザーを消せてしまうため、allowImportが有効な場合（ALLOW_IMPORT=true）だけ受け付ける
+func (a *App) importUsers(w http.ResponseWriter, r *http.Request) {
+	if !a.allowImport {
+		writeError(w, http.StatusForbidden, "forbidden", "import is disabled")
+		return
+	}
+	var b Backup
+	if err := decodeJSON(w, r, &b); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if errs := a.validateBackup(&b); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	err := a.store.Import(b)
+	switch {
+	case errors.Is(err, ErrDuplicateName):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+		return
+	case errors.Is(err, ErrStoreFull):
+		writeError(w, http.StatusInsufficientStorage, "insufficient_storage", err.Error())
+		return
+	case err != nil:
+		log.Printf("failed to import users: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to import users")
+		return
+	}
+	a.forgetResponses()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReindexResponse はIDの振り直しのレスポンスを表す構造体。
+type ReindexResponse struct {
+	IDs map[int]int `json:"ids"` // 元のIDから新しいIDへの対応（JSONではキーを文字列で表す）
+}
+
+// reindexUsers は削除済みでないユーザーのIDを1から詰めて振り直し、削除済みのユーザーにはその後に続くIDを振り直すエンドポイントのハンドラ
+// 削除を重ねてIDがまばらになった状態から、連続したIDで書き出したい場合のためのもの
+// 振り直したユーザーは元のIDで取得できなくなるため、allowReindexが有効な場合（ALLOW_REINDEX=true）だけ受け付ける
+func (a *App) reindexUsers(w http.ResponseWriter, r *http.Request) {
+	if !a.allowReindex {
+		writeError(w, http.StatusForbidden, "forbidden", "reindex is disabled")
+		return
+	}
+	ids, err := a.store.Reindex()
+	if err != nil {
+		log.Printf("failed to reindex users: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to reindex users")
+		return
+	}
+	a.forgetResponses()
+	writeJSON(w, http.StatusOK, ReindexResponse{IDs: ids})
+}
+
+// validateBackup は読み込むユーザーを正規化して検証し、見つかった全ての誤りを返す。
+// IDとUUIDが重複していないことも確認する。誤りがない場合はnilを返す。
+// 削除済みかどうかはdeleted_atから決め、バージョンや更新日時がない場合はloadUsersと同じく補う。
+func (a *App) validateBackup(b *Backup) ValidationErrors {
+	if a.idStrategy == idStrategyUUID {
+		assignBackupIDs(b)
+	}
+	var errs ValidationErrors
+	ids := make(map[int]bool, len(b.Users))
+	uuids := make(map[string]bool, len(b.Users))
+	for i := range b.Users {
+		u := &b.Users[i]
+		normalizeUser(u)
+		userErrs := a.validateUser(*u)
+		switch {
+		case u.ID <= 0:
+			userErrs = append(userErrs, FieldError{Field: "id", Message: "must be a positive integer"})
+		case ids[u.ID]:
+			userErrs = append(userErrs, FieldError{Field: "id", Message: "duplicate id"})
+		}
+		ids[u.ID] = true
+		if u.UUID != "" {
+			switch {
+			case !isUUID(u.UUID):
+				userErrs = append(userErrs, FieldError{Field: "uuid", Message: "must be a UUID"})
+			case uuids[u.UUID]:
+				userErrs = append(userErrs, FieldError{Field: "uuid", Message: "duplicate uuid"})
+			}
+			uuids[u.UUID] = true
+		}
+		for _, e := range userErrs.withIndex(i) {
+			errs = append(errs, FieldError{Field: "users" + e.Field, Message: e.Message})
+		}
+
+		u.Deleted = u.DeletedAt != nil
+		if u.Version == 0 {
+			u.Version = 1
+		}
+		if u.UpdatedAt.IsZero() {
+			u.UpdatedAt = u.CreatedAt
+		}
+	}
+	return errs
+}
+
+// assignBackupIDs はIDのないユーザーに、backupNextIDから順にIDを割り当てる。
+// IDの割り当て方がuuidの場合に書き出した内容には整数のIDが含まれないため、読み込むときに振り直す。
+func assignBackupIDs(b *Backup) {
+	next := backupNextID(*b)
+	for i := range b.Users {
+		if b.Users[i].ID == 0 {
+			b.Users[i].ID = next
+			next++
+		}
+	}
+}
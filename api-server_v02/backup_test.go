@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// withBackup は書き出しと読み込みを有効にする。
+func withBackup(a *App) {
+	a.allowExport = true
+	a.allowImport = true
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, strategy := range []string{idStrategySequential, idStrategyUUID} {
+		t.Run(strategy, func(t *testing.T) {
+			configure := func(a *App) {
+				withBackup(a)
+				a.idStrategy = strategy
+			}
+			src := newTestServer(t, configure)
+			var users []User
+			for _, name := range []string{"alice", "bob", "carol"} {
+				users = append(users, src.CreateUser(name))
+			}
+			bobPath := userPath(users[1])
+			if res, data := src.do(http.MethodDelete, bobPath, ""); res.StatusCode != http.StatusOK {
+				t.Fatalf("DELETE %s: status = %d: %s", bobPath, res.StatusCode, data)
+			}
+			src.CreateUser("bob")
+
+			res, exported := src.do(http.MethodGet, "/admin/export", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /admin/export: status = %d: %s", res.StatusCode, exported)
+			}
+			want := decodeTestJSON[Backup](t, exported)
+
+			dst := newTestServer(t, configure)
+			dst.CreateUser("dave") // 読み込むと元からいたユーザーは置き換わる
+			if res, data := dst.do(http.MethodPost, "/admin/import", string(exported)); res.StatusCode != http.StatusNoContent {
+				t.Fatalf("POST /admin/import: status = %d: %s", res.StatusCode, data)
+			}
+			res, data := dst.do(http.MethodGet, "/admin/export", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /admin/export after import: status = %d: %s", res.StatusCode, data)
+			}
+			if got := decodeTestJSON[Backup](t, data); !reflect.DeepEqual(got, want) {
+				t.Errorf("export after import = %+v, want %+v", got, want)
+			}
+
+			// 削除済みのユーザーは削除済みのまま読み込み、残りのユーザーは元のパスで取得できる
+			if res, _ := dst.do(http.MethodGet, bobPath, ""); res.StatusCode != http.StatusNotFound {
+				t.Errorf("GET %s after import: status = %d, want %d for the user deleted before the export", bobPath, res.StatusCode, http.StatusNotFound)
+			}
+			alicePath := userPath(users[0])
+			if res, data := dst.do(http.MethodGet, alicePath, ""); res.StatusCode != http.StatusOK || decodeTestJSON[User](t, data).Name != "alice" {
+				t.Errorf("GET %s after import: status = %d: %s, want alice", alicePath, res.StatusCode, data)
+			}
+			// 次に割り当てるIDは読み込んだユーザーと重ならない
+			dst.CreateUser("erin")
+			if got := dst.app.store.Count(); got != 4 {
+				t.Errorf("Count() after adding a user to the imported store = %d, want 4", got)
+			}
+		})
+	}
+}
+
+func TestBackupDisabled(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/admin/export", ""},
+		{http.MethodPost, "/admin/import", `{"users":[],"next_id":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != http.StatusForbidden {
+				t.Fatalf("%s %s without ALLOW_EXPORT and ALLOW_IMPORT: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, http.StatusForbidden, data)
+			}
+			if got := ts.app.store.Count(); got != 1 {
+				t.Errorf("Count() = %d after %s %s, want 1", got, tt.method, tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// basePathKey はコンテキストにBASE_PATHを格納するためのキーの型
+type basePathKey struct{}
+
+// mountBasePath はhを "/api/v1" のようなbasePathの下で提供するハンドラを返す。
+// ゲートウェイがパスの先頭部分を付けたまま転送する場合のためのもので、
+// hのミドルウェアやハンドラにはbasePathを取り除いたパスを渡すため、各ルートの登録は変えなくてよい。
+// basePathの外のパスは404とする。basePathが空の場合はhをそのまま返す。
+func mountBasePath(h http.Handler, basePath string) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	stripped := http.StripPrefix(basePath, h)
+	mux := http.NewServeMux()
+	mux.HandleFunc(basePath+"/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), basePathKey{}, basePath)
+		stripped.ServeHTTP(w, r.WithContext(ctx))
+	})
+	return mux
+}
+
+// basePath はmountBasePathが取り除いたパスの先頭部分を返す。
+// LocationヘッダやLinkヘッダのように、クライアントがそのまま使うパスの先頭に付ける。
+func basePath(r *http.Request) string {
+	p, _ := r.Context().Value(basePathKey{}).(string)
+	return p
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMountBasePath(t *testing.T) {
+	tests := []struct {
+		basePath   string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"", http.MethodGet, "/users/1", http.StatusOK},
+		{"", http.MethodGet, "/healthz", http.StatusOK},
+		{"/api/v1", http.MethodGet, "/api/v1/users/1", http.StatusOK},
+		{"/api/v1", http.MethodGet, "/api/v1/users", http.StatusOK},
+		{"/api/v1", http.MethodPost, "/api/v1/users", http.StatusCreated},
+		{"/api/v1", http.MethodGet, "/api/v1/healthz", http.StatusOK},
+		// 先頭部分のないパスでは提供しない
+		{"/api/v1", http.MethodGet, "/users/1", http.StatusNotFound},
+		{"/api/v1", http.MethodPost, "/users", http.StatusNotFound},
+		{"/api/v1", http.MethodGet, "/healthz", http.StatusNotFound},
+		{"/api/v1", http.MethodGet, "/api/v1", http.StatusNotFound},
+		{"/api/v1", http.MethodGet, "/api/v2/users/1", http.StatusNotFound},
+		{"/api/v1", http.MethodGet, "/api/v1users/1", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.basePath+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			app := NewApp(NewInMemoryStore(""))
+			srv := httptest.NewServer(mountBasePath(app.newMux(), tt.basePath))
+			t.Cleanup(srv.Close)
+			ts := &testServer{Server: srv, t: t, app: app}
+			ts.do(http.MethodPost, tt.basePath+"/users", `{"name":"alice"}`)
+
+			body := ""
+			if tt.method == http.MethodPost {
+				body = `{"name":"bob"}`
+			}
+			res, data := ts.do(tt.method, tt.path, body)
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("%s %s with BASE_PATH %q: status = %d, want %d: %s", tt.method, tt.path, tt.basePath, res.StatusCode, tt.wantStatus, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBatchIDs は1回の一括取得で指定できるIDの最大数
+const maxBatchIDs = 100
+
+// BatchResponse は複数のIDを指定したユーザー取得のレスポンスを表す構造体。
+type BatchResponse struct {
+	Users   []User `json:"users"`   // 見つかったユーザー（指定された順）
+	Missing []int  `json:"missing"` // 見つからなかったID
+}
+
+// getUsersBatch はidsにカンマ区切りで指定された複数のユーザーをまとめて返すエンドポイントのハンドラ
+// 同じIDは1回だけ扱い、存在しないIDはエラーにせずmissingとして返す
+func (a *App) getUsersBatch(w http.ResponseWriter, r *http.Request) {
+	// 整数のIDで取得できると件数を推測できるため、IDの割り当て方がuuidの場合は受け付けない
+	if a.idStrategy == idStrategyUUID {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "ids cannot be used when ID_STRATEGY is uuid")
+		return
+	}
+	ids, err := parseIDs(r.URL.Query().Get("ids"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	res := BatchResponse{Users: []User{}, Missing: []int{}}
+	for _, id := range ids {
+		if clientGone(r) {
+			return
+		}
+		if u, ok := a.store.Get(id); ok {
+			res.Users = append(res.Users, u)
+		} else {
+			res.Missing = append(res.Missing, id)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// parseIDs は "1,2,3" のようなカンマ区切りのIDを、重複を除いて指定された順に返す。
+func parseIDs(v string) ([]int, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, errors.New("ids is required")
+	}
+	parts := strings.Split(v, ",")
+	ids := make([]int, 0, len(parts))
+	seen := make(map[int]bool, len(parts))
+	for _, p := range parts {
+		id, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, errors.New("ids must be comma-separated integers")
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) > maxBatchIDs {
+		return nil, fmt.Errorf("ids must contain at most %d ids", maxBatchIDs)
+	}
+	return ids, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGetUsersBatch(t *testing.T) {
+	ts := newTestServer(t)
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		ts.CreateUser(name)
+	}
+	ts.do(http.MethodDelete, "/users/4", "")
+
+	tooMany := make([]string, maxBatchIDs+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i + 1)
+	}
+
+	tests := []struct {
+		name        string
+		ids         string
+		wantStatus  int
+		wantIDs     []int
+		wantMissing []int
+		wantMessage string
+	}{
+		{"all existing", "1,2,3", http.StatusOK, []int{1, 2, 3}, []int{}, ""},
+		// 指定された順に返す
+		{"in the requested order", "3,1", http.StatusOK, []int{3, 1}, []int{}, ""},
+		{"existing and missing", "1,9,2,8", http.StatusOK, []int{1, 2}, []int{9, 8}, ""},
+		{"deleted users are missing", "4,3", http.StatusOK, []int{3}, []int{4}, ""},
+		{"duplicates", "2,2,%201,2", http.StatusOK, []int{2, 1}, []int{}, ""},
+		{"all missing", "9", http.StatusOK, []int{}, []int{9}, ""},
+		{"malformed id", "1,abc", http.StatusBadRequest, nil, nil, "ids must be comma-separated integers"},
+		{"empty element", "1,,2", http.StatusBadRequest, nil, nil, "ids must be comma-separated integers"},
+		{"missing ids", "", http.StatusBadRequest, nil, nil, "ids is required"},
+		{"too many ids", strings.Join(tooMany, ","), http.StatusBadRequest, nil, nil, "at most"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, data := ts.do(http.MethodGet, "/users/batch?ids="+tt.ids, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /users/batch?ids=%s: status = %d, want %d: %s", tt.ids, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := decodeTestJSON[ErrorResponse](t, data).Error; got.Code != "invalid_parameter" || !strings.Contains(got.Message, tt.wantMessage) {
+					t.Errorf("GET /users/batch?ids=%s: error = %+v, want invalid_parameter containing %q", tt.ids, got, tt.wantMessage)
+				}
+				return
+			}
+			got := decodeTestJSON[BatchResponse](t, data)
+			if ids := userIDs(got.Users); !slices.Equal(ids, tt.wantIDs) || got.Users == nil {
+				t.Errorf("GET /users/batch?ids=%s: users = %v, want %v", tt.ids, ids, tt.wantIDs)
+			}
+			if !slices.Equal(got.Missing, tt.wantMissing) || got.Missing == nil {
+				t.Errorf("GET /users/batch?ids=%s: missing = %v, want %v", tt.ids, got.Missing, tt.wantMissing)
+			}
+		})
+	}
+}
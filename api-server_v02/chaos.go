@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// withChaosDelay は全てのリクエストの処理をdelayだけ遅らせるミドルウェア
+// クライアントのタイムアウトの扱いを試すためのもので、CHAOS_DELAYを設定した場合だけ有効にする
+// 待っている間にクライアントが切断するかタイムアウトした場合は、ハンドラを呼び出さずに戻る
+// 遅延がリクエストごとに独立し、順に処理される書き込みで積み重ならないよう、withWriteQueueより外側に置く
+// 遅延もwithTimeoutの処理時間に含めるため、withTimeoutよりは内側に置く
+func withChaosDelay(next http.Handler, delay time.Duration) http.Handler {
+	if delay <= 0 {
+		return next
+	}
+	slog.Warn("CHAOS_DELAY is set, every request is delayed", "delay", delay.String())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			next.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithChaosDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		chaosDelay string // CHAOS_DELAY
+		cancel     bool   // 待っている間にクライアントが切断するか
+		wantDelay  time.Duration
+		wantCalled bool
+	}{
+		// 未設定の場合は遅らせない
+		{"off by default", "", false, 0, true},
+		{"delayed", "50ms", false, 50 * time.Millisecond, true},
+		{"canceled while waiting", "1h", true, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHAOS_DELAY", tt.chaosDelay)
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() with CHAOS_DELAY=%q: %v", tt.chaosDelay, err)
+			}
+			called := false
+			h := withChaosDelay(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusNoContent)
+			}), cfg.ChaosDelay)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			start := time.Now()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil).WithContext(ctx))
+			elapsed := time.Since(start)
+
+			if elapsed < tt.wantDelay {
+				t.Errorf("CHAOS_DELAY=%q: response took %v, want at least %v", tt.chaosDelay, elapsed, tt.wantDelay)
+			}
+			if tt.cancel && elapsed >= cfg.ChaosDelay {
+				t.Errorf("CHAOS_DELAY=%q: canceled request took %v", tt.chaosDelay, elapsed)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("CHAOS_DELAY=%q: handler called = %t, want %t", tt.chaosDelay, called, tt.wantCalled)
+			}
+		})
+	}
+}
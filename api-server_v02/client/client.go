@@ -0,0 +1,173 @@
+// Package client はユーザー管理APIを他のGoのプログラムから呼び出すためのクライアント。
+// サーバはpackage mainのためインポートできないので、やり取りする型はこのパッケージで定義する。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User はAPIがやり取りするユーザー情報を表す構造体。フィールドはサーバのUserと同じ。
+type User struct {
+	ID        int        `json:"id"`
+	UUID      string     `json:"uuid,omitempty"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email,omitempty"`
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// 呼び出し側がerrors.Isで判別できる、ステータスコードごとのエラー
+var (
+	ErrNotFound = errors.New("not found")          // 404
+	ErrConflict = errors.New("conflict")           // 409
+	ErrInvalid  = errors.New("invalid request")    // 400と422
+	ErrServer   = errors.New("server unavailable") // 5xx
+)
+
+// Error は2xx以外のレスポンスを表すエラー。
+// サーバが返したエラーのJSONのコードとメッセージを持ち、errors.Isでステータスコードに応じたErrNotFoundなどと一致する。
+type Error struct {
+	StatusCode int    // レスポンスのステータスコード
+	Code       string // not_found のようなエラーのコード（JSONでない場合は空）
+	Message    string // エラーの内容（JSONでない場合はステータスの説明）
+}
+
+// Error はステータスコードとサーバが返したメッセージを返す。
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is はステータスコードに対応するエラーとtargetが一致するかを返す。
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// listPageSize はListUsersで1回のリクエストで取得する件数
+const listPageSize = 100
+
+// Client はユーザー管理APIのクライアント。
+type Client struct {
+	baseURL    string // "http://localhost:8080" や "https://example.com/api/v1" のようなAPIの起点
+	httpClient *http.Client
+}
+
+// New はbaseURLのAPIを呼び出すClientを生成する。httpClientがnilの場合はhttp.DefaultClientを使う。
+// BASE_PATHの下で提供している場合は、baseURLにその先頭部分まで含める。
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{baseURL: strings.TrimRight(baseURL, "/"), httpClient: httpClient}
+}
+
+// AddUser はユーザーを追加し、IDなどを割り当てられたユーザーを返す。
+func (c *Client) AddUser(ctx context.Context, u User) (User, error) {
+	var added User
+	err := c.do(ctx, http.MethodPost, "/users", u, &added)
+	return added, err
+}
+
+// GetUser は指定されたIDのユーザーを返す。見つからない場合はErrNotFoundと一致するエラーを返す。
+func (c *Client) GetUser(ctx context.Context, id int) (User, error) {
+	var u User
+	err := c.do(ctx, http.MethodGet, "/users/"+strconv.Itoa(id), nil, &u)
+	return u, err
+}
+
+// ListUsers は削除済みのものを除く全てのユーザーをIDの順に返す。
+// サーバはページごとに返すため、カーソルをたどって最後のページまで取得する。
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	users := []User{}
+	cursor := ""
+	for {
+		q := url.Values{"limit": {strconv.Itoa(listPageSize)}, "cursor": {cursor}}
+		var page struct {
+			Users      []User  `json:"users"`
+			NextCursor *string `json:"next_cursor"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/users?"+q.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		users = append(users, page.Users...)
+		if page.NextCursor == nil || *page.NextCursor == "" {
+			return users, nil
+		}
+		cursor = *page.NextCursor
+	}
+}
+
+// do はpathへリクエストを送り、2xxのレスポンスのJSONをoutにデコードする。
+// bodyがnilでない場合はJSONにしてリクエストボディとする。2xx以外の場合は*Errorを返す。
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newError(res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+// newError は2xx以外のレスポンスから*Errorを作る。
+// ボディがサーバの共通の形式のエラーのJSONでない場合は、ステータスの説明をメッセージとする。
+func newError(res *http.Response) *Error {
+	e := &Error{StatusCode: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+	var envelope struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&envelope); err == nil && envelope.Error.Code != "" {
+		e.Code, e.Message = envelope.Error.Code, envelope.Error.Message
+	}
+	return e
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"api-server_v02/client"
+)
+
+func TestClientRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	c := client.New(ts.URL, ts.Client())
+	ctx := context.Background()
+
+	added, err := c.AddUser(ctx, client.User{Name: "alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if added.ID == 0 || added.Name != "alice" || added.Email != "alice@example.com" || added.Version != 1 {
+		t.Errorf("AddUser returned %+v, want alice with an id at version 1", added)
+	}
+
+	got, err := c.GetUser(ctx, added.ID)
+	if err != nil {
+		t.Fatalf("GetUser(%d): %v", added.ID, err)
+	}
+	if got.ID != added.ID || got.Name != added.Name || !got.CreatedAt.Equal(added.CreatedAt) {
+		t.Errorf("GetUser(%d) = %+v, want %+v", added.ID, got, added)
+	}
+}
+
+func TestClientListUsersPages(t *testing.T) {
+	// 1ページに収まらない件数にして、カーソルを最後のページまでたどることを確かめる
+	const total = 150
+	ts := newTestServer(t)
+	for i := 1; i <= total; i++ {
+		ts.CreateUser(fmt.Sprintf("user%03d", i))
+	}
+
+	users, err := client.New(ts.URL, ts.Client()).ListUsers(context.Background())
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(users) != total {
+		t.Fatalf("ListUsers returned %d users, want %d", len(users), total)
+	}
+	for i, u := range users {
+		if u.ID != i+1 {
+			t.Fatalf("ListUsers()[%d].ID = %d, want %d", i, u.ID, i+1)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ts := newTestServer(t)
+	ts.CreateUser("alice")
+	c := client.New(ts.URL, ts.Client())
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"get non-existent user", func() error { _, err := c.GetUser(ctx, 99); return err }, client.ErrNotFound},
+		{"add duplicate name", func() error { _, err := c.AddUser(ctx, client.User{Name: "alice"}); return err }, client.ErrConflict},
+		{"add invalid user", func() error { _, err := c.AddUser(ctx, client.User{Name: ""}); return err }, client.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) {
+				t.Errorf("error = %#v, want a *client.Error", err)
+			}
+		})
+	}
+}
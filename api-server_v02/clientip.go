@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIPKey はコンテキストにクライアントのIPアドレスを格納するためのキーの型
+type clientIPKey struct{}
+
+// withClientIP はリクエスト元のクライアントのIPアドレスを決めてコンテキストに格納するミドルウェア
+// trustProxyがtrueの場合はリバースプロキシの後ろで動いているものとして、
+// X-Forwarded-Forの左端（プロキシに届いた時点の送信元）を使う。
+// 直接公開している場合に送信元を偽装されないよう、既定ではr.RemoteAddrだけを使う。
+func withClientIP(next http.Handler, trustProxy bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if trustProxy {
+			if forwarded := forwardedFor(r); forwarded != "" {
+				ip = forwarded
+			}
+		}
+		ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP はwithClientIPが決めたクライアントのIPアドレスを返す。
+// withClientIPを通っていない場合はr.RemoteAddrのIPアドレスを返す。
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// forwardedFor はX-Forwarded-Forヘッダの左端のIPアドレスを返す。
+// ヘッダがない場合やIPアドレスとして解釈できない場合は空文字を返す。
+func forwardedFor(r *http.Request) string {
+	v := r.Header.Get("X-Forwarded-For")
+	first, _, _ := strings.Cut(v, ",")
+	ip := net.ParseIP(strings.TrimSpace(first))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  string // X-Forwarded-Forヘッダ（空の場合はヘッダを送らない）
+		want       string
+	}{
+		{"untrusted without header", false, "", "192.0.2.1"},
+		// プロキシを信頼しない場合はヘッダで送信元を偽装できない
+		{"untrusted with header", false, "203.0.113.7", "192.0.2.1"},
+		{"trusted without header", true, "", "192.0.2.1"},
+		{"trusted with header", true, "203.0.113.7", "203.0.113.7"},
+		// 複数のプロキシを経由した場合は左端が元の送信元
+		{"trusted with proxy chain", true, "203.0.113.7, 198.51.100.2, 192.0.2.1", "203.0.113.7"},
+		{"trusted with IPv6", true, "2001:db8::1", "2001:db8::1"},
+		{"trusted with invalid header", true, "not-an-ip", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogs(t)
+			var got string
+			h := withClientIP(withLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})), tt.trustProxy)
+
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			r.RemoteAddr = "192.0.2.1:54321"
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("clientIP(X-Forwarded-For: %q, trustProxy %v) = %q, want %q", tt.forwarded, tt.trustProxy, got, tt.want)
+			}
+			// リクエストのログにも同じIPアドレスを出力する
+			if got := requestLog(t, buf)["client"]; got != tt.want {
+				t.Errorf("request log client = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClientIPWithoutMiddleware はwithClientIPを通っていないリクエストでは、
+// ヘッダにかかわらずr.RemoteAddrのIPアドレスを返すことを確かめる。
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.RemoteAddr = "192.0.2.1:54321"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if got := clientIP(r); got != "192.0.2.1" {
+		t.Errorf("clientIP without withClientIP = %q, want 192.0.2.1", got)
+	}
+}
+
+// TestWithRateLimitBehindProxy はプロキシを信頼する場合だけ、
+// 同じプロキシを経由したクライアントをX-Forwarded-Forで区別して数えることを確かめる。
+func TestWithRateLimitBehindProxy(t *testing.T) {
+	tests := []struct {
+		trustProxy bool
+		wantStatus int // 別のクライアントからのリクエストのステータス
+	}{
+		{false, http.StatusTooManyRequests},
+		{true, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		setTestNow(t, &clock)
+		h := withClientIP(withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}), newRateLimiter(rateLimitPerSecond, rateLimitBurst)), tt.trustProxy)
+		get := func(forwarded string) int {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set("X-Forwarded-For", forwarded)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		for i := 0; i < rateLimitBurst; i++ {
+			get("203.0.113.7")
+		}
+		if got := get("203.0.113.7"); got != http.StatusTooManyRequests {
+			t.Errorf("trustProxy %v: request past the burst: status = %d, want %d", tt.trustProxy, got, http.StatusTooManyRequests)
+		}
+		if got := get("203.0.113.8"); got != tt.wantStatus {
+			t.Errorf("trustProxy %v: request from another client: status = %d, want %d", tt.trustProxy, got, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "net/http"
+
+// defaultMaxConcurrentRequests は同時に処理するリクエスト数の上限のデフォルト値
+const defaultMaxConcurrentRequests = 100
+
+// withConcurrencyLimit は同時に処理するリクエストをlimit件までに制限するミドルウェア
+// 保存先などの後段を守るため、上限に達している場合は待たせずにすぐ503とRetry-Afterヘッダを返す
+// 混雑時にも振り分け先から外されないよう/healthzは数えず、接続を開いたままにする/eventsも枠を占有しないよう対象外とする
+func withConcurrencyLimit(next http.Handler, limit int) http.Handler {
+	sem := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == eventsPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "too_busy", "too many concurrent requests")
+			return
+		}
+		defer func() { <-sem }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	const limit = 2
+	started := make(chan struct{}, limit)
+	release := make(chan struct{})
+	h := withConcurrencyLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("slow") {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), limit)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// 処理に時間のかかるリクエストで上限まで枠を埋める
+	var wg sync.WaitGroup
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get("/users?slow")
+		}()
+		<-started
+	}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/users", http.StatusServiceUnavailable},
+		{"/users/1", http.StatusServiceUnavailable},
+		// 混雑時もヘルスチェックとイベントの配信は断らない
+		{"/healthz", http.StatusNoContent},
+		{eventsPath, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		rec := get(tt.path)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET %s while saturated: status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusServiceUnavailable {
+			continue
+		}
+		if got := rec.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("GET %s while saturated: Retry-After = %q, want 1", tt.path, got)
+		}
+		if got := decodeTestJSON[ErrorResponse](t, rec.Body.Bytes()).Error.Code; got != "too_busy" {
+			t.Errorf("GET %s while saturated: code = %q, want too_busy", tt.path, got)
+		}
+	}
+
+	// 処理が終われば枠が空き、次のリクエストを受け付ける
+	close(release)
+	wg.Wait()
+	for i := 0; i < limit+1; i++ {
+		if rec := get("/users"); rec.Code != http.StatusNoContent {
+			t.Errorf("GET /users after the slow requests finished: status = %d, want %d", rec.Code, http.StatusNoContent)
+		}
+	}
+}
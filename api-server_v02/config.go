@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config は環境変数から読み込むサーバの設定をまとめた構造体。
+type Config struct {
+	Addr              string        // 待ち受けアドレス（PORT、既定値 ":8080"）
+	TLSCertFile       string        // HTTPSで使う証明書のファイル（TLS_CERT_FILE、空の場合はHTTPで待ち受ける）
+	TLSKeyFile        string        // HTTPSで使う秘密鍵のファイル（TLS_KEY_FILE、TLS_CERT_FILEと合わせて指定する）
+	APIKey            string        // X-API-Keyヘッダで要求するキー（API_KEY、空の場合は認証しない）
+	JWTSecret         string        // Bearerトークンの署名を検証する鍵（JWT_SECRET、空の場合は検証しない）
+	CORSAllowedOrigin string        // CORSで許可するオリジン（CORS_ALLOWED_ORIGIN、既定値 "*"、/admin/以下は常に許可しない）
+	TrustProxy        bool          // X-Forwarded-ForのIPアドレスをクライアントとみなすか（TRUST_PROXY、既定値 false）
+	AllowReset        bool          // DELETE /usersを有効にするか（ALLOW_RESET、既定値 false）
+	AllowReindex      bool          // POST /admin/reindexを有効にするか（ALLOW_REINDEX、既定値 false）
+	AllowExport       bool          // GET /admin/exportを有効にするか（ALLOW_EXPORT、既定値 false）
+	AllowImport       bool          // POST /admin/importを有効にするか（ALLOW_IMPORT、既定値 false）
+	AllowDrain        bool          // POST /admin/drainを有効にするか（ALLOW_DRAIN、既定値 false）
+	AllowMerge        bool          // POST /admin/mergeを有効にするか（ALLOW_MERGE、既定値 false）
+	AllowSetReadOnly  bool          // PUT /admin/readonlyを有効にするか（ALLOW_SET_READ_ONLY、既定値 false）
+	RequireUserAgent  bool          // User-Agentヘッダのないリクエストを拒否するか（REQUIRE_USER_AGENT、既定値 false）
+	ReadOnly          bool          // 読み取り専用の状態で起動するか（READ_ONLY、既定値 false）
+	Debug             bool          // GET /debug/statsを有効にするか（DEBUG、既定値 false）
+	LogLevel          slog.Level    // 出力するログの最低レベル（LOG_LEVEL、debug・info・warn・errorのいずれか、既定値 info）
+	IDStrategy        string        // IDの割り当て方（ID_STRATEGY、sequentialまたはuuid、既定値 sequential）
+	BasePath          string        // 全てのルートの先頭に付けるパス（BASE_PATH、"/api/v1" のように指定し、既定値は空）
+	TrailingSlash     string        // 末尾にスラッシュが付いたパスの扱い方（TRAILING_SLASH、stripまたはredirect、未設定の場合は何もしない）
+	MaxNameLength     int           // 名前の最大の文字数（MAX_NAME_LENGTH、既定値 256）
+	MaxUsers          int           // 保存できるユーザー数の上限（MAX_USERS、未設定または0の場合は無制限）
+	MaxBodyBytes      int64         // リクエストボディの最大サイズ（MAX_BODY_BYTES、既定値 1MiB）
+	RequestTimeout    time.Duration // ハンドラの処理時間の上限（REQUEST_TIMEOUT、既定値 5s）
+	ReadHeaderTimeout time.Duration // リクエストヘッダの読み込み（READ_HEADER_TIMEOUT、既定値 5s）
+	ReadTimeout       time.Duration // リクエスト全体の読み込み（READ_TIMEOUT、既定値 10s）
+	WriteTimeout      time.Duration // レスポンスの書き込み（WRITE_TIMEOUT、既定値 15s）
+	IdleTimeout       time.Duration // keep-aliveの待ち時間（IDLE_TIMEOUT、既定値 60s）
+	ShutdownTimeout   time.Duration // 停止時に処理中のリクエストの完了を待つ最大時間（SHUTDOWN_TIMEOUT、既定値 10s）
+	DrainGracePeriod  time.Duration // POST /admin/drainの後、停止するまでリクエストを処理し続ける時間（DRAIN_GRACE_PERIOD、既定値 30s）
+	ChaosDelay        time.Duration // 全てのリクエストの処理を遅らせる時間（CHAOS_DELAY、試験用で未設定の場合は遅らせない）
+	ListCacheTTL      time.Duration // GET /usersのレスポンスを覚えておく時間（LIST_CACHE_TTL、未設定の場合は覚えない）
+	DedupWindow       time.Duration // 同じ内容のPOST /usersを重複とみなす時間（DEDUP_WINDOW、未設定の場合は重複とみなさない）
+	IdempotencyTTL    time.Duration // Idempotency-Keyに対するレスポンスを覚えておく時間（IDEMPOTENCY_TTL、既定値 24h）
+	MaxConcurrent     int           // 同時に処理するリクエスト数の上限（MAX_CONCURRENT_REQUESTS、既定値 100）
+	WriteQueueSize    int           // 処理を待てる書き込みのリクエスト数（WRITE_QUEUE_SIZE、既定値 64）
+	WriteQueueTimeout time.Duration // 書き込みのキューが空くのを待つ最大時間（WRITE_QUEUE_TIMEOUT、既定値 1s）
+	SaveAttempts      int           // ファイルへの書き出しを試す回数（SAVE_ATTEMPTS、既定値 3）
+	SaveRetryDelay    time.Duration // 書き出しの再試行までの最初の待ち時間（SAVE_RETRY_DELAY、既定値 100ms）
+}
+
+// defaultAddr は環境変数で指定がない場合の待ち受けアドレス
+const defaultAddr = ":8080"
+
+// defaultMaxBodyBytes はリクエストボディとして受け付ける最大サイズのデフォルト値（1MiB）
+const defaultMaxBodyBytes = 1 << 20
+
+// HTTPサーバのタイムアウトのデフォルト値
+// 書き込みのタイムアウトはハンドラの処理時間の上限（REQUEST_TIMEOUT）より長くしておく
+const (
+	defaultReadHeaderTimeout = 5 * time.Second  // リクエストヘッダの読み込み
+	defaultReadTimeout       = 10 * time.Second // ボディを含むリクエスト全体の読み込み
+	defaultWriteTimeout      = 15 * time.Second // レスポンスの書き込みの完了まで
+	defaultIdleTimeout       = 60 * time.Second // keep-aliveで次のリクエストを待つ時間
+)
+
+// defaultMaxNameLength は名前の最大の文字数のデフォルト値
+const defaultMaxNameLength = 256
+
+// defaultShutdownTimeout はサーバ停止時に処理中のリクエストの完了を待つ最大時間のデフォルト値
+const defaultShutdownTimeout = 10 * time.Second
+
+// defaultDrainGracePeriod は切り離し中になってから停止するまでの時間のデフォルト値
+// ロードバランサが/healthzの失敗を検知して振り分け先から外すまでの時間より長くしておく
+const defaultDrainGracePeriod = 30 * time.Second
+
+// LoadConfig は環境変数から設定を読み込む。
+// 未設定の項目にはデフォルト値を使い、解釈できない値や範囲外の値がある場合はエラーを返す。
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Addr:              defaultAddr,
+		TLSCertFile:       os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:        os.Getenv("TLS_KEY_FILE"),
+		APIKey:            os.Getenv("API_KEY"),
+		JWTSecret:         os.Getenv("JWT_SECRET"),
+		CORSAllowedOrigin: "*",
+		LogLevel:          slog.LevelInfo,
+		IDStrategy:        idStrategySequential,
+		MaxBodyBytes:      defaultMaxBodyBytes,
+		RequestTimeout:    defaultRequestTimeout,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       defaultReadTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		ShutdownTimeout:   defaultShutdownTimeout,
+		DrainGracePeriod:  defaultDrainGracePeriod,
+		IdempotencyTTL:    defaultIdempotencyTTL,
+		MaxNameLength:     defaultMaxNameLength,
+		MaxConcurrent:     defaultMaxConcurrentRequests,
+		WriteQueueSize:    defaultWriteQueueSize,
+		WriteQueueTimeout: defaultWriteQueueTimeout,
+		SaveAttempts:      defaultSaveAttempts,
+		SaveRetryDelay:    defaultSaveRetryDelay,
+	}
+
+	// PORTが "8080" のようにポート番号だけの場合は先頭にコロンを補う
+	if port := os.Getenv("PORT"); port != "" {
+		if !strings.Contains(port, ":") {
+			port = ":" + port
+		}
+		cfg.Addr = port
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGIN"); v != "" {
+		cfg.CORSAllowedOrigin = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return Config{}, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", v)
+		}
+	}
+	switch v := os.Getenv("ID_STRATEGY"); v {
+	case "":
+	case idStrategySequential, idStrategyUUID:
+		cfg.IDStrategy = v
+	default:
+		return Config{}, fmt.Errorf("invalid ID_STRATEGY %q: must be sequential or uuid", v)
+	}
+
+	if v := strings.TrimRight(os.Getenv("BASE_PATH"), "/"); v != "" {
+		if !strings.HasPrefix(v, "/") || strings.ContainsAny(v, "{}") {
+			return Config{}, fmt.Errorf("invalid BASE_PATH %q: must be a path starting with /", v)
+		}
+		cfg.BasePath = v
+	}
+
+	switch v := os.Getenv("TRAILING_SLASH"); v {
+	case trailingSlashKeep, trailingSlashStrip, trailingSlashRedirect:
+		cfg.TrailingSlash = v
+	default:
+		return Config{}, fmt.Errorf("invalid TRAILING_SLASH %q: must be strip or redirect", v)
+	}
+
+	var err error
+	if cfg.TrustProxy, err = envBool("TRUST_PROXY", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowReset, err = envBool("ALLOW_RESET", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowReindex, err = envBool("ALLOW_REINDEX", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowExport, err = envBool("ALLOW_EXPORT", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowImport, err = envBool("ALLOW_IMPORT", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowDrain, err = envBool("ALLOW_DRAIN", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowMerge, err = envBool("ALLOW_MERGE", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.AllowSetReadOnly, err = envBool("ALLOW_SET_READ_ONLY", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.ReadOnly, err = envBool("READ_ONLY", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.Debug, err = envBool("DEBUG", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.RequireUserAgent, err = envBool("REQUIRE_USER_AGENT", false); err != nil {
+		return Config{}, err
+	}
+	if cfg.MaxBodyBytes, err = envInt("MAX_BODY_BYTES", cfg.MaxBodyBytes); err != nil {
+		return Config{}, err
+	}
+	saveAttempts, err := envInt("SAVE_ATTEMPTS", int64(cfg.SaveAttempts))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.SaveAttempts = int(saveAttempts)
+	maxNameLength, err := envInt("MAX_NAME_LENGTH", int64(cfg.MaxNameLength))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.MaxNameLength = int(maxNameLength)
+	maxUsers, err := envNonNegativeInt("MAX_USERS", 0)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.MaxUsers = int(maxUsers)
+	maxConcurrent, err := envInt("MAX_CONCURRENT_REQUESTS", int64(cfg.MaxConcurrent))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.MaxConcurrent = int(maxConcurrent)
+	queueSize, err := envInt("WRITE_QUEUE_SIZE", int64(cfg.WriteQueueSize))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.WriteQueueSize = int(queueSize)
+
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"REQUEST_TIMEOUT", &cfg.RequestTimeout},
+		{"READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout},
+		{"READ_TIMEOUT", &cfg.ReadTimeout},
+		{"WRITE_TIMEOUT", &cfg.WriteTimeout},
+		{"IDLE_TIMEOUT", &cfg.IdleTimeout},
+		{"WRITE_QUEUE_TIMEOUT", &cfg.WriteQueueTimeout},
+		{"SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout},
+		{"DRAIN_GRACE_PERIOD", &cfg.DrainGracePeriod},
+		{"IDEMPOTENCY_TTL", &cfg.IdempotencyTTL},
+		{"DEDUP_WINDOW", &cfg.DedupWindow},
+		{"LIST_CACHE_TTL", &cfg.ListCacheTTL},
+		{"CHAOS_DELAY", &cfg.ChaosDelay},
+		{"SAVE_RETRY_DELAY", &cfg.SaveRetryDelay},
+	}
+	for _, d := range durations {
+		if *d.dst, err = envDuration(d.key, *d.dst); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// envInt は環境変数の値を正の整数として返す。未設定の場合はdefを返す。
+func envInt(key string, def int64) (int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", key, v)
+	}
+	return n, nil
+}
+
+// envNonNegativeInt はenvIntと同じく環境変数の値を整数として返す。0を無制限などの意味に使うため、0も受け付ける。
+func envNonNegativeInt(key string, def int64) (int64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, v)
+	}
+	return n, nil
+}
+
+// envDuration は環境変数の値を "5s" のような形式の正の時間として返す。未設定の場合はdefを返す。
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration such as 5s", key, v)
+	}
+	return d, nil
+}
+
+// envBool は環境変数の値を真偽値として返す。未設定の場合はdefを返す。
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", key, v)
+	}
+	return b, nil
+}
+
+// validateTLS は証明書と秘密鍵のファイルが両方とも指定されているか、両方とも指定されていないかを確認する。
+// コマンドラインのフラグで上書きした後にも確認できるよう、LoadConfigとは分けている。
+func (cfg Config) validateTLS() error {
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	return nil
+}
+
+// serve はsrvで待ち受けを始める。証明書と秘密鍵が指定されている場合はHTTPSで、そうでない場合はHTTPで待ち受ける。
+// srvを停止するまで戻らず、ListenAndServeと同じくShutdownによる停止ではhttp.ErrServerClosedを返す。
+func serve(srv *http.Server, cfg Config) error {
+	if cfg.TLSCertFile != "" {
+		return srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return srv.ListenAndServe()
+}
+
+// buildServer はcfg.Addrでhを提供するHTTPサーバを生成する。
+// 低速な接続で接続を占有され続けないよう、設定に従って各種のタイムアウトを設定する。
+func buildServer(cfg Config, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigMaxUsers(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false}, // 0は無制限
+		{"100", 100, false},
+		{"-1", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("MAX_USERS", tt.value)
+			cfg, err := LoadConfig()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadConfig() with MAX_USERS=%q: no error, want one", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() with MAX_USERS=%q: %v", tt.value, err)
+			}
+			if cfg.MaxUsers != tt.want {
+				t.Errorf("LoadConfig() with MAX_USERS=%q: MaxUsers = %d, want %d", tt.value, cfg.MaxUsers, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{"HTTP", "", "", false},
+		{"HTTPS", "cert.pem", "key.pem", false},
+		{"cert only", "cert.pem", "", true},
+		{"key only", "", "key.pem", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Config{TLSCertFile: tt.certFile, TLSKeyFile: tt.keyFile}.validateTLS()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTLS() with cert %q and key %q = %v, want error %t", tt.certFile, tt.keyFile, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeTestCert は127.0.0.1向けの自己署名の証明書と秘密鍵をdirに書き出し、それぞれのパスと証明書を返す。
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// TestEndpointsOverTLS はエンドポイントがHTTPSでも同じように使えることを確かめる。
+func TestEndpointsOverTLS(t *testing.T) {
+	app := NewApp(NewInMemoryStore(""))
+	srv := httptest.NewTLSServer(app.newMux())
+	t.Cleanup(srv.Close)
+	ts := &testServer{Server: srv, t: t, app: app}
+
+	created := ts.CreateUser("alice")
+	if got, ok := ts.GetUser(created.ID); !ok || got.Name != "alice" {
+		t.Errorf("GetUser(%d) over HTTPS = %+v, %t, want alice", created.ID, got, ok)
+	}
+	if res, data := ts.do(http.MethodDelete, userPath(created), ""); res.StatusCode != http.StatusOK || res.TLS == nil {
+		t.Errorf("DELETE %s over HTTPS: status = %d (TLS %t): %s", userPath(created), res.StatusCode, res.TLS != nil, data)
+	}
+}
+
+// TestServeTLS は証明書と秘密鍵を指定した場合に、serveがHTTPSで待ち受けることを確かめる。
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t, t.TempDir())
+	// serveはcfg.Addrで待ち受けるため、空いているポートを調べてから使う
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := Config{Addr: addr, TLSCertFile: certFile, TLSKeyFile: keyFile}
+	srv := buildServer(cfg, NewApp(NewInMemoryStore("")).newMux())
+	served := make(chan error, 1)
+	go func() { served <- serve(srv, cfg) }()
+	defer func() {
+		srv.Close()
+		if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("serve() = %v, want %v", err, http.ErrServerClosed)
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	defer client.CloseIdleConnections()
+	var res *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if res, err = client.Post("https://"+addr+"/users", "application/json", strings.NewReader(`{"name":"alice"}`)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("POST /users over HTTPS: %v", err)
+		}
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("POST /users over HTTPS: status = %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+
+	// HTTPSで待ち受けている間は、平文のHTTPのリクエストを受け付けない
+	res, err = http.Get("http://" + addr + "/users")
+	if err != nil {
+		t.Fatalf("GET /users over plain HTTP: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /users over plain HTTP: status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestLoadConfigCORSAllowedOrigin(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", "*"},
+		{"https://app.example.com", "https://app.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("CORS_ALLOWED_ORIGIN", tt.value)
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.CORSAllowedOrigin != tt.want {
+				t.Errorf("LoadConfig() with CORS_ALLOWED_ORIGIN=%q: CORSAllowedOrigin = %q, want %q", tt.value, cfg.CORSAllowedOrigin, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigAddr(t *testing.T) {
+	tests := []struct {
+		port string
+		want string
+	}{
+		{"", defaultAddr},
+		{"9090", ":9090"}, // ポート番号だけの場合はコロンを補う
+		{":9090", ":9090"},
+		{"127.0.0.1:9090", "127.0.0.1:9090"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			t.Setenv("PORT", tt.port)
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Addr != tt.want {
+				t.Errorf("LoadConfig() with PORT=%q: Addr = %q, want %q", tt.port, cfg.Addr, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigAllowReset(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"true", true, false},
+		{"false", false, false},
+		{"yes", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("ALLOW_RESET", tt.value)
+			cfg, err := LoadConfig()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("LoadConfig() with ALLOW_RESET=%q: error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if cfg.AllowReset != tt.want {
+				t.Errorf("LoadConfig() with ALLOW_RESET=%q: AllowReset = %v, want %v", tt.value, cfg.AllowReset, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigBasePath(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/api/v1", "/api/v1", false},
+		// 末尾のスラッシュは取り除く
+		{"/api/v1/", "/api/v1", false},
+		{"/", "", false},
+		{"api/v1", "", true},
+		{"/api/{version}", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("BASE_PATH", tt.value)
+			cfg, err := LoadConfig()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("LoadConfig() with BASE_PATH=%q: error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if cfg.BasePath != tt.want {
+				t.Errorf("LoadConfig() with BASE_PATH=%q: BasePath = %q, want %q", tt.value, cfg.BasePath, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMaxNameLength(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultMaxNameLength, false},
+		{"64", 64, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"long", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("MAX_NAME_LENGTH", tt.value)
+			cfg, err := LoadConfig()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("LoadConfig() with MAX_NAME_LENGTH=%q: error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if cfg.MaxNameLength != tt.want {
+				t.Errorf("LoadConfig() with MAX_NAME_LENGTH=%q: MaxNameLength = %d, want %d", tt.value, cfg.MaxNameLength, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRequireUserAgent(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", false, false},
+		{"true", true, false},
+		{"false", false, false},
+		{"on", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("REQUIRE_USER_AGENT", tt.value)
+			cfg, err := LoadConfig()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("LoadConfig() with REQUIRE_USER_AGENT=%q: error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if cfg.RequireUserAgent != tt.want {
+				t.Errorf("LoadConfig() with REQUIRE_USER_AGENT=%q: RequireUserAgent = %v, want %v", tt.value, cfg.RequireUserAgent, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildServerTimeouts(t *testing.T) {
+	type timeouts struct {
+		readHeader, read, write, idle time.Duration
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    timeouts
+		wantErr bool
+	}{
+		{"defaults", nil, timeouts{defaultReadHeaderTimeout, defaultReadTimeout, defaultWriteTimeout, defaultIdleTimeout}, false},
+		{"overridden", map[string]string{
+			"READ_HEADER_TIMEOUT": "1s",
+			"READ_TIMEOUT":        "2s",
+			"WRITE_TIMEOUT":       "3s",
+			"IDLE_TIMEOUT":        "4m",
+		}, timeouts{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Minute}, false},
+		{"partly overridden", map[string]string{"WRITE_TIMEOUT": "30s"}, timeouts{defaultReadHeaderTimeout, defaultReadTimeout, 30 * time.Second, defaultIdleTimeout}, false},
+		{"without a unit", map[string]string{"READ_TIMEOUT": "10"}, timeouts{}, true},
+		{"zero", map[string]string{"IDLE_TIMEOUT": "0s"}, timeouts{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := LoadConfig()
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("LoadConfig() with %v: error = %v, want error %v", tt.env, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			srv := buildServer(cfg, http.NotFoundHandler())
+			got := timeouts{srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout}
+			if got != tt.want {
+				t.Errorf("buildServer with %v: timeouts = %+v, want %+v", tt.env, got, tt.want)
+			}
+			if srv.Addr != cfg.Addr {
+				t.Errorf("buildServer: Addr = %q, want %q", srv.Addr, cfg.Addr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	type settings struct {
+		addr           string
+		apiKey         string
+		maxBodyBytes   int64
+		requestTimeout time.Duration
+	}
+	defaults := settings{defaultAddr, "", defaultMaxBodyBytes, defaultRequestTimeout}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    settings
+		wantErr string // エラーのメッセージに含まれる文字列（空の場合はエラーにならないこと）
+	}{
+		{"defaults", nil, defaults, ""},
+		{"overridden", map[string]string{
+			"PORT":            "9090",
+			"API_KEY":         "secret",
+			"MAX_BODY_BYTES":  "2048",
+			"REQUEST_TIMEOUT": "250ms",
+		}, settings{":9090", "secret", 2048, 250 * time.Millisecond}, ""},
+		{"negative timeout", map[string]string{"REQUEST_TIMEOUT": "-1s"}, settings{}, "REQUEST_TIMEOUT"},
+		{"zero body limit", map[string]string{"MAX_BODY_BYTES": "0"}, settings{}, "MAX_BODY_BYTES"},
+		{"non-numeric body limit", map[string]string{"MAX_BODY_BYTES": "1MB"}, settings{}, "MAX_BODY_BYTES"},
+		{"invalid log level", map[string]string{"LOG_LEVEL": "loud"}, settings{}, "LOG_LEVEL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 実行環境の値の影響を受けないよう、既定値を確かめる項目は空にしておく
+			for _, k := range []string{"PORT", "API_KEY", "MAX_BODY_BYTES", "REQUEST_TIMEOUT"} {
+				t.Setenv(k, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := LoadConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() with %v: error = %v, want one mentioning %s", tt.env, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() with %v: %v", tt.env, err)
+			}
+			got := settings{cfg.Addr, cfg.APIKey, cfg.MaxBodyBytes, cfg.RequestTimeout}
+			if got != tt.want {
+				t.Errorf("LoadConfig() with %v = %+v, want %+v", tt.env, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// csvMediaType はCSVのメディアタイプ
+const csvMediaType = "text/csv"
+
+// csvHeader はユーザー一覧のCSVの見出し行
+var csvHeader = []string{"id", "name"}
+
+// writeCSV はusersを見出し行付きのCSVとして書き込む。ユーザーがいない場合も見出し行は書き込む。
+func writeCSV(w http.ResponseWriter, users []User) {
+	w.Header().Set("Content-Type", csvMediaType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, u := range users {
+		id := strconv.Itoa(u.ID)
+		if u.ID == 0 {
+			id = u.UUID
+		}
+		cw.Write([]string{id, u.Name})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		// ヘッダは送信済みのため、エラーレスポンスには切り替えられない
+		log.Printf("failed to write users as CSV: %v", err)
+	}
+}
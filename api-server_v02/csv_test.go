@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGetAllUsersCSV(t *testing.T) {
+	tests := []struct {
+		name   string
+		users  []string
+		path   string
+		accept string
+		want   [][]string
+	}{
+		{"no users", nil, "/users?format=csv", "", [][]string{{"id", "name"}}},
+		{"format=csv", []string{"alice", "bob"}, "/users?format=csv", "", [][]string{{"id", "name"}, {"1", "alice"}, {"2", "bob"}}},
+		{"Accept: text/csv", []string{"alice", "bob"}, "/users", csvMediaType, [][]string{{"id", "name"}, {"1", "alice"}, {"2", "bob"}}},
+		// format=csvはAcceptより優先する
+		{"format=csv overrides Accept", []string{"alice"}, "/users?format=csv", "application/json", [][]string{{"id", "name"}, {"1", "alice"}}},
+		// 区切り文字や引用符を含む名前もそのまま読み戻せる
+		{"quoted names", []string{`Smith, "Al"`, "line\nbreak"}, "/users?format=csv", "", [][]string{{"id", "name"}, {"1", `Smith, "Al"`}, {"2", "line\nbreak"}}},
+		{"paginated", []string{"alice", "bob", "carol"}, "/users?format=csv&offset=1&limit=1", "", [][]string{{"id", "name"}, {"2", "bob"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, name := range tt.users {
+				ts.CreateUser(name)
+			}
+
+			header := http.Header{}
+			if tt.accept != "" {
+				header.Set("Accept", tt.accept)
+			}
+			res, data := ts.doWithHeader(http.MethodGet, tt.path, "", header)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s: status = %d: %s", tt.path, res.StatusCode, data)
+			}
+			if got := res.Header.Get("Content-Type"); got != "text/csv; charset=utf-8" {
+				t.Errorf("GET %s: Content-Type = %q, want text/csv; charset=utf-8", tt.path, got)
+			}
+			rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			if err != nil {
+				t.Fatalf("parsing CSV %q: %v", data, err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("GET %s rows = %q, want %q", tt.path, rows, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetAllUsersCSVUUID はIDの割り当て方がuuidの場合に、CSVのIDの列にUUIDを書くことを確かめる。
+func TestGetAllUsersCSVUUID(t *testing.T) {
+	ts := newTestServer(t, func(a *App) { a.idStrategy = idStrategyUUID })
+	u := ts.CreateUser("alice")
+
+	_, data := ts.do(http.MethodGet, "/users?format=csv", "")
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV %q: %v", data, err)
+	}
+	if want := [][]string{{"id", "name"}, {u.UUID, "alice"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("GET /users?format=csv rows = %q, want %q", rows, want)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+)
+
+// DebugStats はメモリリークなどの調査のための実行時の統計情報を表す構造体。
+type DebugStats struct {
+	Goroutines int    `json:"goroutines"`  // 動いているゴルーチンの数
+	HeapAlloc  uint64 `json:"heap_alloc"`  // ヒープに確保しているバイト数
+	TotalAlloc uint64 `json:"total_alloc"` // 起動してから確保した累計のバイト数
+	NumGC      uint32 `json:"num_gc"`      // GCを実行した回数
+	UserCount  int    `json:"user_count"`  // 削除済みのものを除くユーザーの数
+}
+
+// debugStats は実行時の統計情報を返すエンドポイントのハンドラ
+// 内部の状態を外部に見せないよう、DEBUG=trueの場合だけ登録する
+func (a *App) debugStats(w http.ResponseWriter, r *http.Request) {
+	// ReadMemStatsは一時的に全てのゴルーチンを止めるため、呼び出しはこのエンドポイントに限る
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	writeJSON(w, http.StatusOK, DebugStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  m.HeapAlloc,
+		TotalAlloc: m.TotalAlloc,
+		NumGC:      m.NumGC,
+		UserCount:  a.store.Count(),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestDebugStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		debug      bool
+		wantStatus int
+	}{
+		{"enabled", true, http.StatusOK},
+		// 無効な場合は登録しないため、存在しないパスと同じく404を返す
+		{"disabled", false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.debug = tt.debug })
+			ts.CreateUser("alice")
+			ts.CreateUser("bob")
+			ts.do(http.MethodDelete, "/users/2", "")
+
+			res, data := ts.do(http.MethodGet, "/debug/stats", "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /debug/stats: status = %d, want %d: %s", res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "not_found" {
+					t.Errorf("GET /debug/stats: code = %q, want not_found", got)
+				}
+				return
+			}
+			stats := decodeTestJSON[DebugStats](t, data)
+			if stats.UserCount != 1 {
+				t.Errorf("user_count = %d, want 1", stats.UserCount)
+			}
+			if stats.Goroutines <= 0 || stats.HeapAlloc == 0 || stats.TotalAlloc < stats.HeapAlloc {
+				t.Errorf("GET /debug/stats = %+v, want positive goroutine and allocation counts", stats)
+			}
+			// GCの回数は直前に実行したGCを含む
+			runtime.GC()
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			_, data = ts.do(http.MethodGet, "/debug/stats", "")
+			if got := decodeTestJSON[DebugStats](t, data).NumGC; got < m.NumGC {
+				t.Errorf("num_gc = %d, want at least %d", got, m.NumGC)
+			}
+		})
+	}
+}
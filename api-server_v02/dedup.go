@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// dedupEntry は同じ内容の追加を重複とみなす間、覚えておく追加の結果
+type dedupEntry struct {
+	key     [sha256.Size]byte // 追加したユーザーの内容のハッシュ
+	id      int               // 追加したユーザーのID
+	expires time.Time         // 重複とみなす期限
+}
+
+// dedupCache は追加したユーザーの内容とIDの対応を短い時間だけ覚えておくキャッシュ。
+// 誤ってボタンを2回押したような、Idempotency-Keyを付けずに同じ内容が続けて送られた場合に
+// 2回目を新しい追加ではなく1回目の結果として扱うために使う。
+type dedupCache struct {
+	mu      sync.Mutex // entriesとorderの排他制御のためのmutex
+	window  time.Duration
+	entries map[[sha256.Size]byte]*dedupEntry
+	order   []*dedupEntry // 覚えた追加を期限の早い順に並べたもの（windowが一定のためrecordを呼んだ順）
+}
+
+// newDedupCache は同じ内容の追加をwindowの間重複とみなすdedupCacheを生成する。
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{window: window, entries: map[[sha256.Size]byte]*dedupEntry{}}
+}
+
+// dedupKey は正規化したユーザーの内容から、重複の判定に使うキーを求める。
+func dedupKey(u User) [sha256.Size]byte {
+	data, _ := json.Marshal(u)
+	return sha256.Sum256(data)
+}
+
+// lookup はkeyの内容で期限内に追加したユーザーのIDを返す。ない場合はfalseを返す。
+func (c *dedupCache) lookup(key [sha256.Size]byte) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now())
+	e, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+	return e.id, true
+}
+
+// record はkeyの内容でidのユーザーを追加したことを覚える。
+func (c *dedupCache) record(key [sha256.Size]byte, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &dedupEntry{key: key, id: id, expires: now().Add(c.window)}
+	c.entries[key] = e
+	c.order = append(c.order, e)
+}
+
+// expire は期限がtより前の追加を忘れる。
+// 追加のたびに全ての内容を調べないよう、期限の早い順に調べて期限内のものに達したら止める。
+// c.muをロックした状態で呼び出すこと。
+func (c *dedupCache) expire(t time.Time) {
+	n := 0
+	for _, e := range c.order {
+		if !t.After(e.expires) {
+			break
+		}
+		if c.entries[e.key] == e {
+			delete(c.entries, e.key)
+		}
+		n++
+	}
+	clear(c.order[:n])
+	c.order = c.order[n:]
+}
+
+// clear は覚えている全ての追加を忘れる。
+// リセットやIDの振り直しの後に、覚えているIDが別のユーザーを指さないようにするために呼ぶ。
+func (c *dedupCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	clear(c.order)
+	c.order = nil
+}
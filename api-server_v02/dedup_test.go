@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	const window = time.Minute
+	tests := []struct {
+		name       string
+		between    func(t *testing.T, ts *testServer, clock *time.Time) // 1回目と2回目の追加の間に行う操作
+		wantStatus int
+		wantName   string // 2回目の追加で返るユーザーの名前
+		wantCount  int    // 2回目の追加の後のユーザー数
+	}{
+		{"within window", nil, http.StatusOK, "alice", 1},
+		{"after window", func(t *testing.T, ts *testServer, clock *time.Time) {
+			*clock = clock.Add(window + time.Second)
+			ts.do(http.MethodDelete, "/users/1", "")
+		}, http.StatusCreated, "alice", 1},
+		{"updated since", func(t *testing.T, ts *testServer, clock *time.Time) {
+			ts.do(http.MethodPatch, "/users/1", `{"name":"alice2"}`)
+		}, http.StatusCreated, "alice", 2},
+		{"after reset", func(t *testing.T, ts *testServer, clock *time.Time) {
+			ts.do(http.MethodDelete, "/users", "")
+			ts.CreateUser("bob") // リセット前のaliceと同じIDになる
+		}, http.StatusCreated, "alice", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := time.Now()
+			setTestNow(t, &clock)
+			ts := newTestServer(t, func(a *App) {
+				a.dedup = newDedupCache(window)
+				a.allowReset = true
+			})
+			ts.CreateUser("alice")
+			if tt.between != nil {
+				tt.between(t, ts, &clock)
+			}
+
+			res, data := ts.do(http.MethodPost, "/users", `{"name":"alice"}`)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("second POST /users: status = %d, want %d: %s", res.StatusCode, tt.wantStatus, data)
+			}
+			if u := decodeTestJSON[User](t, data); u.Name != tt.wantName {
+				t.Errorf("second POST /users returned %+v, want a user named %q", u, tt.wantName)
+			}
+			if got := ts.app.store.Count(); got != tt.wantCount {
+				t.Errorf("Count() = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestDedupCacheExpiresInOrder(t *testing.T) {
+	clock := time.Now()
+	setTestNow(t, &clock)
+	c := newDedupCache(time.Minute)
+	for id := 1; id <= 3; id++ {
+		c.record(dedupKey(User{Name: fmt.Sprintf("user%d", id)}), id)
+		clock = clock.Add(20 * time.Second)
+	}
+
+	// 20秒ずつ間を空けて覚えたため、最初の2件だけが期限切れになる
+	clock = clock.Add(25 * time.Second)
+	if _, ok := c.lookup(dedupKey(User{Name: "user3"})); !ok {
+		t.Error("lookup of the last entry within the window: not found")
+	}
+	if got := len(c.order); got != 1 {
+		t.Errorf("%d entries left after expiring the first two, want 1", got)
+	}
+	if _, ok := c.lookup(dedupKey(User{Name: "user1"})); ok {
+		t.Error("lookup of the expired first entry: found")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "net/http"
+
+// drainPath はサーバを切り離し中の状態にするエンドポイントのパス
+// 読み取り専用の間も切り替えられるよう、書き込みの制限の対象から外す
+const drainPath = "/admin/drain"
+
+// healthz はサーバの死活監視用のエンドポイントのハンドラ
+// 負荷が高いときでも素早く応答できるよう、保存先には触れない
+// 切り離し中はロードバランサが振り分け先から外せるよう503を返す
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	if a.draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// drain はサーバを切り離し中の状態にするエンドポイントのハンドラ
+// 処理中のリクエストも新しく届いたリクエストもそのまま処理し、
+// 猶予時間が過ぎた後の停止はmainがa.drainedを受け取って行う
+// 2回目以降の呼び出しは状態を変えずに同じレスポンスを返す
+// 誰でもプロセスを止められないよう、allowDrainが有効な場合（ALLOW_DRAIN=true）だけ受け付ける
+func (a *App) drain(w http.ResponseWriter, r *http.Request) {
+	if !a.allowDrain {
+		writeError(w, http.StatusForbidden, "forbidden", "drain is disabled")
+		return
+	}
+	if a.draining.CompareAndSwap(false, true) {
+		close(a.drained)
+	}
+	writeJSON(w, http.StatusAccepted, HealthResponse{Status: "draining"})
+}
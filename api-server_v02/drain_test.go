@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantStatus int
+		wantBody   string
+	}{
+		{http.MethodGet, http.StatusOK, `{"status":"ok"}`},
+		{http.MethodHead, http.StatusOK, ""},
+		{http.MethodPost, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			ts := newTestServer(t)
+			// ユーザー情報のロックを取らないため、書き込み中で待たされることはない
+			store := ts.app.store.(*InMemoryStore)
+			store.mu.Lock()
+			defer store.mu.Unlock()
+
+			res, data := ts.do(tt.method, "/healthz", "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s /healthz: status = %d, want %d: %s", tt.method, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.wantBody {
+				t.Errorf("%s /healthz = %s, want %s", tt.method, got, tt.wantBody)
+			}
+			if got := res.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("%s /healthz: Content-Type = %q, want application/json", tt.method, got)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowDrain  bool
+		readOnly    bool
+		calls       int // POST /admin/drainを送る回数
+		wantStatus  int
+		wantHealthz int
+	}{
+		{"disabled", false, false, 1, http.StatusForbidden, http.StatusOK},
+		{"enabled", true, false, 1, http.StatusAccepted, http.StatusServiceUnavailable},
+		// 2回目以降も同じレスポンスを返す
+		{"called twice", true, false, 2, http.StatusAccepted, http.StatusServiceUnavailable},
+		// 読み取り専用の間も切り離せる
+		{"read-only", true, true, 1, http.StatusAccepted, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.allowDrain = tt.allowDrain })
+			ts.app.readOnly.Store(tt.readOnly)
+			alice := ts.CreateUser("alice")
+
+			var res *http.Response
+			var data []byte
+			for i := 0; i < tt.calls; i++ {
+				res, data = ts.do(http.MethodPost, drainPath, "")
+			}
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST %s: status = %d, want %d: %s", drainPath, res.StatusCode, tt.wantStatus, data)
+			}
+
+			res, data = ts.do(http.MethodGet, "/healthz", "")
+			if res.StatusCode != tt.wantHealthz {
+				t.Errorf("GET /healthz: status = %d, want %d: %s", res.StatusCode, tt.wantHealthz, data)
+			}
+			select {
+			case <-ts.app.drained:
+				if !tt.allowDrain {
+					t.Error("drained is closed while drain is disabled")
+				}
+			default:
+				if tt.allowDrain {
+					t.Error("drained is not closed after POST " + drainPath)
+				}
+			}
+
+			// 切り離し中も通常のエンドポイントは応答し続ける
+			if got, ok := ts.GetUser(alice.ID); !ok || got.Name != "alice" {
+				t.Errorf("GET %s = %+v, want alice", userPath(alice), got)
+			}
+			if !tt.readOnly {
+				if res, data := ts.do(http.MethodPost, "/users", `{"name":"bob"}`); res.StatusCode != http.StatusCreated {
+					t.Errorf("POST /users: status = %d, want %d: %s", res.StatusCode, http.StatusCreated, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userETag はユーザーのバージョンから W/"3" のような弱いETagを作る。
+// 更新のたびにバージョンが進むため、エンコードせずに内容の変化を表せる。
+// GETで受け取った値をそのままPUTのIf-Matchに送れるよう、parseVersionはこの形式も受け付ける。
+// 削除してもバージョンは変わらないため、削除済みのユーザーには別の値を使う。
+func userETag(u User) string {
+	v := strconv.Itoa(u.Version)
+	if u.Deleted {
+		v += "-deleted"
+	}
+	return `W/"` + v + `"`
+}
+
+// etagMatches はIf-None-Matchヘッダの値にetagが含まれるかを返す。
+// 弱い比較を行うため、W/ の有無は区別しない。
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// errModifiedSince はIf-Unmodified-Sinceで指定された日時より後にユーザーが更新されたことを表すエラー
+var errModifiedSince = errors.New("user has been modified since the If-Unmodified-Since time")
+
+// errPreconditionRequired は既存のユーザーを置き換えるのに、更新の元にしたバージョンが指定されていないことを表すエラー
+var errPreconditionRequired = errors.New("If-Match header or version is required")
+
+// updatePrecondition はPUTでユーザーを置き換える前に確かめる条件を表す構造体。
+type updatePrecondition struct {
+	anyVersion bool      // If-Match: * の場合はtrueで、現在のバージョンによらず置き換える
+	versions   []int     // 更新の元にしたバージョン。現在のバージョンがいずれかと一致する場合に置き換える
+	since      time.Time // If-Unmodified-Sinceの日時
+	checkSince bool      // If-Unmodified-Sinceを指定された場合はtrue
+}
+
+// parseUpdatePrecondition はIf-Matchヘッダ、なければボディのbodyVersionと、If-Unmodified-Sinceヘッダから条件を読み取る。
+// If-Matchは "*" と、カンマ区切りの複数の値も受け付ける。"*" はRFC 9110に従い、現在のユーザーがいれば一致する。
+func parseUpdatePrecondition(r *http.Request, bodyVersion int) (updatePrecondition, error) {
+	var p updatePrecondition
+	p.since, p.checkSince = unmodifiedSince(r)
+	switch v := strings.TrimSpace(r.Header.Get("If-Match")); {
+	case v == "*":
+		p.anyVersion = true
+	case v != "":
+		for _, candidate := range strings.Split(v, ",") {
+			if strings.TrimSpace(candidate) == "" {
+				continue
+			}
+			version, err := parseVersion(candidate)
+			if err != nil {
+				return updatePrecondition{}, err
+			}
+			p.versions = append(p.versions, version)
+		}
+		if len(p.versions) == 0 {
+			return updatePrecondition{}, errors.New("If-Match has no values")
+		}
+	case bodyVersion != 0:
+		p.versions = []int{bodyVersion}
+	}
+	return p, nil
+}
+
+// hasVersion は更新の元にしたバージョンが指定されているかを返す。
+func (p updatePrecondition) hasVersion() bool {
+	return p.anyVersion || len(p.versions) > 0
+}
+
+// check は置き換える前のユーザーcurが条件を満たすかを確かめる。
+// If-Unmodified-Sinceの日時より後に更新されていればerrModifiedSince、バージョンが指定されていなければerrPreconditionRequired、
+// 一致しなければerrVersionMismatchを返す。
+func (p updatePrecondition) check(cur User) error {
+	if p.checkSince && modifiedSince(cur, p.since) {
+		return errModifiedSince
+	}
+	if !p.hasVersion() {
+		return errPreconditionRequired
+	}
+	if !p.anyVersion && !slices.Contains(p.versions, cur.Version) {
+		return errVersionMismatch
+	}
+	return nil
+}
+
+// lastModified はユーザー情報のLast-Modifiedヘッダの値を返す。
+func lastModified(u User) string {
+	return u.UpdatedAt.UTC().Format(http.TimeFormat)
+}
+
+// unmodifiedSince はIf-Unmodified-Sinceヘッダの日時を返す。
+// 指定がない場合や日付として解釈できない場合は、RFC 9110に従いヘッダを無視してfalseを返す。
+func unmodifiedSince(r *http.Request) (time.Time, bool) {
+	t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// modifiedSince はユーザーがtより後に更新されたかを返す。
+// HTTPの日付は秒単位のため、更新日時も秒未満を切り捨てて比べる。
+func modifiedSince(u User, t time.Time) bool {
+	return u.UpdatedAt.Truncate(time.Second).After(t)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConditionalGetUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		update      bool // 送る前にユーザーを更新するか
+		wantStatus  int
+	}{
+		{"first request", "", false, http.StatusOK},
+		{"matching etag", `W/"1"`, false, http.StatusNotModified},
+		{"matching etag without W/", `"1"`, false, http.StatusNotModified},
+		{"matching etag in a list", `W/"5", W/"1"`, false, http.StatusNotModified},
+		{"any", "*", false, http.StatusNotModified},
+		{"stale etag", `W/"1"`, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+			wantETag := `W/"1"`
+			if tt.update {
+				if res, data := ts.do(http.MethodPatch, "/users/1", `{"name":"alice2"}`); res.StatusCode != http.StatusOK {
+					t.Fatalf("PATCH /users/1: status = %d: %s", res.StatusCode, data)
+				}
+				wantETag = `W/"2"`
+			}
+
+			header := http.Header{}
+			if tt.ifNoneMatch != "" {
+				header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			res, data := ts.doWithHeader(http.MethodGet, "/users/1", "", header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /users/1 with If-None-Match %q: status = %d, want %d: %s", tt.ifNoneMatch, res.StatusCode, tt.wantStatus, data)
+			}
+			if got := res.Header.Get("ETag"); got != wantETag {
+				t.Errorf("ETag = %q, want %q", got, wantETag)
+			}
+			if tt.wantStatus == http.StatusNotModified {
+				if len(data) != 0 {
+					t.Errorf("304 response has a body: %s", data)
+				}
+				return
+			}
+			if u := decodeTestJSON[User](t, data); u.ID != 1 || userETag(u) != wantETag {
+				t.Errorf("GET /users/1 = %+v, want the full user matching %s", u, wantETag)
+			}
+		})
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	created := time.Date(2024, 1, 1, 10, 0, 0, 500_000_000, time.UTC)
+	updated := created.Add(time.Hour)
+	tests := []struct {
+		name   string
+		update bool
+		want   string
+	}{
+		{"created", false, "Mon, 01 Jan 2024 10:00:00 GMT"},
+		{"updated", true, "Mon, 01 Jan 2024 11:00:00 GMT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := created
+			setTestNow(t, &clock)
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+			if tt.update {
+				clock = updated
+				if res, data := ts.do(http.MethodPatch, "/users/1", `{"name":"alice2"}`); res.StatusCode != http.StatusOK {
+					t.Fatalf("PATCH /users/1: status = %d: %s", res.StatusCode, data)
+				}
+			}
+
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				res, data := ts.do(method, "/users/1", "")
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("%s /users/1: status = %d: %s", method, res.StatusCode, data)
+				}
+				if got := res.Header.Get("Last-Modified"); got != tt.want {
+					t.Errorf("%s /users/1: Last-Modified = %q, want %q", method, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIfUnmodifiedSince(t *testing.T) {
+	updated := time.Date(2024, 1, 1, 11, 0, 0, 500_000_000, time.UTC)
+	tests := []struct {
+		name       string
+		since      string
+		wantFailed bool
+	}{
+		{"before the update", "Mon, 01 Jan 2024 10:59:59 GMT", true},
+		// HTTPの日付は秒単位のため、Last-Modifiedと同じ値なら更新されていないものとみなす
+		{"same as Last-Modified", "Mon, 01 Jan 2024 11:00:00 GMT", false},
+		{"after the update", "Tue, 02 Jan 2024 00:00:00 GMT", false},
+		// 日付として解釈できない値は無視する
+		{"invalid date", "yesterday", false},
+	}
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		for _, tt := range tests {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				clock := updated.Add(-time.Hour)
+				setTestNow(t, &clock)
+				ts := newTestServer(t)
+				ts.CreateUser("alice")
+				clock = updated
+				if res, data := ts.do(http.MethodPatch, "/users/1", `{"name":"alice2"}`); res.StatusCode != http.StatusOK {
+					t.Fatalf("PATCH /users/1: status = %d: %s", res.StatusCode, data)
+				}
+
+				body := ""
+				if method == http.MethodPut {
+					body = `{"name":"alice3","version":2}`
+				}
+				res, data := ts.doWithHeader(method, "/users/1", body, http.Header{"If-Unmodified-Since": {tt.since}})
+				if tt.wantFailed {
+					if res.StatusCode != http.StatusPreconditionFailed {
+						t.Fatalf("%s /users/1 with If-Unmodified-Since %q: status = %d, want 412: %s", method, tt.since, res.StatusCode, data)
+					}
+					if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "precondition_failed" {
+						t.Errorf("%s /users/1: code = %q, want precondition_failed", method, got)
+					}
+					if u, ok := ts.GetUser(1); !ok || u.Name != "alice2" {
+						t.Errorf("user 1 after a failed %s = %+v (found %v), want it unchanged", method, u, ok)
+					}
+					return
+				}
+				if res.StatusCode != http.StatusOK {
+					t.Errorf("%s /users/1 with If-Unmodified-Since %q: status = %d, want 200: %s", method, tt.since, res.StatusCode, data)
+				}
+			})
+		}
+	}
+}
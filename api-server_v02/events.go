@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventsPath はユーザーの追加をServer-Sent Eventsで通知するエンドポイントのパス
+// 接続を開いたままにするため、処理時間の制限（withTimeout）の対象から外す
+const eventsPath = "/events"
+
+// eventsKeepAlive は通知がない間も接続を保つため、コメント行を送る間隔
+// プロキシなどが無通信の接続を切らないよう、一般的なアイドルタイムアウトより短くする
+const eventsKeepAlive = 15 * time.Second
+
+// eventsBuffer は購読者ごとに送り待ちにしておける通知の数
+// 受け取りが追いつかない購読者のためにユーザーの追加を待たせないよう、あふれた通知は捨てる
+const eventsBuffer = 16
+
+// eventBroker はユーザーの追加を、GET /eventsで接続しているクライアントに配る仕組み。
+// Appのhookとして登録し、接続ごとに購読を登録・解除する。
+type eventBroker struct {
+	mu     sync.Mutex // subsとclosedの排他制御のためのmutex
+	subs   map[chan User]struct{}
+	closed bool // サーバの停止のため、全ての購読を終えたか
+}
+
+// newEventBroker は購読者のいないeventBrokerを生成する。
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: map[chan User]struct{}{}}
+}
+
+// subscribe は通知を受け取るチャネルを登録して返す。
+// 受け取りを終えるときは、返した関数を呼び出して登録を解除する。
+// 停止後に呼び出した場合や停止した場合は、チャネルを閉じて終わりを伝える。
+func (b *eventBroker) subscribe() (<-chan User, func()) {
+	ch := make(chan User, eventsBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// hook はユーザーが追加されたことを全ての購読者に通知するUserHook。
+func (b *eventBroker) hook(event string, u User) {
+	if event != EventUserCreated {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- u:
+		default:
+		}
+	}
+}
+
+// close は全ての購読を終え、以降の購読も受け付けない。
+// 接続を開いたままのクライアントがサーバの停止を妨げないよう、停止の開始時に呼び出す。
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// streamEvents はユーザーが追加されるたびに、そのユーザーのJSONをServer-Sent Eventsで送るエンドポイントのハンドラ
+// クライアントが切断するか、サーバが停止を始めるまで接続を開いたままにする
+func (a *App) streamEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// 全体の書き込みのタイムアウト（WRITE_TIMEOUT）で接続が切られないようにする
+	// 解除できないResponseWriterの場合でも、タイムアウトまでは通知を送れる
+	rc.SetWriteDeadline(time.Time{})
+
+	users, unsubscribe := a.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("failed to start event stream: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case u, ok := <-users:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(a.publicUser(u))
+			if err != nil {
+				log.Printf("failed to encode event for user %d: %v", u.ID, err)
+				continue
+			}
+			// イベント名を付けず、ブラウザのEventSourceのonmessageで受け取れるようにする
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
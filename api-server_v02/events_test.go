@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openEventStream はGET /eventsの接続を開き、レスポンスを返す。
+// レスポンスのヘッダを受け取った時点で購読は登録済みになっている。
+func openEventStream(ctx context.Context, t *testing.T, ts *testServer) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+eventsPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status = %d", eventsPath, res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("GET %s: Content-Type = %q, want text/event-stream", eventsPath, got)
+	}
+	return res
+}
+
+// readEvent はストリームから次のdata行を読み、ユーザーとしてデコードする。
+func readEvent(t *testing.T, r *bufio.Reader) User {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			return decodeTestJSON[User](t, []byte(data))
+		}
+	}
+}
+
+// subscriberCount はbの購読者の数を返す。
+func (b *eventBroker) subscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func TestStreamEvents(t *testing.T) {
+	ts := newTestServer(t)
+	events := bufio.NewReader(openEventStream(context.Background(), t, ts).Body)
+
+	tests := []struct {
+		name  string
+		write func() User // 通知されるはずのユーザーを返す
+	}{
+		{"create", func() User { return ts.CreateUser("alice") }},
+		// 追加以外の変更は通知しないため、次に届くのは後から追加したユーザー
+		{"delete then create", func() User {
+			if res, data := ts.do(http.MethodDelete, "/users/1", ""); res.StatusCode != http.StatusOK {
+				t.Fatalf("DELETE /users/1: status = %d: %s", res.StatusCode, data)
+			}
+			return ts.CreateUser("bob")
+		}},
+		{"bulk", func() User {
+			res, data := ts.do(http.MethodPost, "/users/bulk", `[{"name":"carol"}]`)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("POST /users/bulk: status = %d: %s", res.StatusCode, data)
+			}
+			return decodeTestJSON[[]User](t, data)[0]
+		}},
+	}
+	for _, tt := range tests {
+		want := tt.write()
+		if got := readEvent(t, events); got.ID != want.ID || got.Name != want.Name {
+			t.Errorf("%s: event = %+v, want %+v", tt.name, got, want)
+		}
+	}
+}
+
+func TestStreamEventsStops(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(ts *testServer, cancel context.CancelFunc)
+	}{
+		{"client disconnects", func(ts *testServer, cancel context.CancelFunc) { cancel() }},
+		{"server shuts down", func(ts *testServer, cancel context.CancelFunc) { ts.app.events.close() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			res := openEventStream(ctx, t, ts)
+			if got := ts.app.events.subscriberCount(); got != 1 {
+				t.Fatalf("subscribers after connecting = %d, want 1", got)
+			}
+
+			tt.stop(ts, cancel)
+			// 購読を解除してハンドラが戻り、ストリームが終わる
+			io.Copy(io.Discard, res.Body)
+			for deadline := time.Now().Add(5 * time.Second); ts.app.events.subscriberCount() != 0; time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("subscription was not removed after the stream stopped")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// userFields は?fieldsで指定できる、UserのJSONでのフィールド名
+var userFields = jsonFieldNames(reflect.TypeOf(User{}))
+
+// jsonFieldNames は構造体の型tのJSONタグからフィールド名を宣言順に返す。
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ProjectedUsersResponse は?fieldsを指定したユーザー一覧取得のレスポンスを表す構造体。
+// 項目はUsersResponseと同じで、各ユーザーは指定されたフィールドだけを持つ。
+type ProjectedUsersResponse struct {
+	Total      int              `json:"total"`
+	Users      []map[string]any `json:"users"`
+	NextCursor *string          `json:"next_cursor,omitempty"`
+}
+
+// parseFields は?fields=id,nameのようにカンマ区切りで指定された、返すフィールドの名前を返す。
+// 指定がない場合は全てのフィールドを返すことを表すnilを返す。
+// 指定の誤りに気付けるよう、存在しないフィールドの名前はエラーとする。
+func parseFields(r *http.Request) ([]string, error) {
+	if !r.URL.Query().Has("fields") {
+		return nil, nil
+	}
+	errInvalid := fmt.Errorf("fields must be a comma-separated list of %s", strings.Join(userFields, ", "))
+	var fields []string
+	for _, f := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !slices.Contains(userFields, f) {
+			return nil, errInvalid
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, errInvalid
+	}
+	return fields, nil
+}
+
+// projectUser はuのうちfieldsのフィールドだけを持つマップを返す。
+// 値の表し方を揃えるため、一度JSONにしてから取り出す。omitemptyで省略される値は含めない。
+func projectUser(u User, fields []string) map[string]any {
+	data, _ := json.Marshal(u)
+	var all map[string]json.RawMessage
+	json.Unmarshal(data, &all)
+	projected := make(map[string]any, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			projected[f] = v
+		}
+	}
+	return projected
+}
+
+// projectUsers はusersの各ユーザーをprojectUserでfieldsのフィールドだけにしたものを返す。
+func projectUsers(users []User, fields []string) []map[string]any {
+	projected := make([]map[string]any, len(users))
+	for i, u := range users {
+		projected[i] = projectUser(u, fields)
+	}
+	return projected
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"?fields=id", []string{"id"}, false},
+		{"?fields=id,name", []string{"id", "name"}, false},
+		{"?fields=name,%20id,", []string{"name", "id"}, false},
+		// 存在しないフィールドは指定の誤りとして断る
+		{"?fields=id,password", nil, true},
+		{"?fields=", nil, true},
+		{"?fields=,", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFields(httptest.NewRequest(http.MethodGet, "/users"+tt.query, nil))
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFields(%q) = %q, %v, want %q (error %v)", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// sortedKeys はmのキーを昇順に並べて返す。
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// TestUserFields は1人の取得と一覧の取得のどちらも、?fieldsで指定したフィールドだけを返すことを確かめる。
+func TestUserFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantKeys   []string // 返すフィールド（nilの場合は全てのフィールドを返すこと）
+	}{
+		{"no fields", "", http.StatusOK, nil},
+		{"single field", "?fields=name", http.StatusOK, []string{"name"}},
+		{"multiple fields", "?fields=id,name,email", http.StatusOK, []string{"email", "id", "name"}},
+		// omitemptyで省略される値は指定しても含めない
+		{"omitted field", "?fields=id,deleted", http.StatusOK, []string{"id"}},
+		{"unknown field", "?fields=id,password", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			u := ts.CreateUser("alice")
+			ts.do(http.MethodPatch, userPath(u), `{"email":"alice@example.com"}`)
+			_, data := ts.do(http.MethodGet, userPath(u), "")
+			full := decodeTestJSON[map[string]any](t, data)
+			want := tt.wantKeys
+			if want == nil {
+				want = sortedKeys(full)
+			}
+
+			for _, path := range []string{userPath(u) + tt.query, "/users" + tt.query} {
+				res, data := ts.do(http.MethodGet, path, "")
+				if res.StatusCode != tt.wantStatus {
+					t.Fatalf("GET %s: status = %d, want %d: %s", path, res.StatusCode, tt.wantStatus, data)
+				}
+				if tt.wantStatus != http.StatusOK {
+					if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "invalid_parameter" {
+						t.Errorf("GET %s: code = %q, want invalid_parameter", path, got)
+					}
+					continue
+				}
+
+				var got map[string]any
+				if path == userPath(u)+tt.query {
+					got = decodeTestJSON[map[string]any](t, data)
+				} else {
+					users := decodeTestJSON[struct{ Users []map[string]any }](t, data).Users
+					if len(users) != 1 {
+						t.Fatalf("GET %s returned %d users, want 1", path, len(users))
+					}
+					got = users[0]
+				}
+				if keys := sortedKeys(got); !slices.Equal(keys, want) {
+					t.Errorf("GET %s fields = %q, want %q", path, keys, want)
+				}
+				for k, v := range got {
+					if !reflect.DeepEqual(v, full[k]) {
+						t.Errorf("GET %s %s = %v, want %v", path, k, v, full[k])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// withGzip はクライアントがgzipに対応している場合にレスポンスを圧縮するミドルウェア
+// HEADはボディを返さないため圧縮しない
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accept-Encodingによって応答が変わることをキャッシュに伝える
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip はAccept-Encodingヘッダの値がgzipを許可しているかを返す。
+// "gzip;q=0" のように明示的に拒否されている場合はfalseを返す。
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter は書き込まれたボディをgzipで圧縮するResponseWriter
+// 空でないボディが書き込まれるまでステータスコードの送信を遅らせ、そのときに圧縮するかを決める
+// そのため、Prefer: return=minimalの201のようなボディのない応答にはContent-Encodingを付けない
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	status      int  // ハンドラが書き込んだステータスコード
+	wroteHeader bool // ハンドラがステータスコードを書き込んだか
+	sentHeader  bool // 包んでいるResponseWriterにステータスコードを送ったか
+}
+
+// WriteHeader はステータスコードを覚えておき、ボディが書き込まれるか応答を終えるときに送る
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	gw.status = code
+}
+
+// sendHeader は圧縮するかを決めてから、覚えておいたステータスコードを送る
+// ハンドラが既にContent-Encodingを設定している場合は二重に圧縮しない
+func (gw *gzipResponseWriter) sendHeader(compress bool) {
+	if gw.sentHeader {
+		return
+	}
+	gw.sentHeader = true
+
+	h := gw.Header()
+	if compress && h.Get("Content-Encoding") == "" && gw.status != http.StatusNoContent && gw.status != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		// 圧縮後のサイズは元のContent-Lengthと異なる
+		h.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+}
+
+// Write はボディを圧縮して書き込む。空のボディではステータスコードを送らない
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if len(b) == 0 && !gw.sentHeader {
+		return 0, nil
+	}
+	gw.sendHeader(true)
+	if gw.gz == nil {
+		return gw.ResponseWriter.Write(b)
+	}
+	return gw.gz.Write(b)
+}
+
+// Flush は圧縮途中のデータを書き出してからクライアントに送る
+// 包んでいるResponseWriterがFlushを持たない場合も、Unwrapをたどって送り出す
+// ボディより先にFlushする場合は、続けてボディを送るものとして圧縮を始める
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	gw.sendHeader(true)
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap はhttp.ResponseControllerが書き込みの期限の設定などを元のResponseWriterに委ねられるよう、元のResponseWriterを返す
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// close は圧縮を終えて残りのデータを書き出す
+// ボディを書き込まなかった場合は、覚えておいたステータスコードを圧縮せずに送る
+func (gw *gzipResponseWriter) close() {
+	if gw.wroteHeader {
+		gw.sendHeader(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+	}
+}
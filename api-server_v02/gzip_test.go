@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"deflate, br", false},
+		{"identity", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	// withGzipはmainでハンドラの外側に重ねるため、テストでも同じように重ねる
+	app := NewApp(NewInMemoryStore(""))
+	srv := httptest.NewServer(withGzip(app.newMux()))
+	t.Cleanup(srv.Close)
+	// クライアントが自動でgzipを要求して展開しないよう、Accept-Encodingはテストで指定する
+	srv.Client().Transport.(*http.Transport).DisableCompression = true
+	ts := &testServer{Server: srv, t: t, app: app}
+	for i := range 20 {
+		ts.CreateUser(fmt.Sprintf("user%d", i))
+	}
+	_, plain := ts.do(http.MethodGet, "/users", "")
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		header         http.Header
+		wantStatus     int
+		wantCompressed bool
+	}{
+		{"gzip", http.MethodGet, "/users", http.Header{"Accept-Encoding": {"gzip"}}, http.StatusOK, true},
+		{"gzip among others", http.MethodGet, "/users", http.Header{"Accept-Encoding": {"br, gzip;q=0.8"}}, http.StatusOK, true},
+		{"no header", http.MethodGet, "/users", nil, http.StatusOK, false},
+		{"gzip refused", http.MethodGet, "/users", http.Header{"Accept-Encoding": {"gzip;q=0"}}, http.StatusOK, false},
+		{"head", http.MethodHead, "/users", http.Header{"Accept-Encoding": {"gzip"}}, http.StatusOK, false},
+		// ボディのない応答にはContent-Encodingを付けない
+		{"empty body", http.MethodPost, "/users", http.Header{"Accept-Encoding": {"gzip"}, "Prefer": {"return=minimal"}}, http.StatusCreated, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := ""
+			if tt.method == http.MethodPost {
+				body = `{"name":"newcomer ` + tt.name + `"}`
+			}
+			res, data := ts.doWithHeader(tt.method, tt.path, body, tt.header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			if got := res.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			gotEncoding := res.Header.Get("Content-Encoding")
+			if !tt.wantCompressed {
+				if gotEncoding != "" {
+					t.Errorf("Content-Encoding = %q, want none", gotEncoding)
+				}
+				if tt.method == http.MethodGet && !bytes.Equal(data, plain) {
+					t.Errorf("uncompressed body = %s, want %s", data, plain)
+				}
+				return
+			}
+			if gotEncoding != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want gzip", gotEncoding)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("reading gzip body: %v", err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("decompressing body: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("decompressed body = %s, want %s", got, plain)
+			}
+			if len(data) >= len(plain) {
+				t.Errorf("compressed body is %d bytes, want fewer than %d", len(data), len(plain))
+			}
+		})
+	}
+}
+
+// TestWithGzipAlreadyEncoded はハンドラが既に圧縮したボディを二重に圧縮しないことを確かめる。
+func TestWithGzipAlreadyEncoded(t *testing.T) {
+	var encoded bytes.Buffer
+	zw := gzip.NewWriter(&encoded)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	h := withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(encoded.Bytes())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if !bytes.Equal(rec.Body.Bytes(), encoded.Bytes()) {
+		t.Errorf("body = %x, want the handler's gzip body %x unchanged", rec.Body.Bytes(), encoded.Bytes())
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testServer はテストごとに空のメモリ上の保存先を使うAppをhttptestで起動したサーバ
+type testServer struct {
+	*httptest.Server
+	t   *testing.T
+	app *App
+}
+
+// newTestServer はファイルに書き出さない空の保存先でAppを起動する。サーバはテストの終了時に停止する。
+// configureを指定した場合は、ハンドラを登録する前にAppの設定を変えられる。
+func newTestServer(t *testing.T, configure ...func(a *App)) *testServer {
+	t.Helper()
+	app := NewApp(NewInMemoryStore(""))
+	for _, f := range configure {
+		f(app)
+	}
+	srv := httptest.NewServer(app.newMux())
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, t: t, app: app}
+}
+
+// do はpathにリクエストを送り、レスポンスとボディを返す。
+// bodyが空でない場合はJSONとして送る。送れなかった場合はテストを失敗させる。
+func (ts *testServer) do(method, path, body string) (*http.Response, []byte) {
+	ts.t.Helper()
+	res, data, err := ts.send(method, path, body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return res, data
+}
+
+// doWithHeader はdoと同じくリクエストを送る。headerのヘッダも付けて送る。
+func (ts *testServer) doWithHeader(method, path, body string, header http.Header) (*http.Response, []byte) {
+	ts.t.Helper()
+	res, data, err := ts.sendWithHeader(method, path, body, header)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return res, data
+}
+
+// send はdoと同じくリクエストを送り、送れなかった場合はエラーを返す。
+// t.Fatalを呼べない、テストのゴルーチン以外から送る場合に使う。
+func (ts *testServer) send(method, path, body string) (*http.Response, []byte, error) {
+	return ts.sendWithHeader(method, path, body, nil)
+}
+
+// sendWithHeader はsendと同じくリクエストを送る。headerのヘッダも付けて送る。
+func (ts *testServer) sendWithHeader(method, path, body string, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating %s %s: %w", method, path, err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s %s: %w", method, path, err)
+	}
+	return res, data, nil
+}
+
+// CreateUser はnameのユーザーを追加し、追加されたユーザーを返す。201以外の場合はテストを失敗させる。
+func (ts *testServer) CreateUser(name string) User {
+	ts.t.Helper()
+	body, _ := json.Marshal(User{Name: name})
+	res, data := ts.do(http.MethodPost, "/users", string(body))
+	if res.StatusCode != http.StatusCreated {
+		ts.t.Fatalf("POST /users %q: status = %d, want %d: %s", name, res.StatusCode, http.StatusCreated, data)
+	}
+	return decodeTestJSON[User](ts.t, data)
+}
+
+// GetUser はidのユーザーを返す。存在しない場合はfalseを返し、200と404以外の場合はテストを失敗させる。
+func (ts *testServer) GetUser(id int) (User, bool) {
+	ts.t.Helper()
+	res, data := ts.do(http.MethodGet, fmt.Sprintf("/users/%d", id), "")
+	switch res.StatusCode {
+	case http.StatusOK:
+		return decodeTestJSON[User](ts.t, data), true
+	case http.StatusNotFound:
+		return User{}, false
+	}
+	ts.t.Fatalf("GET /users/%d: status = %d: %s", id, res.StatusCode, data)
+	return User{}, false
+}
+
+// userPath はuを取得するパスを返す。UUIDを割り当てた場合はUUIDを使う。
+func userPath(u User) string {
+	if u.UUID != "" {
+		return "/users/" + u.UUID
+	}
+	return "/users/" + strconv.Itoa(u.ID)
+}
+
+// decodeTestJSON はdataをTとしてデコードする。デコードできない場合はテストを失敗させる。
+func decodeTestJSON[T any](t *testing.T, data []byte) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return v
+}
+
+func TestCreateThenGetUser(t *testing.T) {
+	ts := newTestServer(t)
+
+	created := ts.CreateUser("alice")
+	got, ok := ts.GetUser(created.ID)
+	if !ok {
+		t.Fatalf("GetUser(%d): not found", created.ID)
+	}
+	if got.ID != created.ID || got.Name != "alice" || got.Version != 1 {
+		t.Errorf("GetUser(%d) = %+v, want the created user %+v", created.ID, got, created)
+	}
+	if _, ok := ts.GetUser(created.ID + 1); ok {
+		t.Errorf("GetUser(%d) found a user that was never created", created.ID+1)
+	}
+}
+
+// TestAppsAreIndependent は同じプロセスで起動した2つのAppが状態を共有しないことを確かめる。
+func TestAppsAreIndependent(t *testing.T) {
+	a, b := newTestServer(t), newTestServer(t)
+	a.CreateUser("alice")
+	a.CreateUser("bob")
+
+	tests := []struct {
+		name string
+		ts   *testServer
+		want int // ユーザー数
+	}{
+		{"first app", a, 2},
+		{"second app", b, 0},
+	}
+	for _, tt := range tests {
+		res, data := tt.ts.do(http.MethodGet, "/users/count", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: GET /users/count: status = %d: %s", tt.name, res.StatusCode, data)
+		}
+		if got := decodeTestJSON[CountResponse](t, data).Count; got != tt.want {
+			t.Errorf("%s: count = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// IDの採番もAppごとに1から始まる
+	if u := b.CreateUser("alice"); u.ID != 1 {
+		t.Errorf("first user in the second app got id %d, want 1", u.ID)
+	}
+	if _, ok := a.GetUser(3); ok {
+		t.Error("creating a user in the second app added it to the first")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+)
+
+// ユーザー情報の変更を表すイベントの種類
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// UserHook はユーザー情報が変更されたときに呼び出される関数。
+// eventは変更の種類、uは変更後（削除の場合は削除前）のユーザー情報。
+type UserHook func(event string, u User)
+
+// OnUserEvent はユーザー情報が変更されたときに呼び出すhookを登録する。
+// hookはリクエストを処理するゴルーチンで呼び出されるため、時間のかかる処理はhookの中で別のゴルーチンに任せること。
+func (a *App) OnUserEvent(hook UserHook) {
+	a.hooksMu.Lock()
+	defer a.hooksMu.Unlock()
+	a.hooks = append(a.hooks, hook)
+}
+
+// emit は登録されている全てのhookにイベントを通知する。
+// 保存先の操作が完了してから呼び出すため、hookから保存先を操作してもデッドロックしない。
+func (a *App) emit(event string, u User) {
+	a.hooksMu.RLock()
+	hooks := a.hooks
+	a.hooksMu.RUnlock()
+	for _, hook := range hooks {
+		runHook(hook, event, u)
+	}
+}
+
+// runHook はhookを呼び出す。hookがpanicしてもリクエストの処理や他のhookに影響しないよう回復する。
+func runHook(hook UserHook, event string, u User) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("panic in %s hook for user %d: %v\n%s", event, u.ID, p, debug.Stack())
+		}
+	}()
+	hook(event, u)
+}
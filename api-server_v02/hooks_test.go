@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// hookCall はhookが受け取ったイベントとユーザー
+type hookCall struct {
+	event string
+	id    int
+	name  string
+}
+
+// recordHook はhookの呼び出しを記録するUserHookと、記録を返す関数を返す。
+func recordHook() (UserHook, func() []hookCall) {
+	var mu sync.Mutex
+	var calls []hookCall
+	hook := func(event string, u User) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, hookCall{event, u.ID, u.Name})
+	}
+	return hook, func() []hookCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func TestOnUserEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   []hookCall
+	}{
+		{"create", http.MethodPost, "/users", `{"name":"bob"}`, []hookCall{{EventUserCreated, 2, "bob"}}},
+		{"bulk create", http.MethodPost, "/users/bulk", `[{"name":"bob"},{"name":"carol"}]`, []hookCall{
+			{EventUserCreated, 2, "bob"},
+			{EventUserCreated, 3, "carol"},
+		}},
+		{"update", http.MethodPut, "/users/1", `{"name":"alice2","version":1}`, []hookCall{{EventUserUpdated, 1, "alice2"}}},
+		{"patch", http.MethodPatch, "/users/1", `{"name":"alice2"}`, []hookCall{{EventUserUpdated, 1, "alice2"}}},
+		// 削除の場合は削除前のユーザー情報を渡す
+		{"delete", http.MethodDelete, "/users/1", "", []hookCall{{EventUserDeleted, 1, "alice"}}},
+		// 失敗した操作ではhookを呼ばない
+		{"failed create", http.MethodPost, "/users", `{"name":""}`, nil},
+		{"duplicate create", http.MethodPost, "/users", `{"name":"alice"}`, nil},
+		{"delete missing", http.MethodDelete, "/users/9", "", nil},
+		{"read", http.MethodGet, "/users/1", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+			hook, calls := recordHook()
+			ts.app.OnUserEvent(hook)
+
+			ts.do(tt.method, tt.path, tt.body)
+			if got := calls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s %s %s: hook calls = %v, want %v", tt.method, tt.path, tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOnUserEventHooksAreIsolated はhookのpanicで他のhookやリクエストの処理が止まらず、
+// hookの中から保存先を操作してもデッドロックしないことを確かめる。
+func TestOnUserEventHooksAreIsolated(t *testing.T) {
+	ts := newTestServer(t)
+	var seen []int
+	ts.app.OnUserEvent(func(event string, u User) { panic("hook failed") })
+	ts.app.OnUserEvent(func(event string, u User) {
+		// hookは保存先のロックを解放した後に呼ばれる
+		if got, ok := ts.app.store.Get(u.ID); ok {
+			seen = append(seen, got.ID)
+		}
+	})
+	hook, calls := recordHook()
+	ts.app.OnUserEvent(hook)
+
+	u := ts.CreateUser("alice")
+	if want := []hookCall{{EventUserCreated, u.ID, "alice"}}; !reflect.DeepEqual(calls(), want) {
+		t.Errorf("hook calls after a panicking hook = %v, want %v", calls(), want)
+	}
+	if want := []int{u.ID}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("hook read users %v from the store, want %v", seen, want)
+	}
+	if res, data := ts.do(http.MethodGet, "/healthz", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("GET /healthz after a panicking hook: status = %d: %s", res.StatusCode, data)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// IDの割り当て方（ID_STRATEGY）
+// sequentialは連番の整数IDだけを割り当て、uuidは連番のIDに加えてランダムなUUIDを割り当てる。
+// uuidの場合は件数を推測されないよう、レスポンスに整数のIDを含めず、取得や更新もUUIDでだけ受け付ける。
+const (
+	idStrategySequential = "sequential"
+	idStrategyUUID       = "uuid"
+)
+
+// newUUID はランダムなUUID（バージョン4）を生成する。
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/randが失敗するのはOSの乱数源が使えない場合だけで、続行できない
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // バージョン4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122のバリアント
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// isUUID はsが "xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx" の形式のUUIDかを返す。
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// assignUUID はIDの割り当て方がuuidの場合に新しいUUIDをuに設定する。
+// クライアントがボディで指定したUUIDは使わない。
+func (a *App) assignUUID(u *User) {
+	u.UUID = ""
+	if a.idStrategy == idStrategyUUID {
+		u.UUID = newUUID()
+	}
+}
+
+// publicUser はレスポンスとして返すuを返す。
+// IDの割り当て方がuuidの場合は、連番から件数を推測されないよう整数のIDを取り除く。
+func (a *App) publicUser(u User) User {
+	if a.idStrategy == idStrategyUUID {
+		u.ID = 0
+	}
+	return u
+}
+
+// publicUsers はusersの各ユーザーをpublicUserにしたものを返す。usersは書き換えない。
+func (a *App) publicUsers(users []User) []User {
+	if a.idStrategy != idStrategyUUID {
+		return users
+	}
+	public := make([]User, len(users))
+	for i, u := range users {
+		public[i] = a.publicUser(u)
+	}
+	return public
+}
+
+// errUUIDRequired はIDの割り当て方がuuidの場合に、整数のIDを指定されたことを表す。
+var errUUIDRequired = errors.New("id must be a UUID")
+
+// pathID はパスパラメータのidをユーザーのIDとして解釈する。
+// 整数の場合はそのままIDとし、UUIDの場合は一致するユーザーのIDを返す。
+// UUIDは大文字でも受け付け、割り当てた小文字のUUIDと比べる。
+// UUIDに一致するユーザーがいない場合はErrUserNotFoundを、
+// IDの割り当て方がuuidで整数を指定された場合はerrUUIDRequiredを返す。
+func (a *App) pathID(r *http.Request) (int, error) {
+	return a.resolveID(r.PathValue("id"))
+}
+
+// resolveID はvをpathIDと同じく整数のIDかUUIDとして解釈し、ユーザーのIDを返す。
+func (a *App) resolveID(v string) (int, error) {
+	if isUUID(v) {
+		id, ok := a.store.IDForUUID(strings.ToLower(v))
+		if !ok {
+			return 0, ErrUserNotFound
+		}
+		return id, nil
+	}
+	if a.idStrategy == idStrategyUUID {
+		return 0, errUUIDRequired
+	}
+	return strconv.Atoi(v)
+}
+
+// userID はpathIDでIDを取得し、取得できない場合はエラーレスポンスを書き込んでfalseを返す。
+// 数値としてもUUIDとしても解釈できないIDは、存在しないユーザーと区別して400を返す。
+func (a *App) userID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := a.pathID(r)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "User not found")
+		return 0, false
+	case errors.Is(err, errUUIDRequired):
+		writeError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return 0, false
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "invalid_id", "id must be an integer or a UUID")
+		return 0, false
+	}
+	return id, true
+}
+
+// userLocation はユーザーを取得するためのパスを返す。UUIDを割り当てた場合はUUIDを使う。
+// BASE_PATHの下で提供している場合は、その先頭部分を付ける。
+func userLocation(r *http.Request, u User) string {
+	if u.UUID != "" {
+		return basePath(r) + "/users/" + u.UUID
+	}
+	return basePath(r) + "/users/" + strconv.Itoa(u.ID)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestUUIDModeHidesIntegerIDs はIDの割り当て方がuuidの場合に、整数のIDを受け付けず、レスポンスにも含めないことを確かめる。
+func TestUUIDModeHidesIntegerIDs(t *testing.T) {
+	ts := newTestServer(t, func(a *App) {
+		a.idStrategy = idStrategyUUID
+		withBackup(a)
+	})
+	var users []User
+	for _, name := range []string{"alice", "bob", "carol"} {
+		users = append(users, ts.CreateUser(name))
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"get by integer id", "/users/1", http.StatusBadRequest},
+		{"get by uuid", userPath(users[0]), http.StatusOK},
+		{"get by upper-case uuid", "/users/" + strings.ToUpper(users[0].UUID), http.StatusOK},
+		{"batch", "/users/batch?ids=1,2", http.StatusBadRequest},
+		{"search by id_min", "/users/search?id_min=1", http.StatusBadRequest},
+		{"search by id_max", "/users/search?id_max=2", http.StatusBadRequest},
+		{"search by name", "/users/search?name=a", http.StatusOK},
+		{"integer cursor", "/users?cursor=" + base64.RawURLEncoding.EncodeToString([]byte("1")), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, data := ts.do(http.MethodGet, tt.path, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s: status = %d, want %d: %s", tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			if strings.Contains(string(data), `"id":`) {
+				t.Errorf("GET %s returned an integer id: %s", tt.path, data)
+			}
+		})
+	}
+
+	t.Run("cursor", func(t *testing.T) {
+		var names []string
+		for cursor, pages := "", 0; pages == 0 || cursor != ""; pages++ {
+			res, data := ts.do(http.MethodGet, "/users?limit=2&cursor="+cursor, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users?cursor=%s: status = %d: %s", cursor, res.StatusCode, data)
+			}
+			page := decodeTestJSON[UsersResponse](t, data)
+			names = append(names, userNames(page.Users)...)
+			cursor = *page.NextCursor
+			if decoded, _ := base64.RawURLEncoding.DecodeString(cursor); cursor != "" && !isUUID(string(decoded)) {
+				t.Fatalf("next_cursor %q encodes %q, want a UUID", cursor, decoded)
+			}
+		}
+		if got := strings.Join(names, ","); got != "alice,bob,carol" {
+			t.Errorf("users over all pages = %s, want alice,bob,carol", got)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		res, data := ts.do(http.MethodGet, "/admin/export", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET /admin/export: status = %d: %s", res.StatusCode, data)
+		}
+		if strings.Contains(string(data), `"id":`) || strings.Contains(string(data), `"next_id":`) {
+			t.Errorf("GET /admin/export returned integer ids: %s", data)
+		}
+	})
+}
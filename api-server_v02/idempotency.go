@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultIdempotencyTTL はIdempotency-Keyに対するレスポンスを覚えておく時間のデフォルト値
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLen はIdempotency-Keyとして受け付ける最大の長さ
+const maxIdempotencyKeyLen = 255
+
+// defaultMaxIdempotencyEntries はレスポンスを覚えておくIdempotency-Keyの数の上限のデフォルト値
+const defaultMaxIdempotencyEntries = 10000
+
+// replayedHeaders は再送に対して覚えておいたレスポンスを返すときに復元するヘッダ
+// リクエストIDなど、リクエストごとに変わるヘッダは含めない
+var replayedHeaders = []string{"Content-Type", "Location", "Preference-Applied"}
+
+// idempotencyEntry はIdempotency-Keyごとに覚えておくレスポンス
+type idempotencyEntry struct {
+	request [sha256.Size]byte // リクエストのメソッド、パス、クエリ文字列とボディのハッシュ
+	done    bool              // レスポンスを書き終えたか（falseの場合は処理中）
+	status  int               // ステータスコード
+	header  http.Header       // replayedHeadersのヘッダ
+	body    []byte            // レスポンスボディ
+	expires time.Time         // 覚えておく期限
+}
+
+// idempotencyCache はIdempotency-Keyとそのキーで返したレスポンスの対応を覚えておくキャッシュ。
+type idempotencyCache struct {
+	mu         sync.Mutex // entriesとorderの排他制御のためのmutex
+	ttl        time.Duration
+	maxEntries int // 覚えておくキーの数の上限
+	entries    map[string]*idempotencyEntry
+	order      []idempotencyKeyEntry // 覚えたレスポンスを期限の早い順に並べたもの（ttlが一定のためfinishを呼んだ順）
+}
+
+// idempotencyKeyEntry は期限の順に並べるため、覚えたレスポンスをキーと組にしたもの
+type idempotencyKeyEntry struct {
+	key   string
+	entry *idempotencyEntry
+}
+
+// newIdempotencyCache はレスポンスをttlの間覚えておくidempotencyCacheを生成する。
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, maxEntries: defaultMaxIdempotencyEntries, entries: map[string]*idempotencyEntry{}}
+}
+
+// errIdempotencyKeyReused は同じIdempotency-Keyで異なるリクエストを送られたことを表すエラー
+var errIdempotencyKeyReused = errors.New("Idempotency-Key was already used for a different request")
+
+// begin はkeyのリクエストの処理を始める。requestはリクエストの内容のハッシュ。
+// 既にレスポンスを覚えている場合はそれを返し、同じキーのリクエストを処理中の場合はbusyにtrueを返す。
+// キーに対して覚えているリクエストとrequestが異なる場合はerrIdempotencyKeyReusedを返す。
+// いずれでもない場合は処理中として登録し、呼び出し側はfinishかabortを呼ぶ。
+func (c *idempotencyCache) begin(key string, request [sha256.Size]byte) (cached *idempotencyEntry, busy bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now())
+	if e, ok := c.entries[key]; ok {
+		if e.request != request {
+			return nil, false, errIdempotencyKeyReused
+		}
+		if !e.done {
+			return nil, true, nil
+		}
+		return e, false, nil
+	}
+	c.evict()
+	c.entries[key] = &idempotencyEntry{request: request}
+	return nil, false, nil
+}
+
+// finish はkeyに対して返したレスポンスを覚える。
+func (c *idempotencyCache) finish(key string, e *idempotencyEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur, ok := c.entries[key]
+	if !ok {
+		// 処理している間にclearで忘れた場合は、忘れる前の状態に対するレスポンスのため覚えない
+		return
+	}
+	e.request = cur.request
+	e.done = true
+	e.expires = now().Add(c.ttl)
+	c.entries[key] = e
+	c.order = append(c.order, idempotencyKeyEntry{key: key, entry: e})
+}
+
+// expire は期限がtより前のレスポンスを取り除く。
+// リクエストのたびに全てのキーを調べないよう、期限の早い順に調べて期限内のものに達したら止める。
+// c.muをロックした状態で呼び出すこと。
+func (c *idempotencyCache) expire(t time.Time) {
+	c.dropOldest(func(e *idempotencyEntry) bool { return t.After(e.expires) })
+}
+
+// evict は新しいキーを1つ登録できるよう、覚えているキーの数がmaxEntries未満になるまで期限の早いレスポンスから取り除く。
+// 処理中のキーは取り除かない。処理中のリクエストの数はMAX_CONCURRENT_REQUESTSで制限される。
+// c.muをロックした状態で呼び出すこと。
+func (c *idempotencyCache) evict() {
+	c.dropOldest(func(*idempotencyEntry) bool { return len(c.entries) >= c.maxEntries })
+}
+
+// dropOldest は期限の早い順に、dropがtrueを返す間レスポンスを取り除く。
+// c.muをロックした状態で呼び出すこと。
+func (c *idempotencyCache) dropOldest(drop func(e *idempotencyEntry) bool) {
+	n := 0
+	for _, ke := range c.order {
+		if !drop(ke.entry) {
+			break
+		}
+		if c.entries[ke.key] == ke.entry {
+			delete(c.entries, ke.key)
+		}
+		n++
+	}
+	// 取り除いたレスポンスを参照し続けないよう、詰める前に消しておく
+	clear(c.order[:n])
+	c.order = c.order[n:]
+}
+
+// clear は覚えている全てのレスポンスと処理中の登録を忘れる。
+// 保存先の全体を置き換えた後に、置き換える前の状態に対するレスポンスを返さないようにするために呼ぶ。
+func (c *idempotencyCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	clear(c.order)
+	c.order = nil
+}
+
+// abort はkeyのレスポンスを覚えずに処理中の登録を取り消し、同じキーで再試行できるようにする。
+func (c *idempotencyCache) abort(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && !e.done {
+		delete(c.entries, key)
+	}
+}
+
+// forgetResponses はリセットや読み込み、IDの振り直しで保存先の全体が変わった後に、
+// 変わる前の状態に対して返したレスポンスを同じIdempotency-Keyの再送や同じ内容の追加に返さないよう忘れる。
+func (a *App) forgetResponses() {
+	a.idempotency.clear()
+	if a.dedup != nil {
+		a.dedup.clear()
+	}
+}
+
+// captureWriter はクライアントへ書き込みながら、ステータスコードとボディを記録するResponseWriter
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader はステータスコードを記録してから元のResponseWriterに書き込む
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write はボディを記録してから元のResponseWriterに書き込む
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body.Write(b)
+	return cw.ResponseWriter.Write(b)
+}
+
+// Unwrap はhttp.ResponseControllerなどが元のResponseWriterを参照できるよう、元のResponseWriterを返す
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// withIdempotency はIdempotency-Keyヘッダを指定されたリクエストの再送を安全にする。
+// 最初のリクエストのレスポンスを覚えておき、同じキーで再送された場合はnextを呼ばずに同じレスポンスを返す。
+// 同じキーでメソッド、パス、クエリ文字列、ボディのいずれかが異なるリクエストを送られた場合は、別の操作の結果を返さないよう422を返す。
+// 5xxのレスポンスは一時的な失敗とみなして覚えず、同じキーで再試行できるようにする。
+func (a *App) withIdempotency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, http.StatusBadRequest, "invalid_header", "Idempotency-Key is too long")
+			return
+		}
+
+		// ボディを比べられるよう読み込んでおき、nextには読み込んだ内容を渡す
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		cached, busy, err := a.idempotency.begin(key, requestHash(r, body))
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", err.Error())
+			return
+		}
+		if busy {
+			writeError(w, http.StatusConflict, "idempotency_key_in_use", "a request with the same Idempotency-Key is in progress")
+			return
+		}
+		if cached != nil {
+			for k, v := range cached.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		cw := &captureWriter{ResponseWriter: w}
+		defer func() {
+			// panicした場合やサーバのエラーの場合はレスポンスを覚えない
+			if cw.status == 0 || cw.status >= 500 {
+				a.idempotency.abort(key)
+				return
+			}
+			header := http.Header{}
+			for _, k := range replayedHeaders {
+				if v := w.Header().Values(k); len(v) > 0 {
+					header[k] = v
+				}
+			}
+			a.idempotency.finish(key, &idempotencyEntry{status: cw.status, header: header, body: cw.body.Bytes()})
+		}()
+		next(cw, r)
+	}
+}
+
+// requestHash はIdempotency-Keyで同じリクエストかを比べるため、rのメソッド、パス、クエリ文字列とbodyのハッシュを返す。
+func requestHash(r *http.Request, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.RawQuery)
+	h.Write(body)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
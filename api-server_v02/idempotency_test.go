@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// setTestNow はテストの間nowが返す時刻をcurに固定する。*curを書き換えると時刻を進められる。
+func setTestNow(t *testing.T, cur *time.Time) {
+	t.Helper()
+	orig := now
+	now = func() time.Time { return *cur }
+	t.Cleanup(func() { now = orig })
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	type request struct {
+		key  string
+		path string
+		body string
+	}
+	alice := request{"k1", "/users", `{"name":"alice"}`}
+	tests := []struct {
+		name         string
+		between      func(t *testing.T, ts *testServer, clock *time.Time) // 最初のリクエストと再送の間に行う操作
+		retry        request
+		wantStatus   int
+		wantReplayed bool
+		wantCount    int // 再送の後のユーザー数
+	}{
+		{"replay", nil, alice, http.StatusCreated, true, 1},
+		{"different body", nil, request{"k1", "/users", `{"name":"bob"}`}, http.StatusUnprocessableEntity, false, 1},
+		{"different query", nil, request{"k1", "/users?unless_exists=name", alice.body}, http.StatusUnprocessableEntity, false, 1},
+		{"different key", nil, request{"k2", "/users", `{"name":"bob"}`}, http.StatusCreated, false, 2},
+		{"expired", func(t *testing.T, ts *testServer, clock *time.Time) {
+			*clock = clock.Add(defaultIdempotencyTTL + time.Second)
+		}, alice, http.StatusConflict, false, 1},
+		{"after reset", func(t *testing.T, ts *testServer, clock *time.Time) {
+			if res, data := ts.do(http.MethodDelete, "/users", ""); res.StatusCode != http.StatusNoContent {
+				t.Fatalf("DELETE /users: status = %d: %s", res.StatusCode, data)
+			}
+		}, alice, http.StatusCreated, false, 1},
+		{"evicted", func(t *testing.T, ts *testServer, clock *time.Time) {
+			ts.app.idempotency.maxEntries = 2
+			for _, key := range []string{"k2", "k3"} {
+				ts.doWithHeader(http.MethodPost, "/users", `{"name":"`+key+`"}`, http.Header{"Idempotency-Key": {key}})
+			}
+		}, alice, http.StatusConflict, false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := time.Now()
+			setTestNow(t, &clock)
+			ts := newTestServer(t, func(a *App) { a.allowReset = true })
+			post := func(req request) (*http.Response, []byte) {
+				return ts.doWithHeader(http.MethodPost, req.path, req.body, http.Header{"Idempotency-Key": {req.key}})
+			}
+
+			res, first := post(alice)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("first POST with Idempotency-Key: status = %d: %s", res.StatusCode, first)
+			}
+			if tt.between != nil {
+				tt.between(t, ts, &clock)
+			}
+			res, data := post(tt.retry)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST %s %s with Idempotency-Key %s: status = %d, want %d: %s", tt.retry.path, tt.retry.body, tt.retry.key, res.StatusCode, tt.wantStatus, data)
+			}
+			if replayed := res.Header.Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Errorf("Idempotent-Replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if tt.wantReplayed && !bytes.Equal(data, first) {
+				t.Errorf("replayed body = %s, want the first response %s", data, first)
+			}
+			if got := ts.app.store.Count(); got != tt.wantCount {
+				t.Errorf("Count() = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
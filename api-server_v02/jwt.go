@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTの検証で返すエラー
+var (
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	errInvalidSignature = errors.New("invalid signature")
+	errTokenExpired     = errors.New("token is expired")
+	errMissingExp       = errors.New("token has no exp claim")
+)
+
+// jwtClaims はトークンのペイロードのうち、検証と認可に使うクレーム
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt *int64 `json:"exp"` // 有効期限（UNIX時間の秒）
+}
+
+// verifyJWT はHS256で署名されたトークンの署名と有効期限をsecretで検証し、クレームを返す。
+// 署名を偽造されないよう、ヘッダのalgがHS256以外（"none"を含む）のトークンは受け付けない。
+func verifyJWT(token string, secret []byte) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, errMalformedToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return jwtClaims{}, err
+	}
+	if header.Alg != "HS256" {
+		return jwtClaims{}, errUnsupportedAlg
+	}
+
+	// 比較にかかる時間から署名を推測されないよう、hmac.Equalで比較する
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, errMalformedToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return jwtClaims{}, errInvalidSignature
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return jwtClaims{}, err
+	}
+	// 期限のないトークンは漏れたときに無効にできないため受け付けない
+	if claims.ExpiresAt == nil {
+		return jwtClaims{}, errMissingExp
+	}
+	if !now().Before(time.Unix(*claims.ExpiresAt, 0)) {
+		return jwtClaims{}, errTokenExpired
+	}
+	return claims, nil
+}
+
+// decodeJWTPart はbase64urlでエンコードされたトークンの一部をJSONとしてdstにデコードする。
+func decodeJWTPart(part string, dst any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errMalformedToken
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return errMalformedToken
+	}
+	return nil
+}
+
+// subjectKey はコンテキストにトークンのsubクレームを格納するためのキーの型
+type subjectKey struct{}
+
+// subjectFromContext はjwtAuthが格納したsubクレームを返す。格納されていない場合は空文字を返す。
+func subjectFromContext(ctx context.Context) string {
+	sub, _ := ctx.Value(subjectKey{}).(string)
+	return sub
+}
+
+// jwtAuth はAuthorizationヘッダのBearerトークンをsecretで検証するミドルウェア
+// 検証に成功した場合はsubクレームをコンテキストに格納して後続のハンドラに渡す
+// secretが空の場合はトークンを検証せずに全てのリクエストを通す
+// ロードバランサからのヘルスチェックは認証の対象外とする
+func jwtAuth(next http.Handler, secret string) http.Handler {
+	if secret == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing bearer token")
+			return
+		}
+		claims, err := verifyJWT(token, []byte(secret))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		}
+		ctx := context.WithValue(r.Context(), subjectKey{}, claims.Subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "test-secret"
+
+// signTestJWT はheaderとclaimsをJSONにしたトークンを、secretを鍵としてHS256で署名して返す。
+func signTestJWT(t *testing.T, header, claims map[string]any, secret string) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := encode(header) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setTestNow(t, &clock)
+	hs256 := map[string]any{"alg": "HS256", "typ": "JWT"}
+	valid := map[string]any{"sub": "alice", "exp": clock.Add(time.Hour).Unix()}
+	validToken := signTestJWT(t, hs256, valid, testJWTSecret)
+	parts := strings.Split(validToken, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", validToken, nil},
+		{"expired", signTestJWT(t, hs256, map[string]any{"sub": "alice", "exp": clock.Add(-time.Second).Unix()}, testJWTSecret), errTokenExpired},
+		{"expires now", signTestJWT(t, hs256, map[string]any{"sub": "alice", "exp": clock.Unix()}, testJWTSecret), errTokenExpired},
+		{"missing exp", signTestJWT(t, hs256, map[string]any{"sub": "alice"}, testJWTSecret), errMissingExp},
+		{"wrong secret", signTestJWT(t, hs256, valid, "other-secret"), errInvalidSignature},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory","exp":9999999999}`)) + "." + parts[2], errInvalidSignature},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged")), errInvalidSignature},
+		{"alg none", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".", errUnsupportedAlg},
+		{"alg HS512", signTestJWT(t, map[string]any{"alg": "HS512"}, valid, testJWTSecret), errUnsupportedAlg},
+		{"two parts", parts[0] + "." + parts[1], errMalformedToken},
+		{"signature not base64url", parts[0] + "." + parts[1] + ".!!", errMalformedToken},
+		{"header not JSON", base64.RawURLEncoding.EncodeToString([]byte("HS256")) + "." + parts[1] + "." + parts[2], errMalformedToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyJWT(tt.token, []byte(testJWTSecret))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verifyJWT error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && claims.Subject != "alice" {
+				t.Errorf("verifyJWT subject = %q, want alice", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setTestNow(t, &clock)
+	hs256 := map[string]any{"alg": "HS256"}
+	valid := signTestJWT(t, hs256, map[string]any{"sub": "alice", "exp": clock.Add(time.Hour).Unix()}, testJWTSecret)
+	expired := signTestJWT(t, hs256, map[string]any{"sub": "alice", "exp": clock.Add(-time.Hour).Unix()}, testJWTSecret)
+
+	tests := []struct {
+		name          string
+		secret        string
+		authorization string
+		path          string
+		wantStatus    int
+		wantSubject   string
+		wantChallenge string // WWW-Authenticateヘッダ
+	}{
+		{"valid token", testJWTSecret, "Bearer " + valid, "/users", http.StatusOK, "alice", ""},
+		{"expired token", testJWTSecret, "Bearer " + expired, "/users", http.StatusUnauthorized, "", `Bearer error="invalid_token"`},
+		{"tampered token", testJWTSecret, "Bearer " + valid + "x", "/users", http.StatusUnauthorized, "", `Bearer error="invalid_token"`},
+		{"missing header", testJWTSecret, "", "/users", http.StatusUnauthorized, "", "Bearer"},
+		{"not a bearer token", testJWTSecret, "Basic YWxpY2U6c2VjcmV0", "/users", http.StatusUnauthorized, "", "Bearer"},
+		{"empty bearer token", testJWTSecret, "Bearer ", "/users", http.StatusUnauthorized, "", "Bearer"},
+		{"health check without a token", testJWTSecret, "", "/healthz", http.StatusOK, "", ""},
+		{"no secret configured", "", "", "/users", http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotSubject string
+			h := jwtAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotSubject = subjectFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}), tt.secret)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET %s with Authorization %q: status = %d, want %d: %s", tt.path, tt.authorization, rec.Code, tt.wantStatus, rec.Body)
+			}
+			if gotSubject != tt.wantSubject {
+				t.Errorf("subject in the context = %q, want %q", gotSubject, tt.wantSubject)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// listCachedHeaders はユーザー一覧のレスポンスを覚えておくときに合わせて覚えるヘッダ
+var listCachedHeaders = []string{"Content-Type", "Link", "X-Total-Count", "X-Next-Cursor"}
+
+// listCacheEntry はクエリ文字列ごとに覚えておくユーザー一覧のレスポンス
+type listCacheEntry struct {
+	gen     uint64      // 覚えたときの世代（現在の世代と異なる場合は使わない）
+	header  http.Header // listCachedHeadersのヘッダ
+	body    []byte      // エンコード済みのレスポンスボディ
+	expires time.Time   // 覚えておく期限
+}
+
+// listCache はGET /usersのレスポンスを短い時間だけ覚えておくキャッシュ。
+// 読み取りが大半を占める状況で、同じ一覧を毎回並べ替えてエンコードしないために使う。
+// 保存先への書き込みのたびに世代を進め、それより前に覚えたレスポンスは返さない。
+type listCache struct {
+	mu      sync.Mutex // genとentriesの排他制御のためのmutex
+	ttl     time.Duration
+	gen     uint64
+	entries map[string]listCacheEntry
+}
+
+// newListCache はレスポンスをttlの間覚えておくlistCacheを生成する。
+func newListCache(ttl time.Duration) *listCache {
+	return &listCache{ttl: ttl, entries: map[string]listCacheEntry{}}
+}
+
+// generation は現在の世代を返す。レスポンスを作り始める前に呼び出し、覚えるときにstoreへ渡す。
+func (c *listCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// lookup はkeyに対して現在の世代で覚えている期限内のレスポンスを返す。
+func (c *listCache) lookup(key string) (listCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || e.gen != c.gen || now().After(e.expires) {
+		delete(c.entries, key)
+		return listCacheEntry{}, false
+	}
+	return e, true
+}
+
+// store はgenの世代で作ったレスポンスをkeyに対して覚える。
+// レスポンスを作っている間に書き込みがあった場合は、古い内容のおそれがあるため覚えない。
+func (c *listCache) store(key string, gen uint64, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	c.entries[key] = listCacheEntry{gen: gen, header: header, body: body, expires: now().Add(c.ttl)}
+}
+
+// invalidate は世代を進め、覚えている全てのレスポンスを捨てる。
+func (c *listCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	clear(c.entries)
+}
+
+// withListCache はユーザー一覧のレスポンスをクエリ文字列とAcceptヘッダごとにa.listCacheで覚えておく。
+// 覚えているレスポンスがある場合はnextを呼ばず、保存先を読まずにエンコード済みのボディを返す。
+// a.listCacheがnilの場合（LIST_CACHE_TTLが未設定の場合）は常にnextを呼ぶ。
+// NDJSONやCSVは一覧全体をメモリ上にまとめずに送り出すためのものなので、JSON以外の形式は覚えずにnextを呼ぶ。
+func (a *App) withListCache(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := a.listCache
+		if mediaType, _ := usersMediaType(r); c == nil || mediaType != jsonMediaType {
+			next(w, r)
+			return
+		}
+		key := r.Header.Get("Accept") + "\n" + r.URL.RawQuery
+		if e, ok := c.lookup(key); ok {
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(e.body)
+			return
+		}
+
+		gen := c.generation()
+		cw := &captureWriter{ResponseWriter: w}
+		next(cw, r)
+		if cw.status != http.StatusOK {
+			return
+		}
+		header := http.Header{}
+		for _, k := range listCachedHeaders {
+			if v := w.Header().Values(k); len(v) > 0 {
+				header[k] = v
+			}
+		}
+		c.store(key, gen, header, cw.body.Bytes())
+	}
+}
+
+// cacheInvalidatingStore は書き込みのたびにキャッシュを無効にするUserStore。
+// 読み取りはそのまま包んでいるUserStoreに任せる。
+type cacheInvalidatingStore struct {
+	UserStore
+	cache *listCache
+}
+
+// Add はユーザーを追加してからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Add(u User) (User, error) {
+	defer s.cache.invalidate()
+	return s.UserStore.Add(u)
+}
+
+// AddMany は複数のユーザーを追加してからキャッシュを無効にする。
+func (s cacheInvalidatingStore) AddMany(us []User) ([]User, error) {
+	defer s.cache.invalidate()
+	return s.UserStore.AddMany(us)
+}
+
+// Upsert はユーザーを置き換えるか追加してからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Upsert(u User, check func(cur User) error) (User, bool, error) {
+	defer s.cache.invalidate()
+	return s.UserStore.Upsert(u, check)
+}
+
+// Modify はユーザーを書き換えてからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Modify(id int, fn func(u *User) error) (User, error) {
+	defer s.cache.invalidate()
+	return s.UserStore.Modify(id, fn)
+}
+
+// Delete はユーザーを削除済みにしてからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Delete(id int) (User, bool) {
+	defer s.cache.invalidate()
+	return s.UserStore.Delete(id)
+}
+
+// Reset は全てのユーザーを取り除いてからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Reset() error {
+	defer s.cache.invalidate()
+	return s.UserStore.Reset()
+}
+
+// Import は全てのユーザーを置き換えてからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Import(b Backup) error {
+	defer s.cache.invalidate()
+	return s.UserStore.Import(b)
+}
+
+// Reindex はIDを振り直してからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Reindex() (map[int]int, error) {
+	defer s.cache.invalidate()
+	return s.UserStore.Reindex()
+}
+
+// Merge はユーザーをまとめてからキャッシュを無効にする。
+func (s cacheInvalidatingStore) Merge(keepID int, mergeIDs []int) (User, []User, error) {
+	defer s.cache.invalidate()
+	return s.UserStore.Merge(keepID, mergeIDs)
+}
+
+// enableListCache はGET /usersのレスポンスをttlの間覚えるようにする。
+// 書き込みのたびに覚えたレスポンスを捨てるよう、保存先をcacheInvalidatingStoreで包む。
+// リクエストを処理し始める前に呼び出すこと。
+func (a *App) enableListCache(ttl time.Duration) {
+	a.listCache = newListCache(ttl)
+	a.store = cacheInvalidatingStore{UserStore: a.store, cache: a.listCache}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withTestListCache はnewTestServerでGET /usersのレスポンスを1分間覚えるようにする。
+func withTestListCache(a *App) {
+	a.enableListCache(time.Minute)
+}
+
+// listNames はGET /usersで返るユーザーの名前を返す。
+func listNames(t *testing.T, ts *testServer) []string {
+	t.Helper()
+	res, data := ts.do(http.MethodGet, "/users", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /users: status = %d: %s", res.StatusCode, data)
+	}
+	return userNames(decodeTestJSON[UsersResponse](t, data).Users)
+}
+
+func TestListCacheServesHits(t *testing.T) {
+	ts := newTestServer(t, withTestListCache)
+	ts.CreateUser("alice")
+	listNames(t, ts)
+
+	// キャッシュを通さずに保存先へ追加しても、覚えている一覧を返す
+	if _, err := ts.app.store.(cacheInvalidatingStore).UserStore.Add(User{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listNames(t, ts), []string{"alice"}; !slices.Equal(got, want) {
+		t.Errorf("GET /users on a cache hit = %v, want the cached %v", got, want)
+	}
+}
+
+func TestListCacheSkipsStreamedFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+	}{
+		{"NDJSON", "/users", ndjsonMediaType},
+		{"CSV", "/users", csvMediaType},
+		{"CSV by format", "/users?format=csv", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, withTestListCache)
+			ts.CreateUser("alice")
+			header := http.Header{}
+			if tt.accept != "" {
+				header.Set("Accept", tt.accept)
+			}
+			ts.doWithHeader(http.MethodGet, tt.path, "", header)
+
+			// キャッシュを通さずに保存先へ追加しても、覚えていないため追加したユーザーを返す
+			if _, err := ts.app.store.(cacheInvalidatingStore).UserStore.Add(User{Name: "bob"}); err != nil {
+				t.Fatal(err)
+			}
+			res, data := ts.doWithHeader(http.MethodGet, tt.path, "", header)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s as %q: status = %d: %s", tt.path, tt.accept, res.StatusCode, data)
+			}
+			if !strings.Contains(string(data), "bob") {
+				t.Errorf("GET %s as %q returned a cached list without bob: %s", tt.path, tt.accept, data)
+			}
+		})
+	}
+}
+
+func TestListCacheInvalidatedOnWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		wantNames []string
+	}{
+		{"create", http.MethodPost, "/users", `{"name":"bob"}`, []string{"alice", "bob"}},
+		{"update", http.MethodPut, "/users/1", `{"name":"alice2","version":1}`, []string{"alice2"}},
+		{"patch", http.MethodPatch, "/users/1", `{"name":"alice3"}`, []string{"alice3"}},
+		{"delete", http.MethodDelete, "/users/1", "", []string{}},
+		{"bulk create", http.MethodPost, "/users/bulk", `[{"name":"bob"},{"name":"carol"}]`, []string{"alice", "bob", "carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, withTestListCache)
+			ts.CreateUser("alice")
+			if got, want := listNames(t, ts), []string{"alice"}; !slices.Equal(got, want) {
+				t.Fatalf("GET /users = %v, want %v", got, want)
+			}
+
+			if res, data := ts.do(tt.method, tt.path, tt.body); res.StatusCode >= 300 {
+				t.Fatalf("%s %s: status = %d: %s", tt.method, tt.path, res.StatusCode, data)
+			}
+			if got := listNames(t, ts); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("GET /users after %s %s = %v, want %v (stale cache served)", tt.method, tt.path, got, tt.wantNames)
+			}
+		})
+	}
+}
+
+// BenchmarkGetAllUsers はキャッシュがある場合とない場合の一覧の読み取りのスループットを比べる。
+func BenchmarkGetAllUsers(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		cache bool
+	}{
+		{"uncached", false},
+		{"cached", true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			app := NewApp(NewInMemoryStore(""))
+			if bm.cache {
+				app.enableListCache(time.Minute)
+			}
+			for i := 0; i < 1000; i++ {
+				if _, err := app.store.Add(User{Name: fmt.Sprintf("user%d", i)}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			h := app.newMux()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users?limit=100", nil))
+					if rec.Code != http.StatusOK {
+						b.Errorf("GET /users: status = %d", rec.Code)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// newLogger はlevel以上のログを1行1つのJSONとしてwへ出力するロガーを生成する。
+// ログの収集基盤で項目ごとに検索できるよう、メッセージとは別にキーと値の組で情報を渡す。
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// fatal はエラーのログを出力してからプロセスを終了する。
+// slogにはlog.Fatalfに当たる関数がないため、終了まで合わせて行う。
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLogLevel はLOG_LEVELで指定したレベル以上のログだけを、1行1つのJSONとして出力することを確かめる。
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		logLevel string
+		want     []string // 出力されるログのlevel
+	}{
+		{"", []string{"INFO", "WARN", "ERROR"}},
+		{"debug", []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{"info", []string{"INFO", "WARN", "ERROR"}},
+		{"WARN", []string{"WARN", "ERROR"}},
+		{"error", []string{"ERROR"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.logLevel, func(t *testing.T) {
+			t.Setenv("LOG_LEVEL", tt.logLevel)
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatalf("LoadConfig() with LOG_LEVEL=%q: %v", tt.logLevel, err)
+			}
+			var buf bytes.Buffer
+			logger := newLogger(&buf, cfg.LogLevel)
+			logger.Debug("message", "n", 1)
+			logger.Info("message", "n", 2)
+			logger.Warn("message", "n", 3)
+			logger.Error("message", "n", 4)
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				if line == "" {
+					continue
+				}
+				var record map[string]any
+				if err := json.Unmarshal([]byte(line), &record); err != nil {
+					t.Fatalf("decoding log line %s: %v", line, err)
+				}
+				if record["msg"] != "message" || record["time"] == nil {
+					t.Errorf("log record = %v, want msg and time", record)
+				}
+				got = append(got, record["level"].(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LOG_LEVEL=%q: logged levels = %v, want %v", tt.logLevel, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRequestLogFields はサーバと同じ順にミドルウェアを重ねたときに、
+// リクエストのログへ各項目が入ることを確かめる。
+func TestRequestLogFields(t *testing.T) {
+	tests := []struct {
+		name          string
+		requestID     string // X-Request-IDヘッダ（空の場合はヘッダを送らない）
+		wantRequestID bool   // ログのrequest_idが送ったIDと一致するか（falseの場合は生成したIDであること）
+	}{
+		{"given request ID", "req-123", true},
+		{"generated request ID", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			app := NewApp(NewInMemoryStore(""))
+			h := chain(app.newMux(), withRequestID, withLogging)
+			req := httptest.NewRequest(http.MethodGet, "/users/9?fields=id", nil)
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			record := requestLog(t, logs)
+			if record["level"] != slog.LevelInfo.String() || record["method"] != http.MethodGet ||
+				record["path"] != "/users/9" || record["status"] != float64(http.StatusNotFound) {
+				t.Errorf("request log = %v, want level INFO, method GET, path /users/9 and status 404", record)
+			}
+			if _, ok := record["duration_ms"].(float64); !ok {
+				t.Errorf("request log = %v, want a numeric duration_ms", record)
+			}
+			id, _ := record["request_id"].(string)
+			if want := rec.Header().Get("X-Request-ID"); id == "" || id != want {
+				t.Errorf("request log request_id = %q, want the response's X-Request-ID %q", id, want)
+			}
+			if (id == tt.requestID) != tt.wantRequestID {
+				t.Errorf("request log request_id = %q with X-Request-ID %q", id, tt.requestID)
+			}
+		})
+	}
+}
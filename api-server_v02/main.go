@@ -1,91 +1,1034 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // User はユーザー情報を表す構造体。
 type User struct {
-	ID   int    `json:"id"`   // JSONエンコード時のフィールド名を指定
-	Name string `json:"name"` // JSONエンコード時のフィールド名を指定
+	ID        int        `json:"id,omitempty"`         // ID_STRATEGY=uuidの場合はレスポンスに含めない
+	UUID      string     `json:"uuid,omitempty"`       // ID_STRATEGY=uuidの場合に割り当てるUUID
+	Name      string     `json:"name"`                 // JSONエンコード時のフィールド名を指定
+	Email     string     `json:"email,omitempty"`      // 既存のクライアントとの互換性のため任意項目とする
+	Version   int        `json:"version"`              // 更新のたびに1ずつ増えるバージョン
+	CreatedAt time.Time  `json:"created_at"`           // 作成日時（UTC）
+	UpdatedAt time.Time  `json:"updated_at"`           // 最後に更新した日時（UTC、作成後に更新していない場合は作成日時）
+	Deleted   bool       `json:"deleted,omitempty"`    // 削除済みか
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // 削除日時（UTC）
+}
+
+// UsersResponse はユーザー一覧取得のレスポンスを表す構造体。
+type UsersResponse struct {
+	Total int    `json:"total"` // ページングに関係なく保存されている全ユーザー数
+	Users []User `json:"users"` // 指定された範囲のユーザー情報
+	// 次のページを取得するためのカーソル（cursorを指定した場合のみ。最後のページでは空文字）
+	NextCursor *string `json:"next_cursor,omitempty"`
 }
 
-// グローバル変数の宣言
-var (
-	users  = []User{} // 保存しているユーザー情報のスライス
-	nextID = 1        // 次に追加されるユーザーに割り当てるID
-	mu     sync.Mutex // usersの排他制御のためのmutex
+// ページングのデフォルト値
+const (
+	defaultLimit  = 20 // 1ページあたりの件数
+	defaultOffset = 0  // 取得開始位置
 )
 
+// App はユーザー情報を扱うハンドラと、その動作に必要な状態をまとめた構造体。
+// パッケージ変数に状態を持たないため、1つのプロセスで独立した複数のAppを動かせる。
+// 保存先を差し替えられるよう、UserStoreを通してユーザー情報にアクセスする。
+type App struct {
+	store            UserStore
+	metrics          requestMetrics // このAppが処理したリクエスト数の集計値
+	allowReset       bool           // DELETE /usersで全てのユーザーを削除できるか（テスト用）
+	allowReindex     bool           // POST /admin/reindexでIDを振り直せるか
+	allowExport      bool           // GET /admin/exportで全てのユーザーを書き出せるか
+	allowImport      bool           // POST /admin/importで全てのユーザーを置き換えられるか
+	allowDrain       bool           // POST /admin/drainでサーバを切り離して停止できるか
+	allowMerge       bool           // POST /admin/mergeで重複したユーザーをまとめられるか
+	allowSetReadOnly bool           // PUT /admin/readonlyで読み取り専用の状態を切り替えられるか
+	idStrategy       string         // IDの割り当て方（idStrategySequentialまたはidStrategyUUID）
+	maxNameLen       int            // 名前の最大の文字数
+	readOnly         atomic.Bool    // 書き込みを断る読み取り専用の状態か（実行中に切り替えられる）
+	debug            bool           // GET /debug/statsを提供するか
+	dedup            *dedupCache    // 同じ内容の追加を重複とみなすためのキャッシュ（nilの場合は重複とみなさない）
+	draining         atomic.Bool    // 切り離し中で、/healthzが503を返す状態か
+	drained          chan struct{}  // 切り離し中になったときに閉じるチャネル
+
+	idempotency *idempotencyCache // Idempotency-Keyごとに返したレスポンス
+	events      *eventBroker      // GET /eventsで接続しているクライアントへの通知
+	listCache   *listCache        // GET /usersのレスポンスのキャッシュ（nilの場合は覚えない）
+
+	hooksMu sync.RWMutex // hooksの排他制御のためのmutex
+	hooks   []UserHook   // ユーザー情報の変更時に呼び出す関数
+
+	validatorsMu sync.RWMutex     // validatorsの排他制御のためのmutex
+	validators   []fieldValidator // 組み込みの検証に加えて呼び出す検証の関数
+}
+
+// NewApp は指定された保存先を使うAppを生成する。
+func NewApp(store UserStore) *App {
+	a := &App{
+		store:       store,
+		maxNameLen:  defaultMaxNameLength,
+		idempotency: newIdempotencyCache(defaultIdempotencyTTL),
+		drained:     make(chan struct{}),
+		events:      newEventBroker(),
+	}
+	a.OnUserEvent(a.events.hook)
+	return a
+}
+
 // addUser は新しいユーザーを追加するエンドポイントのハンドラ
-func addUser(w http.ResponseWriter, r *http.Request) {
-	// POSTメソッドのみ受け付ける
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is allowed", http.StatusBadRequest)
+// ?unless_exists=nameの場合は、同じ名前のユーザーが既にいれば追加せずにそのユーザーを200で返す
+func (a *App) addUser(w http.ResponseWriter, r *http.Request) {
+	unlessExists := r.URL.Query().Get("unless_exists")
+	if unlessExists != "" && unlessExists != "name" {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "unless_exists must be name")
 		return
 	}
 
 	// リクエストボディからUserをデコード
 	var u User
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := decodeJSON(w, r, &u); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// 前後の空白を取り除いてから入力値を検証
+	normalizeUser(&u)
+	if errs := a.validateUser(u); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	// ?dry_run=trueの場合は検証の結果だけを返し、保存しない
+	if r.URL.Query().Get("dry_run") == "true" {
+		a.dryRunAddUser(w, u)
 		return
 	}
 
+	// 直前に同じ内容で追加したユーザーがいれば、新しく追加せずにそのユーザーを返す
+	// 書き込みはwithWriteQueueで1つずつ処理するため、確認してから覚えるまでの間に同じ内容の追加は割り込まない
+	// 覚えた後に更新されたユーザーは、同じ内容で追加したユーザーとはみなさない
+	var key [sha256.Size]byte
+	if a.dedup != nil {
+		key = dedupKey(u)
+		if id, ok := a.dedup.lookup(key); ok {
+			if existing, ok := a.store.Get(id); ok && existing.Name == u.Name && existing.Email == u.Email {
+				w.Header().Set("Location", userLocation(r, existing))
+				writeJSON(w, http.StatusOK, a.publicUser(existing))
+				return
+			}
+		}
+	}
+
 	// ユーザー情報にIDを割り当てて保存
-	mu.Lock()                // 排他制御の開始
-	u.ID = nextID            // 新しいIDを割り当て
-	nextID++                 // 次のIDのインクリメント
-	users = append(users, u) // ユーザーの追加
-	mu.Unlock()              // 排他制御の終了
+	a.assignUUID(&u)
+	added, err := a.store.Add(u)
+	if errors.Is(err, ErrDuplicateName) && unlessExists == "name" {
+		// 名前の確認と追加は保存先がロック内で行うため、同時に送られても追加されるのは1人だけになる
+		// 見つけるまでの間に削除された場合は、通常の重複と同じく409を返す
+		if existing, ok := findUserByName(a.store.All(), u.Name); ok {
+			w.Header().Set("Location", userLocation(r, existing))
+			writeJSON(w, http.StatusOK, a.publicUser(existing))
+			return
+		}
+	}
+	u = added
+	switch {
+	case errors.Is(err, ErrDuplicateName):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+		return
+	case errors.Is(err, ErrStoreFull):
+		writeError(w, http.StatusInsufficientStorage, "insufficient_storage", err.Error())
+		return
+	case err != nil:
+		log.Printf("failed to add user: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to add user")
+		return
+	}
+
+	a.emit(EventUserCreated, u)
+	if a.dedup != nil {
+		a.dedup.record(key, u.ID)
+	}
+
+	// 追加されたユーザー情報を、その取得先と合わせてレスポンスとして返す
+	// Prefer: return=minimalの場合は、作成したユーザーを返さずに取得先だけを返す
+	w.Header().Set("Location", userLocation(r, u))
+	switch pref := preferReturn(r); pref {
+	case "minimal":
+		w.Header().Set("Preference-Applied", "return="+pref)
+		w.WriteHeader(http.StatusCreated)
+		return
+	case "representation":
+		w.Header().Set("Preference-Applied", "return="+pref)
+	}
+	writeJSON(w, http.StatusCreated, a.publicUser(u))
+}
+
+// dryRunAddUser は検証を通ったuを保存せずに、追加した場合の内容として200で返す。
+// 名前の重複も確認するが、確認した後に他のリクエストが同じ名前で追加する場合もある。
+// IDとUUIDは割り当てないため返さず、それ以外の項目は追加した場合の値にする。
+func (a *App) dryRunAddUser(w http.ResponseWriter, u User) {
+	if _, ok := findUserByName(a.store.All(), u.Name); ok {
+		writeError(w, http.StatusConflict, "conflict", ErrDuplicateName.Error())
+		return
+	}
+	u.ID, u.UUID = 0, ""
+	u.CreatedAt = now().UTC()
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
+	u.Deleted, u.DeletedAt = false, nil
+	writeJSON(w, http.StatusOK, a.publicUser(u))
+}
+
+// addUsers は複数のユーザーをまとめて追加するエンドポイントのハンドラ
+// 1件でも不正なユーザーが含まれる場合は、どのユーザーも追加しない
+func (a *App) addUsers(w http.ResponseWriter, r *http.Request) {
+	// リクエストボディからUserの配列をデコード
+	var us []User
+	if err := decodeJSON(w, r, &us); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// 全てのユーザーを検証してから追加する
+	for i := range us {
+		normalizeUser(&us[i])
+		if errs := a.validateUser(us[i]); errs != nil {
+			writeValidationErrors(w, errs.withIndex(i))
+			return
+		}
+		a.assignUUID(&us[i])
+	}
+
+	// 検証の間にクライアントが切断していれば、誰も結果を受け取らない追加はしない
+	if clientGone(r) {
+		return
+	}
+
+	// まとめてIDを割り当てて保存
+	us, err := a.store.AddMany(us)
+	switch {
+	case errors.Is(err, ErrDuplicateName):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+		return
+	case errors.Is(err, ErrStoreFull):
+		writeError(w, http.StatusInsufficientStorage, "insufficient_storage", err.Error())
+		return
+	case err != nil:
+		log.Printf("failed to add users: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to add users")
+		return
+	}
+
+	for _, u := range us {
+		a.emit(EventUserCreated, u)
+	}
 
 	// 追加されたユーザー情報をレスポンスとして返す
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(u)
+	writeJSON(w, http.StatusCreated, a.publicUsers(us))
+}
+
+// CountResponse はユーザー数の取得のレスポンスを表す構造体。
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// countUsers は保存されているユーザーの数を取得するエンドポイントのハンドラ
+// 一覧を取得せずに件数だけを返すため、ユーザーが多くても軽い
+func (a *App) countUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, CountResponse{Count: a.store.Count()})
 }
 
 // getUser は指定されたIDのユーザー情報を取得するエンドポイントのハンドラ
-func getUser(w http.ResponseWriter, r *http.Request) {
-	// GETメソッドのみ受け付ける
-	if r.Method != "GET" {
-		http.Error(w, "Only GET method is allowed", http.StatusBadRequest)
+func (a *App) getUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := negotiate(r, jsonMediaType); !ok {
+		writeNotAcceptable(w, jsonMediaType)
+		return
+	}
+
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	// パスパラメータからIDを取得
+	id, ok := a.userID(w, r)
+	if !ok {
+		return
+	}
+	u, ok := a.store.Get(id)
+	if !ok && includeDeleted(r) {
+		u, ok = findUser(a.store.Deleted(), id)
+	}
+	if !ok {
+		// 一致するユーザーが見つからなかった場合のエラーレスポンス
+		writeError(w, http.StatusNotFound, "not_found", "User not found")
 		return
 	}
 
-	// クエリパラメータからIDを取得
-	id := r.URL.Query().Get("id")
+	// クライアントが持っている内容から変わっていなければ本文を省略する
+	etag := userETag(u)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified(u))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	// 存在の確認だけのHEADではボディを捨てるため、エンコードせずにヘッダだけを返す
+	// ボディの長さはエンコードしないと分からないため、GETと異なりContent-Lengthは返さない
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if fields != nil {
+		writeJSON(w, http.StatusOK, projectUser(a.publicUser(u), fields))
+		return
+	}
+	writeJSON(w, http.StatusOK, a.publicUser(u))
+}
+
+// includeDeleted は?include_deleted=trueで削除済みのユーザーも含めるよう指定されたかを返す。
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("include_deleted") == "true"
+}
+
+// findUserByName はusersから名前が一致するユーザーを探す。名前の重複と同じく大文字と小文字は区別しない。
+func findUserByName(users []User, name string) (User, bool) {
 	for _, u := range users {
-		if fmt.Sprint(u.ID) == id {
-			json.NewEncoder(w).Encode(u)
-			return
+		if strings.EqualFold(u.Name, name) {
+			return u, true
 		}
 	}
+	return User{}, false
+}
 
-	// 一致するユーザーが見つからなかった場合のエラーレスポンス
-	http.Error(w, "User not found", http.StatusNotFound)
+// findUser はusersからIDが一致するユーザーを探す。
+func findUser(users []User, id int) (User, bool) {
+	for _, u := range users {
+		if u.ID == id {
+			return u, true
+		}
+	}
+	return User{}, false
 }
 
 // getAllUsers は全てのユーザー情報を取得するエンドポイントのハンドラ
-func getAllUsers(w http.ResponseWriter, r *http.Request) {
-	// GETメソッドのみ受け付ける
-	if r.Method != "GET" {
-		http.Error(w, "Only GET method is allowed", http.StatusBadRequest)
+func (a *App) getAllUsers(w http.ResponseWriter, r *http.Request) {
+	// 一覧を組み立てる前に返す形式を決める
+	mediaType, ok := usersMediaType(r)
+	if !ok {
+		writeNotAcceptable(w, userListMediaTypes...)
+		return
+	}
+
+	// クエリパラメータからページングの範囲を取得
+	limit := queryInt(r, "limit", defaultLimit)
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	offset := queryInt(r, "offset", defaultOffset)
+	if offset < 0 {
+		offset = defaultOffset
+	}
+
+	// クエリパラメータから並び順を取得
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey == "" {
+		sortKey = defaultSort
+	}
+	compare, ok := userComparators[sortKey]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "sort must be one of id, name, -id, -name")
+		return
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+
+	// 名前での絞り込みを指定された場合は該当するユーザーだけを対象にする
+	users := a.store.All()
+	if users == nil {
+		// JavaScriptのクライアントが扱いやすいよう、nullではなく[]として返す
+		users = []User{}
+	}
+	if includeDeleted(r) {
+		users = append(users, a.store.Deleted()...)
+	}
+	if name := strings.TrimSpace(r.URL.Query().Get("name")); name != "" {
+		users = filterByName(users, name)
+	}
+
+	// Allが返すのはコピーなので、並べ替えても保存されている順序には影響しない
+	slices.SortStableFunc(users, compare)
+	if clientGone(r) {
+		return
+	}
+
+	// cursorを指定された場合は、オフセットではなく前のページの最後のIDより後から返す
+	if r.URL.Query().Has("cursor") {
+		if sortKey != defaultSort {
+			writeError(w, http.StatusBadRequest, "invalid_parameter", "cursor can only be used with sort=id")
+			return
+		}
+		after, err := a.decodeCursor(r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_parameter", "cursor is invalid")
+			return
+		}
+		page := a.cursorPage(users, after, limit)
+		page.Users = a.publicUsers(page.Users)
+		writeUsers(w, r, mediaType, page, fields)
+		return
+	}
+
+	// 範囲外の指定はスライスの長さに丸める
+	start := min(offset, len(users))
+	end := min(start+limit, len(users))
+
+	// 指定された範囲のユーザー情報を全件数と合わせてレスポンスとして返す
+	// 前後のページへのURLはLinkヘッダで返す
+	w.Header().Set("Link", paginationLinks(r, offset, limit, len(users)))
+	writeUsers(w, r, mediaType, UsersResponse{
+		Total: len(users),
+		Users: a.publicUsers(users[start:end]),
+	}, fields)
+}
+
+// paginationLinks はオフセットによるページングの最初、最後、前後のページを指すLinkヘッダ（RFC 8288）の値を返す。
+// 最初のページではprevを、最後のページではnextを含めない。
+// 絞り込みなどのクエリパラメータは引き継ぎ、offsetとlimitだけを置き換える。
+func paginationLinks(r *http.Request, offset, limit, total int) string {
+	link := func(offset int, rel string) string {
+		q := r.URL.Query()
+		q.Set("offset", strconv.Itoa(offset))
+		q.Set("limit", strconv.Itoa(limit))
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, basePath(r), r.URL.Path, q.Encode(), rel)
+	}
+	last := 0
+	if total > 0 {
+		last = (total - 1) / limit * limit
+	}
+	links := []string{link(0, "first")}
+	if offset > 0 {
+		links = append(links, link(max(offset-limit, 0), "prev"))
+	}
+	if offset+limit < total {
+		links = append(links, link(offset+limit, "next"))
+	}
+	links = append(links, link(last, "last"))
+	return strings.Join(links, ", ")
+}
+
+// userListMediaTypes はユーザー一覧を返せるメディアタイプで、Acceptがワイルドカードの場合は先頭のJSONを選ぶ
+var userListMediaTypes = []string{jsonMediaType, ndjsonMediaType, csvMediaType}
+
+// usersMediaType はユーザー一覧を返すメディアタイプをAcceptヘッダから選ぶ。
+// ブラウザから直接開けるよう、?format=csvを指定された場合はAcceptに関係なくCSVを選ぶ。
+func usersMediaType(r *http.Request) (string, bool) {
+	if r.URL.Query().Get("format") == "csv" {
+		return csvMediaType, true
+	}
+	return negotiate(r, userListMediaTypes...)
+}
+
+// writeUsers はユーザー一覧のレスポンスをmediaTypeの形式で書き込む。
+// application/x-ndjsonの場合は、全体を1つの配列にせず1行に1ユーザーずつ返す。
+// text/csvの場合は、IDと名前を見出し行付きのCSVで返す。整数のIDを取り除いたユーザーはUUIDをIDの列に書く。
+// どちらの場合も全件数と次のカーソルはヘッダで返す。
+// fieldsがnilでない場合、JSONとNDJSONでは各ユーザーのfieldsのフィールドだけを返す。CSVの列は変えない。
+func writeUsers(w http.ResponseWriter, r *http.Request, mediaType string, res UsersResponse, fields []string) {
+	if mediaType == jsonMediaType {
+		if fields != nil {
+			writeJSON(w, http.StatusOK, ProjectedUsersResponse{
+				Total:      res.Total,
+				Users:      projectUsers(res.Users, fields),
+				NextCursor: res.NextCursor,
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(res.Total))
+	if res.NextCursor != nil {
+		w.Header().Set("X-Next-Cursor", *res.NextCursor)
+	}
+	if mediaType == csvMediaType {
+		writeCSV(w, res.Users)
+		return
+	}
+	writeNDJSON(w, r, res.Users, fields)
+}
+
+// cursorPage はIDの昇順に並んだusersのうち、IDがafterより大きいユーザーを最大limit件返す。
+// 続きがある場合は最後のユーザーを次のカーソルとして返す。
+func (a *App) cursorPage(users []User, after, limit int) UsersResponse {
+	start, _ := slices.BinarySearchFunc(users, after+1, func(u User, id int) int {
+		return cmp.Compare(u.ID, id)
+	})
+	end := min(start+limit, len(users))
+	next := ""
+	if end < len(users) {
+		next = a.encodeCursor(users[end-1])
+	}
+	return UsersResponse{
+		Total:      len(users),
+		Users:      users[start:end],
+		NextCursor: &next,
+	}
+}
+
+// encodeCursor は最後に返したユーザーuをクライアントに渡すカーソルに変換する。
+// クライアントが中身に依存しないよう、IDをそのままではなくエンコードして返す。
+// IDの割り当て方がuuidの場合は、カーソルから件数を推測されないよう整数のIDではなくUUIDをエンコードする。
+func (a *App) encodeCursor(u User) string {
+	v := strconv.Itoa(u.ID)
+	if a.idStrategy == idStrategyUUID {
+		v = u.UUID
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(v))
+}
+
+// decodeCursor はカーソルを最後に返したユーザーのIDに戻す。空のカーソルは先頭からを表す。
+// カーソルの中身はパスのidと同じくresolveIDで解釈するため、uuidの場合は整数のIDのカーソルを受け付けない。
+func (a *App) decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return a.resolveID(string(b))
+}
+
+// defaultSort は並び順の指定がない場合に使う並び順
+const defaultSort = "id"
+
+// userComparators はsortパラメータの値ごとのユーザーの比較関数
+// 先頭の "-" は降順を表す
+var userComparators = map[string]func(a, b User) int{
+	"id":    compareByID,
+	"-id":   func(a, b User) int { return compareByID(b, a) },
+	"name":  compareByName,
+	"-name": func(a, b User) int { return compareByName(b, a) },
+}
+
+// compareByID はIDの昇順になるようユーザーを比較する
+func compareByID(a, b User) int {
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// compareByName は名前の昇順になるようユーザーを比較する。大文字と小文字は区別しない。
+func compareByName(a, b User) int {
+	return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+}
+
+// filterByName は名前にsubstrを含むユーザーだけを返す。大文字と小文字は区別しない。
+// 保存している名前に合わせてsubstrもNFCに正規化してから比べる。
+// 該当するユーザーがいない場合もnilではなく空のスライスを返す。
+func filterByName(users []User, substr string) []User {
+	substr = strings.ToLower(norm.NFC.String(substr))
+	filtered := []User{}
+	for _, u := range users {
+		if strings.Contains(strings.ToLower(u.Name), substr) {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered
+}
+
+// queryInt はクエリパラメータを整数として取得する。
+// 指定がない場合や数値として解釈できない場合はdefを返す。
+func queryInt(r *http.Request, key string, def int) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// updateUser は指定されたIDのユーザー情報を更新するエンドポイントのハンドラ
+func (a *App) updateUser(w http.ResponseWriter, r *http.Request) {
+	// パスパラメータから更新対象のIDを取得
+	id, ok := a.userID(w, r)
+	if !ok {
+		return
+	}
+
+	// リクエストボディからUserをデコード
+	var u User
+	if err := decodeJSON(w, r, &u); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// IDはボディではなくパスの値を正とする
+	u.ID = id
+
+	// 前後の空白を取り除いてから入力値を検証
+	normalizeUser(&u)
+	if errs := a.validateUser(u); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	// 他のクライアントの更新を上書きしないよう、クライアントが更新の元にしたバージョンを確認する
+	// バージョンはIf-Matchヘッダ、なければボディのversionで指定する
+	// If-Unmodified-Sinceを指定された場合は、その日時より後に更新されていないことも確認する
+	pre, err := parseUpdatePrecondition(r, u.Version)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_header", "If-Match must be *, or ETags from GET or version numbers separated by commas")
+		return
+	}
+
+	// ?upsert=trueの場合は、一致するユーザーがいなければそのIDで新しく作成する
+	// 一致するユーザーを置き換える場合は、upsertを指定しない場合と同じ条件を確認する
+	if r.URL.Query().Get("upsert") == "true" {
+		a.upsertUser(w, r, u, pre)
+		return
+	}
+	if !pre.hasVersion() {
+		writeError(w, http.StatusPreconditionRequired, "precondition_required", errPreconditionRequired.Error())
+		return
+	}
+
+	// 一致するユーザーをボディの内容で丸ごと置き換える
+	u, err = a.store.Modify(id, func(cur *User) error {
+		if err := pre.check(*cur); err != nil {
+			return err
+		}
+		cur.Name = u.Name
+		cur.Email = u.Email
+		return nil
+	})
+	switch {
+	case errors.Is(err, errModifiedSince):
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+		return
+	case errors.Is(err, errVersionMismatch):
+		writeError(w, http.StatusConflict, "version_conflict", err.Error())
+		return
+	case err != nil:
+		writeModifyError(w, err)
+		return
+	}
+	a.emit(EventUserUpdated, u)
+	writeJSON(w, http.StatusOK, a.publicUser(u))
+}
+
+// errVersionMismatch は更新の元にしたバージョンが現在のバージョンと異なることを表すエラー
+var errVersionMismatch = errors.New("user has been modified by another request")
+
+// parseVersion はIf-Matchヘッダに指定された1つの値をバージョンとして解釈する。
+// GETで返したETag（W/"3"）、引用符で囲んだ値（"3"）、囲まない値（3）のいずれも受け付ける。
+func parseVersion(v string) (int, error) {
+	return strconv.Atoi(strings.Trim(strings.TrimPrefix(strings.TrimSpace(v), "W/"), `"`))
+}
+
+// upsertUser はuを保存し、新しく作成した場合は201、既存のユーザーを置き換えた場合は200を返す。
+// 既存のユーザーを置き換える場合は、置き換える前にpreの条件を満たすかを確かめる。
+func (a *App) upsertUser(w http.ResponseWriter, r *http.Request, u User, pre updatePrecondition) {
+	if u.ID <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_id", "id must be a positive integer")
+		return
+	}
+	a.assignUUID(&u) // 既存のユーザーを置き換える場合はストアが元のUUIDを引き継ぐ
+	u, created, err := a.store.Upsert(u, pre.check)
+	switch {
+	case errors.Is(err, errModifiedSince):
+		writeError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+		return
+	case errors.Is(err, errVersionMismatch):
+		writeError(w, http.StatusConflict, "version_conflict", err.Error())
+		return
+	case errors.Is(err, errPreconditionRequired):
+		writeError(w, http.StatusPreconditionRequired, "precondition_required", err.Error())
+		return
+	case errors.Is(err, ErrDuplicateName):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+		return
+	case errors.Is(err, ErrStoreFull):
+		writeError(w, http.StatusInsufficientStorage, "insufficient_storage", err.Error())
+		return
+	case err != nil:
+		log.Printf("failed to upsert user: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to update user")
+		return
+	}
+	status, event := http.StatusOK, EventUserUpdated
+	if created {
+		status, event = http.StatusCreated, EventUserCreated
+		w.Header().Set("Location", userLocation(r, u))
+	}
+	a.emit(event, u)
+	writeJSON(w, status, a.publicUser(u))
+}
+
+// UserPatch はユーザー情報を部分的に更新するリクエストを表す構造体。
+// 指定されなかったフィールドと空文字を指定されたフィールドを区別するためポインタで受け取る。
+type UserPatch struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+}
+
+// patchUser は指定されたIDのユーザー情報のうち、指定されたフィールドだけを更新するエンドポイントのハンドラ
+func (a *App) patchUser(w http.ResponseWriter, r *http.Request) {
+	// パスパラメータから更新対象のIDを取得
+	id, ok := a.userID(w, r)
+	if !ok {
+		return
+	}
+
+	// JSON Merge Patchとして送られた場合はRFC 7386の規則で更新する
+	if requestMediaType(r) == mergePatchMediaType {
+		a.mergePatchUser(w, r, id)
+		return
+	}
+
+	// リクエストボディから更新するフィールドをデコード
+	var p UserPatch
+	if err := decodeJSON(w, r, &p); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// 現在のユーザー情報に指定されたフィールドだけを反映してから検証する
+	u, err := a.store.Modify(id, func(u *User) error {
+		if p.Name != nil {
+			u.Name = *p.Name
+		}
+		if p.Email != nil {
+			u.Email = *p.Email
+		}
+		normalizeUser(u)
+		if errs := a.validateUser(*u); errs != nil {
+			return errs
+		}
+		return nil
+	})
+	if err != nil {
+		writeModifyError(w, err)
+		return
+	}
+	a.emit(EventUserUpdated, u)
+	writeJSON(w, http.StatusOK, a.publicUser(u))
+}
+
+// writeModifyError はstore.Modifyが返したエラーを対応するステータスコードのエラーレスポンスとして書き込む。
+func writeModifyError(w http.ResponseWriter, err error) {
+	var errs ValidationErrors
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		// 一致するユーザーが見つからなかった場合のエラーレスポンス
+		writeError(w, http.StatusNotFound, "not_found", "User not found")
+	case errors.Is(err, ErrDuplicateName):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	case errors.As(err, &errs):
+		writeValidationErrors(w, errs)
+	default:
+		log.Printf("failed to update user: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to update user")
+	}
+}
+
+// deleteUser は指定されたIDのユーザーを削除するエンドポイントのハンドラ
+// 再試行したクライアントが最初の削除の成否を区別できるよう、削除したユーザーを200で返す
+// 削除済みのユーザーは見つからないものとして扱うため、同じIDを再び削除すると404になる
+func (a *App) deleteUser(w http.ResponseWriter, r *http.Request) {
+	// パスパラメータからIDを取得
+	// 数値としてもUUIDとしても解釈できないIDに一致するユーザーは存在しない
+	id, err := a.pathID(r)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not_found", "User not found")
 		return
 	}
 
-	// 保存している全てのユーザー情報をレスポンスとして返す
-	json.NewEncoder(w).Encode(users)
+	// If-Unmodified-Sinceを指定された場合は、その日時より後に更新されたユーザーを削除しない
+	// ストアに条件付きの削除はないため、確認と削除の間に更新された場合は見逃す
+	if since, ok := unmodifiedSince(r); ok {
+		if cur, found := a.store.Get(id); found && modifiedSince(cur, since) {
+			writeError(w, http.StatusPreconditionFailed, "precondition_failed", errModifiedSince.Error())
+			return
+		}
+	}
+	u, ok := a.store.Delete(id)
+	if !ok {
+		// 一致するユーザーが見つからなかった場合のエラーレスポンス
+		writeError(w, http.StatusNotFound, "not_found", "User not found")
+		return
+	}
+	a.emit(EventUserDeleted, u)
+	writeJSON(w, http.StatusOK, a.publicUser(u))
+}
+
+// resetUsers は全てのユーザーを削除するエンドポイントのハンドラ
+// 結合テストでサーバを再起動せずに状態を戻すためのもので、本番で誤って呼ばれないよう
+// allowResetが有効な場合（ALLOW_RESET=true）だけ受け付ける
+func (a *App) resetUsers(w http.ResponseWriter, r *http.Request) {
+	if !a.allowReset {
+		writeError(w, http.StatusForbidden, "forbidden", "reset is disabled")
+		return
+	}
+	if err := a.store.Reset(); err != nil {
+		log.Printf("failed to reset users: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to reset users")
+		return
+	}
+	a.forgetResponses()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HealthResponse はヘルスチェックのレスポンスを表す構造体。
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// newMux は各エンドポイントとハンドラ関数を関連付けたServeMuxを生成する
+// ServeMuxが返す404と405は、withMuxErrorsで他のエラーと同じJSONの形式にする
+func (a *App) newMux() http.Handler {
+	// Go 1.22のServeMuxのパターンでメソッドとパスを指定する
+	// メソッドが一致しない場合はServeMuxが405を返す
+	// GETのパターンはHEADにも一致し、HEADではnet/httpがボディを捨ててヘッダとステータスだけを返す
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users", a.withListCache(a.getAllUsers))
+	mux.HandleFunc("POST /users", a.withIdempotency(a.addUser))
+	mux.HandleFunc("DELETE /users", a.resetUsers)
+	mux.HandleFunc("POST /users/bulk", a.addUsers)
+	mux.HandleFunc("GET /users/count", a.countUsers)
+	mux.HandleFunc("GET /users/recent", a.getRecentUsers)
+	mux.HandleFunc("GET /users/search", a.searchUsers)
+	mux.HandleFunc("GET /users/batch", a.getUsersBatch)
+	mux.HandleFunc("GET /users/{id}", a.getUser)
+	mux.HandleFunc("PUT /users/{id}", a.updateUser)
+	mux.HandleFunc("PATCH /users/{id}", a.patchUser)
+	mux.HandleFunc("DELETE /users/{id}", a.deleteUser)
+	mux.HandleFunc("GET /healthz", a.healthz)
+	mux.HandleFunc("GET "+eventsPath, a.streamEvents)
+	mux.HandleFunc("GET /version", getVersion)
+	mux.HandleFunc("GET /metrics", a.getMetrics)
+	mux.HandleFunc("GET /openapi.json", openAPI)
+	mux.HandleFunc("GET "+readOnlyPath, a.getReadOnly)
+	mux.HandleFunc("PUT "+readOnlyPath, a.setReadOnly)
+	mux.HandleFunc("POST "+drainPath, a.drain)
+	mux.HandleFunc("GET /admin/export", a.exportUsers)
+	mux.HandleFunc("POST /admin/import", a.importUsers)
+	mux.HandleFunc("POST /admin/reindex", a.reindexUsers)
+	mux.HandleFunc("POST /admin/merge", a.mergeUsers)
+	if a.debug {
+		// 無効な場合は登録しないため、ServeMuxが404を返す
+		mux.HandleFunc("GET /debug/stats", a.debugStats)
+	}
+	return withMuxErrors(mux)
+}
+
+// openStore は保存先を用意し、サーバ停止時に呼び出す後始末の関数と合わせて返す。
+// dbPathを指定した場合はSQLiteのデータベースを使い、
+// 指定しない場合は前回保存したファイルを読み込んだメモリ上の保存先を使う。
+// メモリ上の保存先でsnapshotIntervalが正の場合は、更新のたびではなくその間隔でファイルへ書き出す。
+func openStore(cfg Config, dbPath string, snapshotInterval time.Duration) (UserStore, func(), error) {
+	if dbPath == "" {
+		s := NewInMemoryStore(usersFile)
+		s.saveAttempts = cfg.SaveAttempts
+		s.saveRetryDelay = cfg.SaveRetryDelay
+		s.maxUsers = cfg.MaxUsers
+		if snapshotInterval <= 0 {
+			return s, s.Flush, nil
+		}
+		ticker := time.NewTicker(snapshotInterval)
+		stop := s.startSnapshots(ticker.C)
+		return s, func() {
+			ticker.Stop()
+			stop()
+		}, nil
+	}
+	s, err := NewSQLiteStore(dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.maxUsers = cfg.MaxUsers
+	return s, func() { s.Close() }, nil
+}
+
+// seed はpathのJSONファイルに書かれたユーザーを保存先に追加し、追加した件数を返す。
+// ファイル内のIDは使わず、保存先が新しいIDを割り当てる。
+// APIから追加する場合と同じ規則で検証するため、名前の長さなどの設定を済ませてから呼び出す。
+func (a *App) seed(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var us []User
+	if err := json.Unmarshal(data, &us); err != nil {
+		return 0, fmt.Errorf("invalid seed file %s: %w", path, err)
+	}
+	for i := range us {
+		normalizeUser(&us[i])
+		if errs := a.validateUser(us[i]); errs != nil {
+			return 0, fmt.Errorf("invalid seed file %s: %w", path, errs.withIndex(i))
+		}
+		a.assignUUID(&us[i])
+	}
+	us, err = a.store.AddMany(us)
+	if err != nil {
+		return 0, err
+	}
+	return len(us), nil
+}
+
+// shutdownServer は処理中のリクエストの完了を最大timeoutだけ待ってからsrvを停止する。
+// 運用者が停止の遅れや打ち切りの影響を判断できるよう、待っているリクエストの数を記録する
+func (a *App) shutdownServer(srv *http.Server, timeout time.Duration) {
+	slog.Info("shutting down server", "in_flight", a.metrics.inFlight.Load(), "timeout", timeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("requests did not finish before shutdown timeout", "in_flight", a.metrics.inFlight.Load(), "timeout", timeout.String())
+	} else if err != nil {
+		slog.Error("failed to shut down server gracefully", "error", err)
+	}
 }
 
 func main() {
-	// 各エンドポイントとハンドラ関数の関連付け
-	http.HandleFunc("/add-user", addUser)
-	http.HandleFunc("/get-user", getUser)
-	http.HandleFunc("/get-all-users", getAllUsers)
+	addr := flag.String("addr", "", "listen address (overrides $PORT, default "+defaultAddr+")")
+	tlsCert := flag.String("tls-cert", "", "path to a TLS certificate to serve HTTPS (overrides $TLS_CERT_FILE)")
+	tlsKey := flag.String("tls-key", "", "path to the TLS private key for -tls-cert (overrides $TLS_KEY_FILE)")
+	dbPath := flag.String("db", "", "path to a SQLite database (default: in-memory store persisted to "+usersFile+")")
+	snapshotInterval := flag.Duration("snapshot-interval", 0, "write "+usersFile+" at this interval instead of on every change (in-memory store only)")
+	seed := flag.String("seed", "", "path to a JSON file of users to add at startup")
+	pretty := flag.Bool("pretty", false, "pretty-print all JSON responses (for development)")
+	flag.Parse()
+
+	// 設定の読み込み
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	// logパッケージで出力するログも同じJSON形式になるよう、既定のロガーを差し替える
+	slog.SetDefault(newLogger(os.Stderr, cfg.LogLevel))
+	if *addr != "" {
+		cfg.Addr = *addr
+	}
+	if *tlsCert != "" {
+		cfg.TLSCertFile = *tlsCert
+	}
+	if *tlsKey != "" {
+		cfg.TLSKeyFile = *tlsKey
+	}
+	if err := cfg.validateTLS(); err != nil {
+		fatal("failed to load config", err)
+	}
+
+	// 保存先の用意
+	store, closeStore, err := openStore(cfg, *dbPath, *snapshotInterval)
+	if err != nil {
+		fatal("failed to open store", err)
+	}
+	app := NewApp(store)
+	app.allowReset = cfg.AllowReset
+	app.allowReindex = cfg.AllowReindex
+	app.allowExport = cfg.AllowExport
+	app.allowImport = cfg.AllowImport
+	app.allowDrain = cfg.AllowDrain
+	app.allowMerge = cfg.AllowMerge
+	app.allowSetReadOnly = cfg.AllowSetReadOnly
+	app.idStrategy = cfg.IDStrategy
+	app.maxNameLen = cfg.MaxNameLength
+	app.readOnly.Store(cfg.ReadOnly)
+	app.debug = cfg.Debug
+	app.idempotency = newIdempotencyCache(cfg.IdempotencyTTL)
+	if cfg.DedupWindow > 0 {
+		app.dedup = newDedupCache(cfg.DedupWindow)
+	}
+	if cfg.ListCacheTTL > 0 {
+		app.enableListCache(cfg.ListCacheTTL)
+	}
+	if *seed != "" {
+		n, err := app.seed(*seed)
+		if err != nil {
+			fatal("failed to seed users", err)
+		}
+		slog.Info("seeded users", "count", n, "file", *seed)
+	}
 
 	// HTTPサーバの起動
-	http.ListenAndServe(":8080", nil)
+	limiter := newRateLimiter(rateLimitPerSecond, rateLimitBurst)
+	go limiter.cleanupLoop(time.Minute, rateLimitIdleTTL)
+	writes := newWriteQueue(cfg.WriteQueueSize, cfg.WriteQueueTimeout)
+	handler := chain(app.newMux(),
+		func(h http.Handler) http.Handler { return withClientIP(h, cfg.TrustProxy) },
+		withRequestID,
+		withLogging,
+		func(h http.Handler) http.Handler { return withTrailingSlash(h, cfg.TrailingSlash) },
+		app.withMetrics,
+		withRecovery,
+		withGzip,
+		func(h http.Handler) http.Handler { return withCORS(h, corsPolicies(cfg.CORSAllowedOrigin)) },
+		func(h http.Handler) http.Handler { return withRateLimit(h, limiter) },
+		func(h http.Handler) http.Handler { return withConcurrencyLimit(h, cfg.MaxConcurrent) },
+		func(h http.Handler) http.Handler { return requireUserAgent(h, cfg.RequireUserAgent) },
+		func(h http.Handler) http.Handler { return jwtAuth(h, cfg.JWTSecret) },
+		func(h http.Handler) http.Handler { return apiKeyAuth(h, cfg.APIKey) },
+		func(h http.Handler) http.Handler { return withBodyLimit(h, cfg.MaxBodyBytes) },
+		app.withReadOnly,
+		func(h http.Handler) http.Handler { return withTimeout(h, cfg.RequestTimeout) },
+		func(h http.Handler) http.Handler { return withChaosDelay(h, cfg.ChaosDelay) },
+		func(h http.Handler) http.Handler { return withWriteQueue(h, writes) },
+		func(h http.Handler) http.Handler { return withPrettyJSON(h, *pretty) },
+	)
+	// 認証の除外などのミドルウェアがBASE_PATHを意識せずに済むよう、全体をBASE_PATHの下に置く
+	srv := buildServer(cfg, mountBasePath(handler, cfg.BasePath))
+	srv.RegisterOnShutdown(app.events.close)
+	slog.Info("starting server", "addr", cfg.Addr, "tls", cfg.TLSCertFile != "", "version", version)
+	go func() {
+		if err := serve(srv, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to start server", err)
+		}
+	}()
+
+	// 終了シグナルを受け取るか、切り離し中になってから猶予時間が過ぎるまで待機
+	// 猶予時間の間もリクエストは処理し続け、その間に終了シグナルを受け取った場合はすぐに停止へ進む
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-quit:
+	case <-app.drained:
+		slog.Info("draining", "grace_period", cfg.DrainGracePeriod.String())
+		select {
+		case <-quit:
+		case <-time.After(cfg.DrainGracePeriod):
+		}
+	}
+
+	// 処理中のリクエストの完了を待ってからサーバを停止
+	app.shutdownServer(srv, cfg.ShutdownTimeout)
+
+	// 停止前に保存先の内容を確定させる
+	closeStore()
 }
@@ -0,0 +1,1451 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// userNames はusersの名前を順に並べて返す。
+func userNames(users []User) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Name
+	}
+	return names
+}
+
+// userIDs はusersのIDを順に並べて返す。
+func userIDs(users []User) []int {
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+// idRange はfirstからlastまでのIDを返す。lastがfirstより小さい場合は空のスライスを返す。
+func idRange(first, last int) []int {
+	ids := []int{}
+	for id := first; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestDeleteUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantNames  []string // 削除後の一覧の名前（IDの順）
+	}{
+		{"middle", "2", http.StatusOK, []string{"alice", "carol"}},
+		{"end", "3", http.StatusOK, []string{"alice", "bob"}},
+		{"non-existent", "4", http.StatusNotFound, []string{"alice", "bob", "carol"}},
+		{"not a number", "abc", http.StatusNotFound, []string{"alice", "bob", "carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, name := range []string{"alice", "bob", "carol"} {
+				ts.CreateUser(name)
+			}
+
+			res, data := ts.do(http.MethodDelete, "/users/"+tt.id, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("DELETE /users/%s: status = %d, want %d: %s", tt.id, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusOK {
+				deleted := decodeTestJSON[User](t, data)
+				if strconv.Itoa(deleted.ID) != tt.id || !deleted.Deleted {
+					t.Errorf("DELETE /users/%s returned %+v, want the deleted user", tt.id, deleted)
+				}
+				if _, ok := ts.GetUser(deleted.ID); ok {
+					t.Errorf("GET /users/%s still finds the deleted user", tt.id)
+				}
+			}
+
+			_, data = ts.do(http.MethodGet, "/users", "")
+			if got := userNames(decodeTestJSON[UsersResponse](t, data).Users); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("users after DELETE /users/%s = %v, want %v", tt.id, got, tt.wantNames)
+			}
+		})
+	}
+}
+
+// TestDeleteUserTwice は最初の削除が削除したユーザーを返し、再試行した削除が404になることで、
+// クライアントが最初の削除の成否を区別できることを確かめる。
+func TestDeleteUserTwice(t *testing.T) {
+	for _, st := range testStores {
+		t.Run(st.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.store = st.open(t) })
+			u := ts.CreateUser("alice")
+			ts.do(http.MethodPatch, userPath(u), `{"email":"alice@example.com"}`)
+
+			tests := []struct {
+				name       string
+				wantStatus int
+			}{
+				{"first", http.StatusOK},
+				{"retry", http.StatusNotFound},
+				{"second retry", http.StatusNotFound},
+			}
+			for _, tt := range tests {
+				res, data := ts.do(http.MethodDelete, userPath(u), "")
+				if res.StatusCode != tt.wantStatus {
+					t.Fatalf("%s DELETE %s: status = %d, want %d: %s", tt.name, userPath(u), res.StatusCode, tt.wantStatus, data)
+				}
+				if tt.wantStatus == http.StatusNotFound {
+					if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "not_found" {
+						t.Errorf("%s DELETE %s: code = %q, want not_found", tt.name, userPath(u), got)
+					}
+					continue
+				}
+				deleted := decodeTestJSON[User](t, data)
+				if deleted.ID != u.ID || deleted.Name != "alice" || deleted.Email != "alice@example.com" || !deleted.Deleted || deleted.DeletedAt == nil {
+					t.Errorf("%s DELETE %s returned %+v, want alice marked as deleted", tt.name, userPath(u), deleted)
+				}
+			}
+		})
+	}
+}
+
+func TestHeadUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"existing", "/users/1", "", http.StatusOK},
+		{"missing", "/users/2", "", http.StatusNotFound},
+		{"not an id", "/users/abc", "", http.StatusBadRequest},
+		{"not modified", "/users/1", `W/"1"`, http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			header := http.Header{}
+			if tt.ifNoneMatch != "" {
+				header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			res, data := ts.doWithHeader(http.MethodHead, tt.path, "", header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("HEAD %s: status = %d, want %d", tt.path, res.StatusCode, tt.wantStatus)
+			}
+			if len(data) != 0 {
+				t.Errorf("HEAD %s returned a body: %s", tt.path, data)
+			}
+			if tt.wantStatus == http.StatusOK {
+				// エンコードせずに返すため、ETagなどはGETと同じでもContent-Lengthは返さない
+				if got := res.Header.Get("ETag"); got != `W/"1"` {
+					t.Errorf("HEAD %s: ETag = %q, want %q", tt.path, got, `W/"1"`)
+				}
+				if got := res.Header.Get("Content-Type"); got != "application/json" {
+					t.Errorf("HEAD %s: Content-Type = %q, want application/json", tt.path, got)
+				}
+				if res.ContentLength != -1 {
+					t.Errorf("HEAD %s: Content-Length = %d, want none", tt.path, res.ContentLength)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantName   string // 更新後のユーザー1の名前
+	}{
+		{"success", "/users/1", `{"name":"alice2","version":1}`, http.StatusOK, "alice2"},
+		{"non-existent id", "/users/99", `{"name":"alice2","version":1}`, http.StatusNotFound, "alice"},
+		{"invalid id", "/users/abc", `{"name":"alice2","version":1}`, http.StatusBadRequest, "alice"},
+		{"stale version", "/users/1", `{"name":"alice2","version":2}`, http.StatusConflict, "alice"},
+		{"missing version", "/users/1", `{"name":"alice2"}`, http.StatusPreconditionRequired, "alice"},
+		{"invalid name", "/users/1", `{"name":"","version":1}`, http.StatusUnprocessableEntity, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(http.MethodPut, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("PUT %s: status = %d, want %d: %s", tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if u := decodeTestJSON[User](t, data); u.ID != 1 || u.Name != tt.wantName || u.Version != 2 {
+					t.Errorf("PUT %s returned %+v, want user 1 named %q at version 2", tt.path, u, tt.wantName)
+				}
+			}
+			if u, _ := ts.GetUser(1); u.Name != tt.wantName {
+				t.Errorf("user 1 after PUT %s is named %q, want %q", tt.path, u.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestUpdateUserPreconditions(t *testing.T) {
+	const past = "Sat, 01 Jan 2000 00:00:00 GMT"
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		header     http.Header
+		wantStatus int
+		wantName   string // 更新後のユーザー1の名前
+	}{
+		{"If-Match ETag", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {`W/"1"`}}, http.StatusOK, "alice2"},
+		{"If-Match version", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {"1"}}, http.StatusOK, "alice2"},
+		{"If-Match overrides body version", "/users/1", `{"name":"alice2","version":2}`, http.Header{"If-Match": {`W/"1"`}}, http.StatusOK, "alice2"},
+		{"If-Match stale", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {`W/"2"`}}, http.StatusConflict, "alice"},
+		{"If-Match any", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {"*"}}, http.StatusOK, "alice2"},
+		{"If-Match list containing the version", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {`W/"3", W/"1"`}}, http.StatusOK, "alice2"},
+		{"If-Match list without the version", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {`W/"2", "3"`}}, http.StatusConflict, "alice"},
+		{"If-Match invalid", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {"abc"}}, http.StatusBadRequest, "alice"},
+		{"If-Match without values", "/users/1", `{"name":"alice2"}`, http.Header{"If-Match": {","}}, http.StatusBadRequest, "alice"},
+		{"If-Unmodified-Since in the past", "/users/1", `{"name":"alice2","version":1}`, http.Header{"If-Unmodified-Since": {past}}, http.StatusPreconditionFailed, "alice"},
+		{"upsert existing without version", "/users/1?upsert=true", `{"name":"alice2"}`, nil, http.StatusPreconditionRequired, "alice"},
+		{"upsert existing with version", "/users/1?upsert=true", `{"name":"alice2","version":1}`, nil, http.StatusOK, "alice2"},
+		{"upsert existing with stale version", "/users/1?upsert=true", `{"name":"alice2","version":2}`, nil, http.StatusConflict, "alice"},
+		{"upsert existing with If-Match", "/users/1?upsert=true", `{"name":"alice2"}`, http.Header{"If-Match": {`W/"1"`}}, http.StatusOK, "alice2"},
+		{"upsert existing with stale If-Match", "/users/1?upsert=true", `{"name":"alice2"}`, http.Header{"If-Match": {`W/"2"`}}, http.StatusConflict, "alice"},
+		{"upsert existing with If-Match any", "/users/1?upsert=true", `{"name":"alice2"}`, http.Header{"If-Match": {"*"}}, http.StatusOK, "alice2"},
+		{"upsert existing modified since", "/users/1?upsert=true", `{"name":"alice2","version":1}`, http.Header{"If-Unmodified-Since": {past}}, http.StatusPreconditionFailed, "alice"},
+		{"upsert new without version", "/users/5?upsert=true", `{"name":"bob"}`, nil, http.StatusCreated, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.doWithHeader(http.MethodPut, tt.path, tt.body, tt.header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("PUT %s with %v: status = %d, want %d: %s", tt.path, tt.header, res.StatusCode, tt.wantStatus, data)
+			}
+			if u, _ := ts.GetUser(1); u.Name != tt.wantName {
+				t.Errorf("user 1 after PUT %s with %v is named %q, want %q", tt.path, tt.header, u.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestGetAllUsersPagination(t *testing.T) {
+	const total = 25
+	ts := newTestServer(t)
+	for i := 1; i <= total; i++ {
+		ts.CreateUser(fmt.Sprintf("user%02d", i))
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []int
+	}{
+		{"first page", "", idRange(1, defaultLimit)},
+		{"middle page", "?limit=5&offset=10", idRange(11, 15)},
+		{"last partial page", "?limit=10&offset=20", idRange(21, 25)},
+		{"out-of-range offset", "?offset=100", idRange(1, 0)},
+		{"negative values fall back to defaults", "?limit=-1&offset=-5", idRange(1, defaultLimit)},
+		{"invalid values fall back to defaults", "?limit=abc&offset=xyz", idRange(1, defaultLimit)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, data := ts.do(http.MethodGet, "/users"+tt.query, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users%s: status = %d: %s", tt.query, res.StatusCode, data)
+			}
+			page := decodeTestJSON[UsersResponse](t, data)
+			if page.Total != total {
+				t.Errorf("GET /users%s: total = %d, want %d", tt.query, page.Total, total)
+			}
+			if got := userIDs(page.Users); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("GET /users%s: ids = %v, want %v", tt.query, got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestMaxUsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"add", http.MethodPost, "/users", `{"name":"carol"}`},
+		{"bulk", http.MethodPost, "/users/bulk", `[{"name":"carol"}]`},
+		{"upsert", http.MethodPut, "/users/3?upsert=true", `{"name":"carol"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.store.(*InMemoryStore).maxUsers = 2 })
+			ts.CreateUser("alice")
+			bob := ts.CreateUser("bob")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != http.StatusInsufficientStorage {
+				t.Fatalf("%s %s at the limit: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, http.StatusInsufficientStorage, data)
+			}
+
+			// 削除したユーザーは数えないため、削除すると追加できる
+			if res, data := ts.do(http.MethodDelete, "/users/"+strconv.Itoa(bob.ID), ""); res.StatusCode != http.StatusOK {
+				t.Fatalf("DELETE /users/%d: status = %d: %s", bob.ID, res.StatusCode, data)
+			}
+			if res, data := ts.do(tt.method, tt.path, tt.body); res.StatusCode != http.StatusCreated {
+				t.Fatalf("%s %s after a delete: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, http.StatusCreated, data)
+			}
+			if got := ts.app.store.Count(); got != 2 {
+				t.Errorf("Count() = %d, want 2", got)
+			}
+		})
+	}
+}
+
+// TestConcurrentReadsAndWrites は一覧や取得と追加や更新を同時に行っても競合しないことを確かめる。
+// go test -raceで実行したときに、データ競合が報告されないことも確かめる。
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	const writers, readers, perWorker = 8, 8, 20
+	ts := newTestServer(t)
+	seed := ts.CreateUser("seed")
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				res, data, err := ts.send(http.MethodPost, "/users", fmt.Sprintf(`{"name":"w%d-%d"}`, w, i))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.StatusCode != http.StatusCreated {
+					t.Errorf("POST /users: status = %d: %s", res.StatusCode, data)
+				}
+			}
+		}()
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				for _, path := range []string{"/users?limit=100", "/users/" + strconv.Itoa(seed.ID), "/users/count"} {
+					res, data, err := ts.send(http.MethodGet, path, "")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if res.StatusCode != http.StatusOK {
+						t.Errorf("GET %s: status = %d: %s", path, res.StatusCode, data)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, want := ts.app.store.Count(), 1+writers*perWorker; got != want {
+		t.Errorf("Count() = %d after concurrent writes, want %d", got, want)
+	}
+	ids := userIDs(ts.app.store.All())
+	unique := slices.Clone(ids)
+	slices.Sort(unique)
+	if len(slices.Compact(unique)) != len(ids) {
+		t.Errorf("concurrent writes assigned duplicate ids: %v", ids)
+	}
+}
+
+// postConcurrently はn個のゴルーチンから同時にpathへbodyをPOSTし、返ったステータスコードを数える。
+func postConcurrently(t *testing.T, ts *testServer, n int, path, body string) map[int]int {
+	t.Helper()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		start    = make(chan struct{})
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start // できるだけ同時に送るよう、全てのゴルーチンがそろってから送る
+			res, _, err := ts.send(http.MethodPost, path, body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			statuses[res.StatusCode]++
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return statuses
+}
+
+func TestAddUserConcurrentDuplicates(t *testing.T) {
+	ts := newTestServer(t)
+
+	statuses := postConcurrently(t, ts, 2, "/users", `{"name":"alice"}`)
+	if statuses[http.StatusCreated] != 1 || statuses[http.StatusConflict] != 1 {
+		t.Errorf("statuses of two identical POST /users = %v, want one %d and one %d", statuses, http.StatusCreated, http.StatusConflict)
+	}
+	if got := ts.app.store.Count(); got != 1 {
+		t.Errorf("Count() = %d, want 1", got)
+	}
+}
+
+func TestAddUserUnlessExistsConcurrent(t *testing.T) {
+	const n = 10
+	ts := newTestServer(t)
+
+	statuses := postConcurrently(t, ts, n, "/users?unless_exists=name", `{"name":"alice"}`)
+	if statuses[http.StatusCreated] != 1 || statuses[http.StatusOK] != n-1 {
+		t.Errorf("statuses of %d POST /users?unless_exists=name = %v, want one %d and %d %d", n, statuses, http.StatusCreated, n-1, http.StatusOK)
+	}
+	if got := ts.app.store.Count(); got != 1 {
+		t.Errorf("Count() = %d, want exactly one creation", got)
+	}
+}
+
+// TestRoutes はメソッドとパスの組み合わせごとに、パスでIDを指定するルーティングで処理されることを確かめる。
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantName   string   // レスポンスのユーザーの名前（空の場合は確かめない）
+		wantList   []string // 一覧のレスポンスのユーザーの名前（nilの場合は確かめない）
+	}{
+		{http.MethodGet, "/users", "", http.StatusOK, "", []string{"alice"}},
+		{http.MethodPost, "/users", `{"name":"bob"}`, http.StatusCreated, "bob", nil},
+		{http.MethodGet, "/users/1", "", http.StatusOK, "alice", nil},
+		{http.MethodPut, "/users/1", `{"name":"alice2","version":1}`, http.StatusOK, "alice2", nil},
+		{http.MethodDelete, "/users/1", "", http.StatusOK, "alice", nil},
+		{http.MethodGet, "/users/2", "", http.StatusNotFound, "", nil},
+		// 以前のクエリパラメータでIDを指定する形式は提供しない
+		{http.MethodGet, "/get-user?id=1", "", http.StatusNotFound, "", nil},
+		{http.MethodPost, "/users/1", `{"name":"bob"}`, http.StatusMethodNotAllowed, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantName != "" {
+				if u := decodeTestJSON[User](t, data); u.Name != tt.wantName {
+					t.Errorf("%s %s returned %+v, want %s", tt.method, tt.path, u, tt.wantName)
+				}
+			}
+			if tt.wantList != nil {
+				if page := decodeTestJSON[UsersResponse](t, data); !slices.Equal(userNames(page.Users), tt.wantList) {
+					t.Errorf("%s %s returned %+v, want %v", tt.method, tt.path, page, tt.wantList)
+				}
+			}
+		})
+	}
+}
+
+// TestGracefulShutdown はShutdownが処理中のリクエストの完了を待ってからエラーなしで戻り、
+// 停止後に保存先を閉じると未保存の変更もファイルに書き出されることを確かめる。
+func TestGracefulShutdown(t *testing.T) {
+	file := filepath.Join(t.TempDir(), usersFile)
+	store := NewInMemoryStore(file)
+	stopSnapshots := store.startSnapshots(nil) // 定期的には書き出さず、停止時にだけ書き出す
+	app := NewApp(store)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := chain(app.newMux(), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				close(started)
+				<-release
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	srv := buildServer(Config{}, h)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	type result struct {
+		res *http.Response
+		err error
+	}
+	posted := make(chan result, 1)
+	go func() {
+		res, err := http.Post("http://"+l.Addr().String()+"/users", "application/json", strings.NewReader(`{"name":"alice"}`))
+		if err == nil {
+			res.Body.Close()
+		}
+		posted <- result{res, err}
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v while a request was in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() = %v, want nil", err)
+	}
+	if r := <-posted; r.err != nil || r.res.StatusCode != http.StatusCreated {
+		t.Errorf("in-flight POST /users during shutdown = %v, %v, want %d", r.res, r.err, http.StatusCreated)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Serve() = %v, want %v", err, http.ErrServerClosed)
+	}
+
+	stopSnapshots()
+	if got := userNames(NewInMemoryStore(file).All()); !slices.Equal(got, []string{"alice"}) {
+		t.Errorf("users in %s after shutdown = %v, want [alice]", file, got)
+	}
+}
+
+// TestShutdownServerLogsInFlight は停止を始めたときに処理中のリクエストの数を記録し、
+// タイムアウトまでに終わらなかった場合は残っている数を警告として記録することを確かめる。
+func TestShutdownServerLogsInFlight(t *testing.T) {
+	const slow = 2 // 停止の間も処理中のままにするリクエストの数
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		finish   bool  // 停止を始めた後に処理中のリクエストを終わらせるか
+		wantWarn int64 // 警告に記録される処理中の数（-1の場合は警告しないこと）
+	}{
+		{"finished in time", 10 * time.Second, true, -1},
+		{"timed out", 50 * time.Millisecond, false, slow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			app := NewApp(NewInMemoryStore(""))
+			started := make(chan struct{}, slow)
+			release := make(chan struct{})
+			h := app.withMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				started <- struct{}{}
+				<-release
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			srv := buildServer(Config{}, h)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(l)
+
+			var wg sync.WaitGroup
+			for i := 0; i < slow; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if res, err := http.Get("http://" + l.Addr().String() + "/users"); err == nil {
+						res.Body.Close()
+					}
+				}()
+				<-started
+			}
+			t.Cleanup(func() {
+				if !tt.finish {
+					close(release)
+				}
+				wg.Wait()
+			})
+
+			done := make(chan struct{})
+			go func() {
+				app.shutdownServer(srv, tt.timeout)
+				close(done)
+			}()
+			if tt.finish {
+				// Shutdownが待ち始めてから処理中のリクエストを終わらせる
+				time.Sleep(50 * time.Millisecond)
+				close(release)
+			}
+			<-done
+
+			records := map[string]map[string]any{}
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				record := decodeTestJSON[map[string]any](t, []byte(line))
+				records[record["msg"].(string)] = record
+			}
+			if got := records["shutting down server"]["in_flight"]; got != float64(slow) {
+				t.Errorf("shutting down server log in_flight = %v, want %d", got, slow)
+			}
+			warn, warned := records["requests did not finish before shutdown timeout"]
+			if tt.wantWarn < 0 {
+				if warned {
+					t.Errorf("unexpected shutdown timeout warning: %v", warn)
+				}
+				return
+			}
+			if !warned || warn["level"] != "WARN" || warn["in_flight"] != float64(tt.wantWarn) {
+				t.Errorf("shutdown timeout warning = %v, want in_flight %d", warn, tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestGetAllUsersNameFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantNames []string
+	}{
+		{"substring", "?name=li", []string{"alice", "Charlie"}},
+		{"case-insensitive", "?name=ALI", []string{"alice"}},
+		{"surrounding whitespace", "?name=%20bob%20", []string{"bob"}},
+		{"whitespace only is no filter", "?name=%20%20", []string{"alice", "bob", "Charlie"}},
+		{"no parameter", "", []string{"alice", "bob", "Charlie"}},
+		{"no matches", "?name=zoe", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, name := range []string{"alice", "bob", "Charlie"} {
+				ts.CreateUser(name)
+			}
+
+			res, data := ts.do(http.MethodGet, "/users"+tt.query, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users%s: status = %d: %s", tt.query, res.StatusCode, data)
+			}
+			page := decodeTestJSON[UsersResponse](t, data)
+			if got := userNames(page.Users); !slices.Equal(got, tt.wantNames) || page.Users == nil {
+				t.Errorf("GET /users%s returned %v (%s), want %v", tt.query, got, data, tt.wantNames)
+			}
+			if page.Total != len(tt.wantNames) {
+				t.Errorf("GET /users%s: total = %d, want %d", tt.query, page.Total, len(tt.wantNames))
+			}
+		})
+	}
+}
+
+// TestEmptyListsAreArrays はユーザーがいない場合も、一覧をnullではなく[]として返すことを確かめる。
+func TestEmptyListsAreArrays(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(ts *testServer)
+		path  string
+		want  string // レスポンスに含まれるはずの空の一覧
+	}{
+		{"fresh server", func(ts *testServer) {}, "/users", `"users":[]`},
+		{"after deleting every user", func(ts *testServer) {
+			ts.do(http.MethodDelete, userPath(ts.CreateUser("alice")), "")
+		}, "/users", `"users":[]`},
+		{"offset past the end", func(ts *testServer) { ts.CreateUser("alice") }, "/users?offset=10", `"users":[]`},
+		{"search", func(ts *testServer) {}, "/users/search?name=alice", `[]`},
+		{"recent", func(ts *testServer) {}, "/users/recent", `"users":[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			tt.setup(ts)
+
+			res, data := ts.do(http.MethodGet, tt.path, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s: status = %d: %s", tt.path, res.StatusCode, data)
+			}
+			if !strings.Contains(string(data), tt.want) || strings.Contains(string(data), "null") {
+				t.Errorf("GET %s = %s, want %s", tt.path, data, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatedAt(t *testing.T) {
+	clock := time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("JST", 9*60*60))
+	setTestNow(t, &clock)
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		create func() []byte
+	}{
+		{"create", func() []byte {
+			_, data := ts.do(http.MethodPost, "/users", `{"name":"alice"}`)
+			return data
+		}},
+		{"bulk", func() []byte {
+			_, data := ts.do(http.MethodPost, "/users/bulk", `[{"name":"bob"}]`)
+			return decodeTestJSON[[]json.RawMessage](t, data)[0]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.create()
+			// UTCのRFC3339で返す
+			var raw struct {
+				CreatedAt string `json:"created_at"`
+			}
+			if err := json.Unmarshal(data, &raw); err != nil {
+				t.Fatalf("decoding %s: %v", data, err)
+			}
+			if want := "2024-05-05T22:08:09Z"; raw.CreatedAt != want {
+				t.Errorf("created_at = %q, want %q", raw.CreatedAt, want)
+			}
+			u := decodeTestJSON[User](t, data)
+			if stored, _ := ts.GetUser(u.ID); !stored.CreatedAt.Equal(clock) {
+				t.Errorf("stored CreatedAt = %v, want %v", stored.CreatedAt, clock)
+			}
+		})
+	}
+}
+
+func TestAddUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantIDs    []int            // 追加されたユーザーのID
+		wantErrors ValidationErrors // 422の場合の誤り
+		wantNames  []string         // 追加後の一覧の名前
+	}{
+		{"valid batch", `[{"name":"bob"},{"name":"carol"}]`, http.StatusCreated, []int{2, 3}, nil, []string{"alice", "bob", "carol"}},
+		{"empty array", `[]`, http.StatusCreated, []int{}, nil, []string{"alice"}},
+		// 1件でも誤りがあれば、どのユーザーも追加しない
+		{"one invalid element", `[{"name":"bob"},{"name":" "}]`, http.StatusUnprocessableEntity, nil,
+			ValidationErrors{{Field: "[1].name", Message: "required"}}, []string{"alice"}},
+		{"duplicate of an existing user", `[{"name":"bob"},{"name":"Alice"}]`, http.StatusConflict, nil, nil, []string{"alice"}},
+		{"duplicate within the batch", `[{"name":"bob"},{"name":"bob"}]`, http.StatusConflict, nil, nil, []string{"alice"}},
+		{"not an array", `{"name":"bob"}`, http.StatusBadRequest, nil, nil, []string{"alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(http.MethodPost, "/users/bulk", tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST /users/bulk %s: status = %d, want %d: %s", tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			switch tt.wantStatus {
+			case http.StatusCreated:
+				if got := userIDs(decodeTestJSON[[]User](t, data)); !slices.Equal(got, tt.wantIDs) {
+					t.Errorf("POST /users/bulk %s created ids %v, want %v", tt.body, got, tt.wantIDs)
+				}
+			case http.StatusUnprocessableEntity:
+				if got := decodeTestJSON[ValidationErrorResponse](t, data).Errors; !reflect.DeepEqual(got, tt.wantErrors) {
+					t.Errorf("POST /users/bulk %s: errors = %v, want %v", tt.body, got, tt.wantErrors)
+				}
+			}
+			if got := userNames(ts.app.store.All()); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("users after POST /users/bulk %s = %v, want %v", tt.body, got, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestGetAllUsersSort(t *testing.T) {
+	tests := []struct {
+		sort       string
+		wantStatus int
+		wantNames  []string
+	}{
+		{"", http.StatusOK, []string{"carol", "alice", "Bob"}},
+		{"id", http.StatusOK, []string{"carol", "alice", "Bob"}},
+		{"-id", http.StatusOK, []string{"Bob", "alice", "carol"}},
+		{"name", http.StatusOK, []string{"alice", "Bob", "carol"}}, // 大文字と小文字は区別しない
+		{"-name", http.StatusOK, []string{"carol", "Bob", "alice"}},
+		{"age", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, name := range []string{"carol", "alice", "Bob"} {
+				ts.CreateUser(name)
+			}
+
+			path := "/users?sort=" + tt.sort
+			res, data := ts.do(http.MethodGet, path, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s: status = %d, want %d: %s", path, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if got := userNames(decodeTestJSON[UsersResponse](t, data).Users); !slices.Equal(got, tt.wantNames) {
+					t.Errorf("GET %s = %v, want %v", path, got, tt.wantNames)
+				}
+			}
+			// 並べ替えても保存されている順序は変わらない
+			if got := userNames(ts.app.store.All()); !slices.Equal(got, []string{"carol", "alice", "Bob"}) {
+				t.Errorf("stored users after GET %s = %v, want them in id order", path, got)
+			}
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantName   string // ユーザー1の名前
+		wantEmail  string // ユーザー1のメールアドレス
+	}{
+		{"name only", "/users/1", `{"name":"alice2"}`, http.StatusOK, "alice2", "alice@example.com"},
+		{"email only", "/users/1", `{"email":"a@example.com"}`, http.StatusOK, "alice", "a@example.com"},
+		// 空文字を指定した場合は、指定しない場合と区別して空にする
+		{"clear email", "/users/1", `{"email":""}`, http.StatusOK, "alice", ""},
+		{"no fields", "/users/1", `{}`, http.StatusOK, "alice", "alice@example.com"},
+		{"empty name", "/users/1", `{"name":""}`, http.StatusUnprocessableEntity, "alice", "alice@example.com"},
+		{"duplicate name", "/users/1", `{"name":"BOB"}`, http.StatusConflict, "alice", "alice@example.com"},
+		// IDはパスで指定し、ボディでは変えられない
+		{"id in the body", "/users/1", `{"id":2}`, http.StatusBadRequest, "alice", "alice@example.com"},
+		{"missing user", "/users/9", `{"name":"zoe"}`, http.StatusNotFound, "alice", "alice@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if res, data := ts.do(http.MethodPost, "/users", `{"name":"alice","email":"alice@example.com"}`); res.StatusCode != http.StatusCreated {
+				t.Fatalf("POST /users: status = %d: %s", res.StatusCode, data)
+			}
+			ts.CreateUser("bob")
+
+			res, data := ts.do(http.MethodPatch, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("PATCH %s %s: status = %d, want %d: %s", tt.path, tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			stored, _ := ts.GetUser(1)
+			if tt.wantStatus == http.StatusOK {
+				// 更新後のユーザー全体を返す
+				if got := decodeTestJSON[User](t, data); !reflect.DeepEqual(got, stored) {
+					t.Errorf("PATCH %s %s returned %+v, want the stored user %+v", tt.path, tt.body, got, stored)
+				}
+			}
+			if stored.Name != tt.wantName || stored.Email != tt.wantEmail {
+				t.Errorf("user 1 after PATCH %s %s = %+v, want name %q and email %q", tt.path, tt.body, stored, tt.wantName, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestCountUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  int
+		deleted int
+		want    string
+	}{
+		{"empty store", 0, 0, `{"count":0}`},
+		{"a few users", 3, 0, `{"count":3}`},
+		// 削除済みのユーザーは数えない
+		{"with deleted users", 3, 2, `{"count":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for i := range tt.create {
+				ts.CreateUser(fmt.Sprintf("user%d", i))
+			}
+			for id := 1; id <= tt.deleted; id++ {
+				if res, data := ts.do(http.MethodDelete, "/users/"+strconv.Itoa(id), ""); res.StatusCode != http.StatusOK {
+					t.Fatalf("DELETE /users/%d: status = %d: %s", id, res.StatusCode, data)
+				}
+			}
+
+			res, data := ts.do(http.MethodGet, "/users/count", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users/count: status = %d: %s", res.StatusCode, data)
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("GET /users/count = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string // 空の場合はファイルを作らない
+		wantErr   bool
+		wantNames []string // 追加後のGET /usersのユーザー名
+	}{
+		// ファイル内のIDは使わず、新しいIDを割り当てる
+		{"three users", `[{"id":7,"name":"alice"},{"id":7,"name":"bob"},{"name":"carol"}]`, false, []string{"alice", "bob", "carol"}},
+		{"empty list", `[]`, false, nil},
+		{"missing file", "", true, nil},
+		{"invalid json", `[{"name":"alice"}`, true, nil},
+		{"invalid user", `[{"name":"alice"},{"name":""}]`, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			path := filepath.Join(t.TempDir(), "seed.json")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := ts.app.seed(path)
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("seed(%s) error = %v, want error %v", tt.file, err, tt.wantErr)
+			}
+			if n != len(tt.wantNames) {
+				t.Errorf("seed(%s) = %d, want %d", tt.file, n, len(tt.wantNames))
+			}
+
+			res, data := ts.do(http.MethodGet, "/users", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users: status = %d: %s", res.StatusCode, data)
+			}
+			users := decodeTestJSON[UsersResponse](t, data).Users
+			if got := userNames(users); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("GET /users after seeding = %v, want %v", got, tt.wantNames)
+			}
+			if got, want := userIDs(users), idRange(1, len(tt.wantNames)); !slices.Equal(got, want) {
+				t.Errorf("seeded ids = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUpsertUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		wantStatus   int
+		wantLocation string
+		wantIDs      []int // 更新後に保存されているユーザーのID
+		wantNextID   int   // 続けてPOSTしたユーザーのID
+	}{
+		{"update existing", "/users/1?upsert=true", `{"name":"alice2","version":1}`, http.StatusOK, "", []int{1}, 2},
+		{"update existing strict", "/users/1", `{"name":"alice2","version":1}`, http.StatusOK, "", []int{1}, 2},
+		// 作成する場合は更新の元にしたバージョンがないため、versionは不要
+		{"upsert new", "/users/5?upsert=true", `{"name":"bob"}`, http.StatusCreated, "/users/5", []int{1, 5}, 6},
+		{"upsert existing without a version", "/users/1?upsert=true", `{"name":"alice2"}`, http.StatusPreconditionRequired, "", []int{1}, 2},
+		{"strict missing", "/users/5", `{"name":"bob","version":1}`, http.StatusNotFound, "", []int{1}, 2},
+		{"upsert=false missing", "/users/5?upsert=false", `{"name":"bob","version":1}`, http.StatusNotFound, "", []int{1}, 2},
+		{"upsert existing stale version", "/users/1?upsert=true", `{"name":"alice2","version":2}`, http.StatusConflict, "", []int{1}, 2},
+		{"upsert new duplicate name", "/users/5?upsert=true", `{"name":"alice"}`, http.StatusConflict, "", []int{1}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(http.MethodPut, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("PUT %s %s: status = %d, want %d: %s", tt.path, tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			if got := res.Header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("PUT %s: Location = %q, want %q", tt.path, got, tt.wantLocation)
+			}
+			if got := userIDs(ts.app.store.All()); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("ids after PUT %s = %v, want %v", tt.path, got, tt.wantIDs)
+			}
+			// 作成したIDを後のPOSTで使い回さない
+			if u := ts.CreateUser("carol"); u.ID != tt.wantNextID {
+				t.Errorf("POST /users after PUT %s got id %d, want %d", tt.path, u.ID, tt.wantNextID)
+			}
+		})
+	}
+}
+
+func TestGetAllUsersCursor(t *testing.T) {
+	const total = 25
+	tests := []struct {
+		name    string
+		limit   int
+		between func(ts *testServer, n int) // n番目のページを取得した後に実行する
+		wantIDs []int
+	}{
+		{"one per page", 1, nil, idRange(1, total)},
+		{"uneven pages", 7, nil, idRange(1, total)},
+		{"exact pages", 5, nil, idRange(1, total)},
+		{"single page", 100, nil, idRange(1, total)},
+		// 取得済みのユーザーを削除しても、残りのユーザーを飛ばさない
+		{"delete seen users between pages", 5, func(ts *testServer, n int) {
+			ts.do(http.MethodDelete, "/users/"+strconv.Itoa(n*5), "")
+		}, idRange(1, total)},
+		// 途中で追加したユーザーは最後のページに含まれる
+		{"add users between pages", 10, func(ts *testServer, n int) {
+			ts.CreateUser(fmt.Sprintf("added%d", n))
+		}, idRange(1, total+2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for i := 1; i <= total; i++ {
+				ts.CreateUser(fmt.Sprintf("user%02d", i))
+			}
+
+			var got []int
+			cursor := ""
+			for n := 1; ; n++ {
+				if n > total+10 {
+					t.Fatalf("cursor pagination did not finish; ids so far: %v", got)
+				}
+				path := fmt.Sprintf("/users?limit=%d&cursor=%s", tt.limit, cursor)
+				res, data := ts.do(http.MethodGet, path, "")
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("GET %s: status = %d: %s", path, res.StatusCode, data)
+				}
+				page := decodeTestJSON[UsersResponse](t, data)
+				if page.NextCursor == nil {
+					t.Fatalf("GET %s has no next_cursor: %s", path, data)
+				}
+				if len(page.Users) > tt.limit {
+					t.Errorf("GET %s returned %d users, want at most %d", path, len(page.Users), tt.limit)
+				}
+				got = append(got, userIDs(page.Users)...)
+				if *page.NextCursor == "" {
+					break
+				}
+				cursor = *page.NextCursor
+				if tt.between != nil {
+					tt.between(ts, n)
+				}
+			}
+			if !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("ids over all pages = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestGetAllUsersCursorInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"not base64", "?cursor=%25%25"},
+		{"not an id", "?cursor=" + base64.RawURLEncoding.EncodeToString([]byte("abc"))},
+		{"sorted by name", "?sort=name&cursor="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+			res, data := ts.do(http.MethodGet, "/users"+tt.query, "")
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("GET /users%s: status = %d, want 400: %s", tt.query, res.StatusCode, data)
+			}
+			if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "invalid_parameter" {
+				t.Errorf("GET /users%s: code = %q, want invalid_parameter", tt.query, got)
+			}
+		})
+	}
+}
+
+func TestResetUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowReset bool
+		wantStatus int
+		wantNames  []string // リセット後に残っているユーザー
+		wantNextID int      // リセット後に追加したユーザーのID
+	}{
+		{"enabled", true, http.StatusNoContent, nil, 1},
+		{"disabled", false, http.StatusForbidden, []string{"alice", "bob"}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.allowReset = tt.allowReset })
+			ts.CreateUser("alice")
+			ts.CreateUser("bob")
+			ts.CreateUser("carol")
+			ts.do(http.MethodDelete, "/users/3", "")
+
+			res, data := ts.do(http.MethodDelete, "/users", "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("DELETE /users: status = %d, want %d: %s", res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusNoContent && len(data) != 0 {
+				t.Errorf("DELETE /users: body = %s, want none", data)
+			}
+			if got := userNames(ts.app.store.All()); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("users after DELETE /users = %v, want %v", got, tt.wantNames)
+			}
+			if u := ts.CreateUser("dave"); u.ID != tt.wantNextID {
+				t.Errorf("POST /users after DELETE /users got id %d, want %d", u.ID, tt.wantNextID)
+			}
+		})
+	}
+}
+
+func TestGetUserID(t *testing.T) {
+	tests := []struct {
+		id         string
+		wantStatus int
+		wantCode   string
+	}{
+		{"1", http.StatusOK, ""},
+		{"2", http.StatusOK, ""},
+		{"9", http.StatusNotFound, "not_found"},
+		{"0", http.StatusNotFound, "not_found"},
+		{"-1", http.StatusNotFound, "not_found"},
+		{"abc", http.StatusBadRequest, "invalid_id"},
+		{"1.5", http.StatusBadRequest, "invalid_id"},
+		{"1abc", http.StatusBadRequest, "invalid_id"},
+		{"99999999999999999999", http.StatusBadRequest, "invalid_id"},
+	}
+	ts := newTestServer(t)
+	ts.CreateUser("alice")
+	ts.CreateUser("bob")
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			res, data := ts.do(http.MethodGet, "/users/"+tt.id, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /users/%s: status = %d, want %d: %s", tt.id, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if u := decodeTestJSON[User](t, data); strconv.Itoa(u.ID) != tt.id {
+					t.Errorf("GET /users/%s returned user %d", tt.id, u.ID)
+				}
+				return
+			}
+			if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != tt.wantCode {
+				t.Errorf("GET /users/%s: code = %q, want %q", tt.id, got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAddUserLocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		idStrategy string
+		basePath   string
+		prefer     string
+	}{
+		{"sequential", idStrategySequential, "", ""},
+		{"uuid", idStrategyUUID, "", ""},
+		{"base path", idStrategySequential, "/api/v1", ""},
+		// ボディを返さない場合もLocationで取得先を示す
+		{"return=minimal", idStrategySequential, "", "return=minimal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(NewInMemoryStore(""))
+			app.idStrategy = tt.idStrategy
+			srv := httptest.NewServer(mountBasePath(app.newMux(), tt.basePath))
+			t.Cleanup(srv.Close)
+			ts := &testServer{Server: srv, t: t, app: app}
+			ts.do(http.MethodPost, tt.basePath+"/users", `{"name":"alice"}`)
+
+			header := http.Header{}
+			if tt.prefer != "" {
+				header.Set("Prefer", tt.prefer)
+			}
+			res, data := ts.doWithHeader(http.MethodPost, tt.basePath+"/users", `{"name":"bob"}`, header)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("POST %s/users: status = %d: %s", tt.basePath, res.StatusCode, data)
+			}
+			location := res.Header.Get("Location")
+			if tt.prefer == "" {
+				if want := tt.basePath + userPath(decodeTestJSON[User](t, data)); location != want {
+					t.Errorf("Location = %q, want %q", location, want)
+				}
+			}
+
+			// Locationはそのまま作成したユーザーの取得に使える
+			res, data = ts.do(http.MethodGet, location, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET %s: status = %d: %s", location, res.StatusCode, data)
+			}
+			if u := decodeTestJSON[User](t, data); u.Name != "bob" {
+				t.Errorf("GET %s returned %q, want bob", location, u.Name)
+			}
+		})
+	}
+}
+
+// TestSoftDelete は削除したユーザーを既定では隠し、include_deleted=trueで削除日時とともに返すことを確かめる。
+func TestSoftDelete(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantIDs    []int // 返すユーザーのID
+	}{
+		{"list hides deleted", "/users", http.StatusOK, []int{1, 3}},
+		{"list with include_deleted", "/users?include_deleted=true", http.StatusOK, []int{1, 2, 3}},
+		{"list with include_deleted=false", "/users?include_deleted=false", http.StatusOK, []int{1, 3}},
+		{"get hides deleted", "/users/2", http.StatusNotFound, nil},
+		{"get with include_deleted", "/users/2?include_deleted=true", http.StatusOK, []int{2}},
+		{"count hides deleted", "/users/count", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for _, name := range []string{"alice", "bob", "carol"} {
+				ts.CreateUser(name)
+			}
+			clock := deletedAt
+			setTestNow(t, &clock)
+			if res, data := ts.do(http.MethodDelete, "/users/2", ""); res.StatusCode != http.StatusOK {
+				t.Fatalf("DELETE /users/2: status = %d: %s", res.StatusCode, data)
+			}
+
+			res, data := ts.do(http.MethodGet, tt.path, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s: status = %d, want %d: %s", tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			var users []User
+			switch {
+			case tt.wantStatus != http.StatusOK:
+				return
+			case strings.HasPrefix(tt.path, "/users/count"):
+				if got := decodeTestJSON[CountResponse](t, data).Count; got != 2 {
+					t.Errorf("GET %s = %d, want 2", tt.path, got)
+				}
+				return
+			case strings.HasPrefix(tt.path, "/users/2"):
+				users = []User{decodeTestJSON[User](t, data)}
+			default:
+				users = decodeTestJSON[UsersResponse](t, data).Users
+			}
+			if got := userIDs(users); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("GET %s: ids = %v, want %v", tt.path, got, tt.wantIDs)
+			}
+			for _, u := range users {
+				if wantDeleted := u.ID == 2; u.Deleted != wantDeleted || (u.DeletedAt != nil) != wantDeleted {
+					t.Errorf("GET %s: user %d has deleted %v at %v, want deleted %v", tt.path, u.ID, u.Deleted, u.DeletedAt, wantDeleted)
+				} else if wantDeleted && !u.DeletedAt.Equal(deletedAt) {
+					t.Errorf("GET %s: user 2 deleted at %v, want %v", tt.path, u.DeletedAt, deletedAt)
+				}
+			}
+		})
+	}
+}
+
+// TestSoftDeleteRecreateName は削除したユーザーと同じ名前のユーザーを新しいIDで作成でき、削除済みのユーザーも残ることを確かめる。
+func TestSoftDeleteRecreateName(t *testing.T) {
+	for _, st := range testStores {
+		t.Run(st.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.store = st.open(t) })
+			ts.CreateUser("alice")
+			if res, data := ts.do(http.MethodDelete, "/users/1", ""); res.StatusCode != http.StatusOK {
+				t.Fatalf("DELETE /users/1: status = %d: %s", res.StatusCode, data)
+			}
+
+			if u := ts.CreateUser("alice"); u.ID != 2 {
+				t.Errorf("re-created alice got id %d, want 2", u.ID)
+			}
+			_, data := ts.do(http.MethodGet, "/users?include_deleted=true", "")
+			users := decodeTestJSON[UsersResponse](t, data).Users
+			if got := userIDs(users); !slices.Equal(got, []int{1, 2}) {
+				t.Errorf("users with include_deleted = %v, want [1 2]", got)
+			}
+			if got := userNames(users); !slices.Equal(got, []string{"alice", "alice"}) {
+				t.Errorf("names with include_deleted = %v, want both alices", got)
+			}
+		})
+	}
+}
+
+// TestHeadUsers は一覧へのHEADがGETと同じステータスコードとヘッダを返し、ボディを返さないことを確かめる。
+func TestHeadUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		accept     string
+		wantStatus int
+		headers    []string // GETと同じ値であることを確かめるヘッダ
+	}{
+		{"json", "/users", "", http.StatusOK, []string{"Content-Type", "Link"}},
+		{"ndjson", "/users", ndjsonMediaType, http.StatusOK, []string{"Content-Type", "X-Total-Count"}},
+		{"csv", "/users?format=csv", "", http.StatusOK, []string{"Content-Type", "X-Total-Count"}},
+		{"invalid sort", "/users?sort=age", "", http.StatusBadRequest, []string{"Content-Type"}},
+		{"count", "/users/count", "", http.StatusOK, []string{"Content-Type"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+			ts.CreateUser("bob")
+			header := http.Header{}
+			if tt.accept != "" {
+				header.Set("Accept", tt.accept)
+			}
+
+			getRes, _ := ts.doWithHeader(http.MethodGet, tt.path, "", header)
+			res, data := ts.doWithHeader(http.MethodHead, tt.path, "", header)
+			if res.StatusCode != tt.wantStatus || getRes.StatusCode != tt.wantStatus {
+				t.Fatalf("HEAD %s: status = %d (GET %d), want %d", tt.path, res.StatusCode, getRes.StatusCode, tt.wantStatus)
+			}
+			if len(data) != 0 {
+				t.Errorf("HEAD %s returned a body: %s", tt.path, data)
+			}
+			for _, h := range tt.headers {
+				if got, want := res.Header.Get(h), getRes.Header.Get(h); got != want || got == "" {
+					t.Errorf("HEAD %s: %s = %q, want %q as for GET", tt.path, h, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddUserDryRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		body       string
+		wantStatus int
+		wantNames  []string // リクエスト後に保存されているユーザー
+	}{
+		{"dry run", "?dry_run=true", `{"name":" bob ","email":"bob@example.com"}`, http.StatusOK, []string{"alice"}},
+		{"normal request", "", `{"name":"bob"}`, http.StatusCreated, []string{"alice", "bob"}},
+		{"dry_run=false", "?dry_run=false", `{"name":"bob"}`, http.StatusCreated, []string{"alice", "bob"}},
+		// 保存しない場合も検証と重複の確認は行う
+		{"dry run invalid", "?dry_run=true", `{"name":""}`, http.StatusUnprocessableEntity, []string{"alice"}},
+		{"dry run duplicate", "?dry_run=true", `{"name":"alice"}`, http.StatusConflict, []string{"alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			setTestNow(t, &created)
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(http.MethodPost, "/users"+tt.query, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST /users%s %s: status = %d, want %d: %s", tt.query, tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			if got := userNames(ts.app.store.All()); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("users after POST /users%s = %v, want %v", tt.query, got, tt.wantNames)
+			}
+			if tt.wantStatus == http.StatusOK {
+				// 保存しないためIDとLocationは返さず、それ以外は追加した場合の値を返す
+				want := User{Name: "bob", Email: "bob@example.com", Version: 1, CreatedAt: created, UpdatedAt: created}
+				if got := decodeTestJSON[User](t, data); !reflect.DeepEqual(got, want) {
+					t.Errorf("POST /users%s = %+v, want %+v", tt.query, got, want)
+				}
+				if got := res.Header.Get("Location"); got != "" {
+					t.Errorf("POST /users%s: Location = %q, want none", tt.query, got)
+				}
+			}
+			// 保存しなかったIDは次の追加で使う
+			if u := ts.CreateUser("carol"); u.ID != len(tt.wantNames)+1 {
+				t.Errorf("next POST /users got id %d, want %d", u.ID, len(tt.wantNames)+1)
+			}
+		})
+	}
+}
+
+// parseLinks はLinkヘッダの値をrelごとのURLに分けて返す。
+func parseLinks(t *testing.T, header string) map[string]string {
+	t.Helper()
+	links := map[string]string{}
+	if header == "" {
+		return links
+	}
+	for _, part := range strings.Split(header, ", ") {
+		target, params, ok := strings.Cut(part, ">; ")
+		rel, found := strings.CutPrefix(params, "rel=")
+		if !ok || !found || !strings.HasPrefix(target, "<") {
+			t.Fatalf("malformed Link header %q", header)
+		}
+		links[strings.Trim(rel, `"`)] = strings.TrimPrefix(target, "<")
+	}
+	return links
+}
+
+func TestGetAllUsersLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		users int
+		query string
+		want  map[string]string
+	}{
+		{"first page", 25, "?limit=10", map[string]string{
+			"first": "/users?limit=10&offset=0",
+			"next":  "/users?limit=10&offset=10",
+			"last":  "/users?limit=10&offset=20",
+		}},
+		{"middle page", 25, "?limit=10&offset=10", map[string]string{
+			"first": "/users?limit=10&offset=0",
+			"prev":  "/users?limit=10&offset=0",
+			"next":  "/users?limit=10&offset=20",
+			"last":  "/users?limit=10&offset=20",
+		}},
+		{"last page", 25, "?limit=10&offset=20", map[string]string{
+			"first": "/users?limit=10&offset=0",
+			"prev":  "/users?limit=10&offset=10",
+			"last":  "/users?limit=10&offset=20",
+		}},
+		// 前のページは先頭より前にならない
+		{"unaligned offset", 25, "?limit=10&offset=5", map[string]string{
+			"first": "/users?limit=10&offset=0",
+			"prev":  "/users?limit=10&offset=0",
+			"next":  "/users?limit=10&offset=15",
+			"last":  "/users?limit=10&offset=20",
+		}},
+		{"exact multiple", 20, "?limit=10&offset=10", map[string]string{
+			"first": "/users?limit=10&offset=0",
+			"prev":  "/users?limit=10&offset=0",
+			"last":  "/users?limit=10&offset=10",
+		}},
+		{"no users", 0, "", map[string]string{
+			"first": "/users?limit=20&offset=0",
+			"last":  "/users?limit=20&offset=0",
+		}},
+		// 絞り込みの条件は引き継ぐ
+		{"keeps filters", 25, "?name=user&sort=-id&limit=10", map[string]string{
+			"first": "/users?limit=10&name=user&offset=0&sort=-id",
+			"next":  "/users?limit=10&name=user&offset=10&sort=-id",
+			"last":  "/users?limit=10&name=user&offset=20&sort=-id",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			for i := 1; i <= tt.users; i++ {
+				ts.CreateUser(fmt.Sprintf("user%02d", i))
+			}
+			res, data := ts.do(http.MethodGet, "/users"+tt.query, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users%s: status = %d: %s", tt.query, res.StatusCode, data)
+			}
+			if got := parseLinks(t, res.Header.Get("Link")); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GET /users%s: Link = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// mergePatchMediaType はJSON Merge Patch（RFC 7386）のメディアタイプ
+const mergePatchMediaType = "application/merge-patch+json"
+
+// mergePatchUser はJSON Merge Patchで指定されたIDのユーザー情報を更新する。
+// 現在のユーザー情報のJSONにパッチを適用し、その結果をユーザー情報として読み直してから検証する。
+func (a *App) mergePatchUser(w http.ResponseWriter, r *http.Request, id int) {
+	var patch map[string]any
+	if err := decodeBody(w, r, &patch); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	// パッチを適用した結果がユーザー情報として読めない場合はリクエストの誤りとして扱う
+	var decodeErr error
+	u, err := a.store.Modify(id, func(u *User) error {
+		cur, err := toJSONObject(*u)
+		if err != nil {
+			return err
+		}
+		merged, err := json.Marshal(mergePatch(cur, patch))
+		if err != nil {
+			return err
+		}
+		var patched User
+		if decodeErr = decodeMerged(merged, &patched); decodeErr != nil {
+			return decodeErr
+		}
+		*u = patched
+		normalizeUser(u)
+		if errs := a.validateUser(*u); errs != nil {
+			return errs
+		}
+		return nil
+	})
+	if decodeErr != nil {
+		writeDecodeError(w, decodeErr)
+		return
+	}
+	if err != nil {
+		writeModifyError(w, err)
+		return
+	}
+	a.emit(EventUserUpdated, u)
+	writeJSON(w, http.StatusOK, a.publicUser(u))
+}
+
+// mergePatch はRFC 7386の規則でpatchをtargetに適用した結果を返す。
+// patchのフィールドは値で上書きし、nullのフィールドは取り除き、patchにないフィールドはそのまま残す。
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		// オブジェクト以外のパッチは値全体を置き換える
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// toJSONObject はvをJSONに変換したときのオブジェクトをマップとして返す。
+func toJSONObject(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decodeMerged はパッチを適用したJSONをユーザー情報として読み込む。
+// Userにないフィールドを追加するパッチは綴りの誤りとみなして拒否する。
+func decodeMerged(data []byte, u *User) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(u)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestMergePatch はRFC 7386の付録Aの例でmergePatchの結果を確かめる。
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		var target, patch, want any
+		for _, v := range []struct {
+			data string
+			dst  *any
+		}{{tt.target, &target}, {tt.patch, &patch}, {tt.want, &want}} {
+			if err := json.Unmarshal([]byte(v.data), v.dst); err != nil {
+				t.Fatalf("decoding %s: %v", v.data, err)
+			}
+		}
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		patch      string
+		wantStatus int
+		wantName   string
+		wantEmail  string
+	}{
+		{"overwrite name", `{"name":"alice2"}`, http.StatusOK, "alice2", "alice@example.com"},
+		{"overwrite both", `{"name":"alice2","email":"a2@example.com"}`, http.StatusOK, "alice2", "a2@example.com"},
+		{"null clears email", `{"email":null}`, http.StatusOK, "alice", ""},
+		{"absent fields are untouched", `{}`, http.StatusOK, "alice", "alice@example.com"},
+		// IDやバージョンはパッチで書き換えられない
+		{"id and version are ignored", `{"id":9,"version":9}`, http.StatusOK, "alice", "alice@example.com"},
+		{"null name fails validation", `{"name":null}`, http.StatusUnprocessableEntity, "alice", "alice@example.com"},
+		{"invalid email", `{"email":"alice"}`, http.StatusUnprocessableEntity, "alice", "alice@example.com"},
+		{"unknown field", `{"nmae":"alice2"}`, http.StatusBadRequest, "alice", "alice@example.com"},
+		{"wrong type", `{"name":1}`, http.StatusBadRequest, "alice", "alice@example.com"},
+		{"not an object", `["alice2"]`, http.StatusBadRequest, "alice", "alice@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if res, data := ts.do(http.MethodPost, "/users", `{"name":"alice","email":"alice@example.com"}`); res.StatusCode != http.StatusCreated {
+				t.Fatalf("POST /users: status = %d: %s", res.StatusCode, data)
+			}
+
+			header := http.Header{"Content-Type": {mergePatchMediaType}}
+			res, data := ts.doWithHeader(http.MethodPatch, "/users/1", tt.patch, header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("PATCH /users/1 %s: status = %d, want %d: %s", tt.patch, res.StatusCode, tt.wantStatus, data)
+			}
+			u, _ := ts.GetUser(1)
+			if u.Name != tt.wantName || u.Email != tt.wantEmail {
+				t.Errorf("user after PATCH %s = {name %q email %q}, want {name %q email %q}", tt.patch, u.Name, u.Email, tt.wantName, tt.wantEmail)
+			}
+			wantVersion := 1
+			if tt.wantStatus == http.StatusOK {
+				wantVersion = 2
+			}
+			if u.ID != 1 || u.Version != wantVersion {
+				t.Errorf("user after PATCH %s has id %d version %d, want id 1 version %d", tt.patch, u.ID, u.Version, wantVersion)
+			}
+		})
+	}
+}
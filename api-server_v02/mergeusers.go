@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// MergeRequest は同じ人物の重複したユーザーを1つにまとめるリクエストを表す構造体。
+// ユーザーはパスのidと同じく、整数のIDかUUIDで指定する。IDの割り当て方がuuidの場合はUUIDだけを受け付ける。
+type MergeRequest struct {
+	KeepID   userRef   `json:"keep_id"`   // 残すユーザー
+	MergeIDs []userRef `json:"merge_ids"` // 削除済みにするユーザー
+}
+
+// userRef はリクエストボディでユーザーを指す値で、整数のIDかUUIDの文字列を表す。
+type userRef string
+
+// UnmarshalJSON は数値の場合は整数のIDとして、文字列の場合はそのまま受け取る。
+func (ref *userRef) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*ref = userRef(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*ref = userRef(n)
+	return nil
+}
+
+// resolve はmのユーザーの指定をresolveIDでIDに変換する。指定されていない値は0とする。
+// 解釈できない指定は検証の誤りとして返し、UUIDに一致するユーザーがいない場合はErrUserNotFoundを返す。
+func (m MergeRequest) resolve(a *App) (keepID int, mergeIDs []int, errs ValidationErrors, err error) {
+	resolveRef := func(field string, ref userRef) int {
+		if ref == "" || err != nil {
+			return 0
+		}
+		id, resolveErr := a.resolveID(string(ref))
+		switch {
+		case errors.Is(resolveErr, ErrUserNotFound):
+			err = resolveErr
+		case errors.Is(resolveErr, errUUIDRequired):
+			errs = append(errs, FieldError{Field: field, Message: "must be a UUID"})
+		case resolveErr != nil:
+			errs = append(errs, FieldError{Field: field, Message: "must be an integer or a UUID"})
+		}
+		return id
+	}
+	keepID = resolveRef("keep_id", m.KeepID)
+	mergeIDs = make([]int, len(m.MergeIDs))
+	for i, ref := range m.MergeIDs {
+		mergeIDs[i] = resolveRef(fmt.Sprintf("merge_ids[%d]", i), ref)
+	}
+	return keepID, mergeIDs, errs, err
+}
+
+// validateMerge はIDに変換したリクエストの入力値を検証し、見つかった全ての誤りを返す。誤りがない場合はnilを返す。
+// ユーザーが存在するかは保存先がまとめる直前に確認する。
+func validateMerge(keepID int, mergeIDs []int) ValidationErrors {
+	var errs ValidationErrors
+	if keepID <= 0 {
+		errs = append(errs, FieldError{Field: "keep_id", Message: "must be a positive integer"})
+	}
+	if len(mergeIDs) == 0 {
+		errs = append(errs, FieldError{Field: "merge_ids", Message: "required"})
+	}
+	seen := make(map[int]bool, len(mergeIDs))
+	for i, id := range mergeIDs {
+		field := fmt.Sprintf("merge_ids[%d]", i)
+		switch {
+		case id <= 0:
+			errs = append(errs, FieldError{Field: field, Message: "must be a positive integer"})
+		case id == keepID:
+			errs = append(errs, FieldError{Field: field, Message: "must differ from keep_id"})
+		case seen[id]:
+			errs = append(errs, FieldError{Field: field, Message: "duplicate id"})
+		}
+		seen[id] = true
+	}
+	return errs
+}
+
+// mergeUsers は同じ人物として重複して登録されたユーザーを、keep_idのユーザーに1つにまとめるエンドポイントのハンドラ
+// merge_idsのユーザーは削除済みにし、残したユーザーを返す
+// 存在しないIDが1つでも含まれる場合は400を返し、どのユーザーも削除しない
+// 指定したユーザーを削除できてしまうため、allowMergeが有効な場合（ALLOW_MERGE=true）だけ受け付ける
+func (a *App) mergeUsers(w http.ResponseWriter, r *http.Request) {
+	if !a.allowMerge {
+		writeError(w, http.StatusForbidden, "forbidden", "merge is disabled")
+		return
+	}
+	var m MergeRequest
+	if err := decodeJSON(w, r, &m); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	keepID, mergeIDs, errs, err := m.resolve(a)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	}
+	if errs == nil {
+		errs = validateMerge(keepID, mergeIDs)
+	}
+	if errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	kept, merged, err := a.store.Merge(keepID, mergeIDs)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		writeError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	case err != nil:
+		log.Printf("failed to merge users: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to merge users")
+		return
+	}
+	for _, u := range merged {
+		a.emit(EventUserDeleted, u)
+	}
+	writeJSON(w, http.StatusOK, a.publicUser(kept))
+}
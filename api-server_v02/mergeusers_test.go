@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeUsers(t *testing.T) {
+	// bodyはalice、bob、carolの順に追加したユーザーからリクエストボディを作る
+	byID := func(keep string, merge ...string) func(users []User) string {
+		return func([]User) string {
+			return fmt.Sprintf(`{"keep_id":%s,"merge_ids":[%s]}`, keep, strings.Join(merge, ","))
+		}
+	}
+	byUUID := func(keep int, merge ...int) func(users []User) string {
+		return func(users []User) string {
+			refs := make([]string, len(merge))
+			for i, j := range merge {
+				refs[i] = `"` + users[j].UUID + `"`
+			}
+			return fmt.Sprintf(`{"keep_id":"%s","merge_ids":[%s]}`, users[keep].UUID, strings.Join(refs, ","))
+		}
+	}
+	tests := []struct {
+		name       string
+		disabled   bool // ALLOW_MERGEを有効にしない
+		uuid       bool // IDの割り当て方をuuidにする
+		body       func(users []User) string
+		wantStatus int
+		wantNames  []string // まとめた後の一覧の名前
+	}{
+		{"success", false, false, byID("1", "2", "3"), http.StatusOK, []string{"alice"}},
+		{"ids as strings", false, false, byID(`"1"`, `"3"`), http.StatusOK, []string{"alice", "bob"}},
+		{"disabled", true, false, byID("1", "2"), http.StatusForbidden, []string{"alice", "bob", "carol"}},
+		{"same id", false, false, byID("1", "1"), http.StatusUnprocessableEntity, []string{"alice", "bob", "carol"}},
+		{"duplicate merge ids", false, false, byID("1", "2", "2"), http.StatusUnprocessableEntity, []string{"alice", "bob", "carol"}},
+		{"no merge ids", false, false, byID("1"), http.StatusUnprocessableEntity, []string{"alice", "bob", "carol"}},
+		{"not an id", false, false, byID("1", `"abc"`), http.StatusUnprocessableEntity, []string{"alice", "bob", "carol"}},
+		{"missing user", false, false, byID("1", "2", "9"), http.StatusBadRequest, []string{"alice", "bob", "carol"}},
+		{"missing keep user", false, false, byID("9", "2"), http.StatusBadRequest, []string{"alice", "bob", "carol"}},
+		{"uuid", false, true, byUUID(0, 1, 2), http.StatusOK, []string{"alice"}},
+		{"uuid same user", false, true, byUUID(0, 0), http.StatusUnprocessableEntity, []string{"alice", "bob", "carol"}},
+		{"uuid missing user", false, true, byID(`"00000000-0000-4000-8000-000000000000"`, `"00000000-0000-4000-8000-000000000001"`), http.StatusBadRequest, []string{"alice", "bob", "carol"}},
+		{"integer ids in uuid mode", false, true, byID("1", "2"), http.StatusUnprocessableEntity, []string{"alice", "bob", "carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) {
+				a.allowMerge = !tt.disabled
+				if tt.uuid {
+					a.idStrategy = idStrategyUUID
+				}
+			})
+			var users []User
+			for _, name := range []string{"alice", "bob", "carol"} {
+				users = append(users, ts.CreateUser(name))
+			}
+
+			body := tt.body(users)
+			res, data := ts.do(http.MethodPost, "/admin/merge", body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST /admin/merge %s: status = %d, want %d: %s", body, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if kept := decodeTestJSON[User](t, data); kept.Name != "alice" || kept.UUID != users[0].UUID {
+					t.Errorf("POST /admin/merge %s returned %+v, want alice", body, kept)
+				}
+			}
+			if got := userNames(ts.app.store.All()); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("users after POST /admin/merge %s = %v, want %v", body, got, tt.wantNames)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// requestMetrics はリクエスト数の集計値を保持する構造体。
+type requestMetrics struct {
+	total    atomic.Int64    // 全リクエスト数
+	byStatus [6]atomic.Int64 // ステータスコードの分類（1xx〜5xx）ごとのリクエスト数
+	inFlight atomic.Int64    // 処理中のリクエスト数（停止時に完了を待っている数として記録する）
+}
+
+// withMetrics はリクエスト数をステータスコードの分類ごとにAppの集計値へ記録するミドルウェア
+func (a *App) withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		a.metrics.inFlight.Add(1)
+		defer a.metrics.inFlight.Add(-1)
+		next.ServeHTTP(rec, r)
+
+		a.metrics.total.Add(1)
+		if class := rec.status / 100; class >= 1 && class <= 5 {
+			a.metrics.byStatus[class].Add(1)
+		}
+	})
+}
+
+// getMetrics は集計値をPrometheusのテキスト形式で返すエンドポイントのハンドラ
+func (a *App) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP http_requests_total Total number of HTTP requests.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	fmt.Fprintf(w, "http_requests_total %d\n", a.metrics.total.Load())
+
+	fmt.Fprintln(w, "# HELP http_responses_total Number of HTTP responses by status class.")
+	fmt.Fprintln(w, "# TYPE http_responses_total counter")
+	for class := 1; class <= 5; class++ {
+		fmt.Fprintf(w, "http_responses_total{class=\"%dxx\"} %d\n", class, a.metrics.byStatus[class].Load())
+	}
+
+	fmt.Fprintln(w, "# HELP http_requests_in_flight Number of HTTP requests being processed.")
+	fmt.Fprintln(w, "# TYPE http_requests_in_flight gauge")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", a.metrics.inFlight.Load())
+
+	fmt.Fprintln(w, "# HELP users Current number of users.")
+	fmt.Fprintln(w, "# TYPE users gauge")
+	fmt.Fprintf(w, "users %d\n", a.store.Count())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetMetrics(t *testing.T) {
+	// withMetricsはmainでハンドラの外側に重ねるため、テストでも同じように重ねる
+	app := NewApp(NewInMemoryStore(""))
+	srv := httptest.NewServer(app.withMetrics(app.newMux()))
+	t.Cleanup(srv.Close)
+	ts := &testServer{Server: srv, t: t, app: app}
+
+	ts.CreateUser("alice")
+	ts.CreateUser("bob")
+	ts.do(http.MethodGet, "/users", "")
+	ts.do(http.MethodGet, "/users/9", "")
+	ts.do(http.MethodPost, "/users", `{"name":""}`)
+	ts.do(http.MethodDelete, "/users/2", "")
+
+	res, data := ts.do(http.MethodGet, "/metrics", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: status = %d: %s", res.StatusCode, data)
+	}
+	if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("GET /metrics: Content-Type = %q, want text/plain", got)
+	}
+
+	// 集計はレスポンスを書き込んだ後に行うため、/metrics自身のリクエストは含まない
+	tests := []string{
+		"http_requests_total 6",
+		`http_responses_total{class="2xx"} 4`,
+		`http_responses_total{class="4xx"} 2`,
+		`http_responses_total{class="5xx"} 0`,
+		"http_requests_in_flight 1",
+		"users 1",
+		"# TYPE http_requests_total counter",
+		"# TYPE users gauge",
+	}
+	lines := strings.Split(string(data), "\n")
+	for _, want := range tests {
+		if !slices.Contains(lines, want) {
+			t.Errorf("GET /metrics has no line %q:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// middleware はハンドラを包んで処理を追加する関数
+type middleware = func(http.Handler) http.Handler
+
+// chain はmwsでhを包んだハンドラを返す。
+// mwsは外側から内側の順に並べ、リクエストは先頭のミドルウェアから順に通る。
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// statusRecorder はハンドラが書き込んだステータスコードを記録するResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader はステータスコードを記録してから元のResponseWriterに書き込む
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap はhttp.ResponseControllerがFlushなどを元のResponseWriterに委ねられるよう、元のResponseWriterを返す
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// withLogging はリクエストごとにメソッド、パス、ステータスコード、処理時間、クライアントのIPアドレスをログに出力するミドルウェア
+// 処理時間はミリ秒単位の小数としてduration_msに出力する
+func withLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// WriteHeaderが呼ばれなかった場合は200として扱う
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"request_id", requestIDFromContext(r.Context()),
+			"client", clientIP(r),
+		)
+	})
+}
+
+// withRecovery はハンドラのpanicから回復し、サーバを止めずに500を返すミドルウェア
+// panicの内容とスタックトレースはログにだけ出力し、内部の情報をクライアントに漏らさない
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// 応答を中断するためのpanicはnet/httpに任せる
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.Error("panic",
+				"error", fmt.Sprint(p),
+				"request_id", requestIDFromContext(r.Context()),
+				"stack", string(debug.Stack()),
+			)
+			writeError(w, http.StatusInternalServerError, "internal", "internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withBodyLimit はリクエストボディの読み込みをlimitバイトまでに制限するミドルウェア
+// 巨大なボディでメモリを使い果たさないよう、上限を超えて読もうとした時点でエラーにする
+func withBodyLimit(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CORSで許可するメソッドとヘッダ
+const (
+	corsAllowMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsAllowHeaders = "Authorization, Content-Type, X-API-Key, X-Request-ID"
+)
+
+// corsPolicy はパスごとのCORSの扱い
+type corsPolicy struct {
+	allowedOrigin string // 許可するオリジン（空の場合はブラウザから別オリジンで呼び出せないようにする）
+}
+
+// corsPolicies はパスの先頭部分ごとのCORSの扱いを、allowedOriginを既定として返す。
+// 管理用のエンドポイントはブラウザから呼び出されることを想定しないため許可しない。
+func corsPolicies(allowedOrigin string) map[string]corsPolicy {
+	return map[string]corsPolicy{
+		"/":       {allowedOrigin: allowedOrigin},
+		"/admin/": {},
+	}
+}
+
+// corsPolicyFor はpoliciesのうちpathに一致する最も長い先頭部分の扱いを返す。
+// 一致するものがない場合は許可しない扱いを返す。
+func corsPolicyFor(policies map[string]corsPolicy, path string) corsPolicy {
+	best := ""
+	for prefix := range policies {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	return policies[best]
+}
+
+// withCORS はブラウザから別オリジンで呼び出せるようCORSのヘッダを付与するミドルウェア
+// どのオリジンを許可するかはパスごとにpoliciesから決める
+// OPTIONSによるプリフライトリクエストは後続のハンドラに渡さず、許可する場合は204、許可しない場合は403で応答する
+func withCORS(next http.Handler, policies map[string]corsPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := corsPolicyFor(policies, r.URL.Path)
+		if policy.allowedOrigin == "" {
+			// CORSのヘッダを付けないため、ブラウザは別オリジンからのレスポンスを読めない
+			if r.Method == http.MethodOptions {
+				writeError(w, http.StatusForbidden, "cors_forbidden", "cross-origin requests are not allowed for this path")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", policy.allowedOrigin)
+		h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+		h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		if policy.allowedOrigin != "*" {
+			// オリジンごとに応答が変わり得ることをキャッシュに伝える
+			h.Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKeyAuth はX-API-KeyヘッダがapiKeyと一致するリクエストだけを通すミドルウェア
+// apiKeyが空の場合はローカルでの開発を妨げないよう全てのリクエストを通し、起動時に一度だけ警告を出力する
+// ロードバランサからのヘルスチェックは認証の対象外とする
+func apiKeyAuth(next http.Handler, apiKey string) http.Handler {
+	if apiKey == "" {
+		slog.Warn("API_KEY is not set, all requests are allowed without authentication")
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 比較にかかる時間から値を推測されないよう、定数時間で比較する
+		got := r.Header.Get("X-API-Key")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(apiKey)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireUserAgent はUser-Agentヘッダのないリクエストを400で拒否するミドルウェア
+// 簡易的なボット除けのためのもので、ヘッダを送らない正当なクライアントのためにrequiredがfalseの場合は全て通す
+// ロードバランサからのヘルスチェックは対象外とする
+func requireUserAgent(next http.Handler, required bool) http.Handler {
+	if !required {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" && r.Header.Get("User-Agent") == "" {
+			writeError(w, http.StatusBadRequest, "missing_user_agent", "User-Agent header is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDKey はコンテキストにリクエストIDを格納するためのキーの型
+type requestIDKey struct{}
+
+// withRequestID はリクエストごとにIDを割り当ててコンテキストに格納するミドルウェア
+// X-Request-IDヘッダが指定されていればその値を引き継ぎ、なければ新しく生成する
+// 割り当てたIDはログから追跡できるようレスポンスのヘッダにも返す
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newUUID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDFromContext はコンテキストに格納されたリクエストIDを返す。格納されていない場合は空文字を返す。
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// defaultRequestTimeout はリクエストの処理にかけられる時間のデフォルト値
+const defaultRequestTimeout = 5 * time.Second
+
+// timeoutBody はタイムアウトした場合に返すレスポンスボディ
+const timeoutBody = `{"error":{"code":"timeout","message":"request timed out"}}`
+
+// withTimeout はリクエストの処理時間をtimeoutまでに制限するミドルウェア
+// 時間内に処理が終わらない場合は503を返し、r.Context()を通してハンドラにキャンセルを伝える
+// http.TimeoutHandlerはレスポンスを全て溜めてから送り、途中で送り出すこともできないため、
+// 少しずつ送るレスポンス（isStreamedResponse）は対象から外す
+func withTimeout(next http.Handler, timeout time.Duration) http.Handler {
+	th := http.TimeoutHandler(next, timeout, timeoutBody)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamedResponse(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		th.ServeHTTP(&timeoutWriter{ResponseWriter: w}, r)
+	})
+}
+
+// isStreamedResponse はrへのレスポンスを一度に組み立てず、書き込みながら少しずつ送るかを返す。
+// 接続を開いたまま送り続けるGET /eventsと、NDJSONかCSVで返すユーザー一覧が当たる。
+// 書き込みのタイムアウト（WRITE_TIMEOUT）は引き続き全体の上限となる。
+func isStreamedResponse(r *http.Request) bool {
+	if r.URL.Path == eventsPath {
+		return true
+	}
+	if r.URL.Path != "/users" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	mediaType, ok := usersMediaType(r)
+	return ok && mediaType != jsonMediaType
+}
+
+// timeoutWriter はタイムアウト時のレスポンスにContent-Typeを補うResponseWriter
+// http.TimeoutHandlerはタイムアウト時にContent-Typeを設定しないため、他のエラーと同じくJSONとして返す
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader はContent-Typeが未設定の503にJSONのContent-Typeを補ってから書き込む
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && tw.Header().Get("Content-Type") == "" {
+		tw.Header().Set("Content-Type", "application/json")
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithBodyLimit(t *testing.T) {
+	const limit = 64
+	large := `{"name":"` + strings.Repeat("a", limit) + `"}`
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"add within the limit", http.MethodPost, "/users", "application/json", `{"name":"bob"}`, http.StatusCreated},
+		{"add", http.MethodPost, "/users", "application/json", large, http.StatusRequestEntityTooLarge},
+		{"bulk", http.MethodPost, "/users/bulk", "application/json", `[` + large + `]`, http.StatusRequestEntityTooLarge},
+		{"update", http.MethodPut, "/users/1", "application/json", large, http.StatusRequestEntityTooLarge},
+		{"patch", http.MethodPatch, "/users/1", mergePatchMediaType, large, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(NewInMemoryStore(""))
+			if _, err := app.store.Add(User{Name: "alice"}); err != nil {
+				t.Fatal(err)
+			}
+			h := withBodyLimit(app.newMux(), limit)
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s %s with %d bytes: status = %d, want %d: %s", tt.method, tt.path, len(tt.body), rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusRequestEntityTooLarge {
+				return
+			}
+			var res ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Error.Code != "payload_too_large" {
+				t.Errorf("error response = %s (%v), want code payload_too_large", rec.Body, err)
+			}
+			// 上限を超えたボディの内容は保存先に反映しない
+			if u, _ := app.store.Get(1); u.Name != "alice" || app.store.Count() != 1 {
+				t.Errorf("store after a rejected body: user 1 = %+v, count = %d", u, app.store.Count())
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiKey     string // サーバに設定するキー
+		header     string // X-API-Keyヘッダの値（空の場合は送らない）
+		path       string
+		wantStatus int
+	}{
+		{"correct key", "secret", "secret", "/users", http.StatusNoContent},
+		{"wrong key", "secret", "wrong", "/users", http.StatusUnauthorized},
+		{"key with the same prefix", "secret", "secret2", "/users", http.StatusUnauthorized},
+		{"missing key", "secret", "", "/users", http.StatusUnauthorized},
+		{"health check without a key", "secret", "", "/healthz", http.StatusNoContent},
+		{"no key configured", "", "", "/users", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := apiKeyAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}), tt.apiKey)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("X-API-Key", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET %s with X-API-Key %q: status = %d, want %d: %s", tt.path, tt.header, rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusUnauthorized {
+				var res ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Error.Code != "unauthorized" {
+					t.Errorf("error response = %s (%v), want code unauthorized", rec.Body, err)
+				}
+			}
+		})
+	}
+}
+
+// captureLogs はテストの間、slogの既定のロガーの出力をJSONとしてバッファに書き込むよう切り替える。
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(newLogger(&buf, slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(orig) })
+	return &buf
+}
+
+// requestLog はwithLoggingが出力したリクエストのログを1件デコードして返す。
+func requestLog(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decoding log line %s: %v", line, err)
+		}
+		if record["msg"] == "request" {
+			return record
+		}
+	}
+	t.Fatalf("no request log in %s", buf)
+	return nil
+}
+
+func TestWithLogging(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		handler    http.HandlerFunc
+		wantStatus int
+	}{
+		{"implicit 200", http.MethodGet, "/users", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("[]"))
+		}, http.StatusOK},
+		{"created", http.MethodPost, "/users", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}, http.StatusCreated},
+		{"not found", http.MethodDelete, "/users/9", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusNotFound, "not_found", "User not found")
+		}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			rec := httptest.NewRecorder()
+			withLogging(tt.handler).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			record := requestLog(t, logs)
+			if record["method"] != tt.method || record["path"] != tt.path || record["status"] != float64(tt.wantStatus) {
+				t.Errorf("request log = %v, want method %s, path %s and status %d", record, tt.method, tt.path, tt.wantStatus)
+			}
+			if _, ok := record["duration_ms"].(float64); !ok {
+				t.Errorf("request log = %v, want a numeric duration_ms", record)
+			}
+		})
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	tests := []struct {
+		name       string
+		origin     string // 許可するオリジン
+		method     string
+		wantStatus int
+		wantNext   bool   // 後続のハンドラに渡すか
+		wantVary   string // Varyヘッダ
+	}{
+		{"preflight", "*", http.MethodOptions, http.StatusNoContent, false, ""},
+		{"normal request", "*", http.MethodGet, http.StatusOK, true, ""},
+		{"preflight for one origin", "https://app.example.com", http.MethodOptions, http.StatusNoContent, false, "Origin"},
+		{"normal request for one origin", "https://app.example.com", http.MethodPost, http.StatusOK, true, "Origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			}), corsPolicies(tt.origin))
+			req := httptest.NewRequest(tt.method, "/users", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s /users: status = %d, want %d", tt.method, rec.Code, tt.wantStatus)
+			}
+			if called != tt.wantNext {
+				t.Errorf("%s /users: next handler called = %t, want %t", tt.method, called, tt.wantNext)
+			}
+			want := map[string]string{
+				"Access-Control-Allow-Origin":  tt.origin,
+				"Access-Control-Allow-Methods": corsAllowMethods,
+				"Access-Control-Allow-Headers": corsAllowHeaders,
+				"Vary":                         tt.wantVary,
+			}
+			for k, v := range want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s /users: %s = %q, want %q", tt.method, k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestWithCORSPerRoute(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantOrigin string // Access-Control-Allow-Originヘッダ（空の場合はヘッダがないこと）
+		wantNext   bool
+	}{
+		{http.MethodOptions, "/users", http.StatusNoContent, "https://app.example.com", false},
+		{http.MethodGet, "/users/1", http.StatusOK, "https://app.example.com", true},
+		{http.MethodOptions, "/healthz", http.StatusNoContent, "https://app.example.com", false},
+		// 管理用のエンドポイントはプリフライトを断り、通常のリクエストにもヘッダを付けない
+		{http.MethodOptions, "/admin/export", http.StatusForbidden, "", false},
+		{http.MethodGet, "/admin/export", http.StatusOK, "", true},
+		{http.MethodOptions, "/admin/drain", http.StatusForbidden, "", false},
+		// 先頭部分が同じでも/admin/の下でなければ既定の扱いになる
+		{http.MethodOptions, "/administrators", http.StatusNoContent, "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			called := false
+			h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			}), corsPolicies("https://app.example.com"))
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("%s %s: Access-Control-Allow-Origin = %q, want %q", tt.method, tt.path, got, tt.wantOrigin)
+			}
+			if called != tt.wantNext {
+				t.Errorf("%s %s: next handler called = %t, want %t", tt.method, tt.path, called, tt.wantNext)
+			}
+			if tt.wantStatus == http.StatusForbidden {
+				if got := decodeTestJSON[ErrorResponse](t, rec.Body.Bytes()).Error.Code; got != "cors_forbidden" {
+					t.Errorf("%s %s: code = %q, want cors_forbidden", tt.method, tt.path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireUserAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   bool
+		path       string
+		userAgent  string // 空の場合はヘッダを送らない
+		wantStatus int
+	}{
+		{"with a user agent", true, "/users", "client/1.0", http.StatusNoContent},
+		{"without a user agent", true, "/users", "", http.StatusBadRequest},
+		{"disabled without a user agent", false, "/users", "", http.StatusNoContent},
+		{"disabled with a user agent", false, "/users", "client/1.0", http.StatusNoContent},
+		// ロードバランサのヘルスチェックはUser-Agentを送らなくても通す
+		{"health check without a user agent", true, "/healthz", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := requireUserAgent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}), tt.required)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.userAgent != "" {
+				req.Header.Set("User-Agent", tt.userAgent)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET %s with User-Agent %q: status = %d, want %d", tt.path, tt.userAgent, rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if got := decodeTestJSON[ErrorResponse](t, rec.Body.Bytes()).Error.Code; got != "missing_user_agent" {
+					t.Errorf("GET %s without User-Agent: code = %q, want missing_user_agent", tt.path, got)
+				}
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string // X-Request-IDヘッダの値（空の場合は送らない）
+	}{
+		{"incoming id is echoed", "trace-123"},
+		{"missing id is generated", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			var inContext string
+			h := withRequestID(withLogging(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				inContext = requestIDFromContext(r.Context())
+			})))
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("X-Request-ID")
+			if tt.incoming != "" && got != tt.incoming {
+				t.Errorf("X-Request-ID = %q, want the incoming %q", got, tt.incoming)
+			}
+			if tt.incoming == "" && !isUUID(got) {
+				t.Errorf("generated X-Request-ID = %q, want a UUID", got)
+			}
+			if inContext != got {
+				t.Errorf("requestIDFromContext = %q, want %q", inContext, got)
+			}
+			if record := requestLog(t, logs); record["request_id"] != got {
+				t.Errorf("request log = %v, want request_id %q", record, got)
+			}
+		})
+	}
+
+	// 生成するIDはリクエストごとに異なる
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+		if id := rec.Header().Get("X-Request-ID"); seen[id] {
+			t.Fatalf("generated request id %q twice", id)
+		} else {
+			seen[id] = true
+		}
+	}
+	if got := requestIDFromContext(context.Background()); got != "" {
+		t.Errorf("requestIDFromContext without an id = %q, want empty", got)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	tests := []struct {
+		name       string
+		path       string
+		delay      time.Duration // ハンドラが応答するまでの時間
+		wantStatus int
+		wantCancel bool // ハンドラにキャンセルが伝わるか
+	}{
+		{"fast handler", "/users", 0, http.StatusOK, false},
+		{"slow handler", "/users", time.Second, http.StatusServiceUnavailable, true},
+		// 少しずつ送るレスポンスは時間を制限しない
+		{"slow stream", "/users?format=csv", 2 * timeout, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canceled := make(chan bool, 1)
+			h := withTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					canceled <- false
+					w.WriteHeader(http.StatusOK)
+				case <-r.Context().Done():
+					canceled <- true
+				}
+			}), timeout)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET %s: status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+			if got := <-canceled; got != tt.wantCancel {
+				t.Errorf("GET %s: handler saw cancellation = %t, want %t", tt.path, got, tt.wantCancel)
+			}
+			if tt.wantStatus != http.StatusServiceUnavailable {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("timed out response: Content-Type = %q, want application/json", got)
+			}
+			var res ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Error.Code != "timeout" {
+				t.Errorf("timed out response = %s (%v), want code timeout", rec.Body, err)
+			}
+		})
+	}
+}
+
+func TestWithRecovery(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("secret detail")
+	})
+	mux.HandleFunc("/panic-error", func(w http.ResponseWriter, r *http.Request) {
+		panic(fmt.Errorf("secret detail"))
+	})
+	mux.HandleFunc("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	srv := httptest.NewServer(withRecovery(mux))
+	t.Cleanup(srv.Close)
+	ts := &testServer{Server: srv, t: t}
+
+	tests := []struct {
+		path       string
+		wantStatus int // 0の場合は応答を受け取れないこと
+		wantLog    bool
+	}{
+		{"/panic", http.StatusInternalServerError, true},
+		{"/panic-error", http.StatusInternalServerError, true},
+		// 応答を中断するpanicは500にせず、そのまま接続を切る
+		{"/abort", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			buf := captureLogs(t)
+			res, data, err := ts.send(http.MethodGet, tt.path, "")
+			if tt.wantStatus == 0 {
+				if err == nil {
+					t.Errorf("GET %s: status = %d, want the connection closed", tt.path, res.StatusCode)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("GET %s: %v", tt.path, err)
+				}
+				if res.StatusCode != tt.wantStatus {
+					t.Fatalf("GET %s: status = %d, want %d: %s", tt.path, res.StatusCode, tt.wantStatus, data)
+				}
+				got := decodeTestJSON[ErrorResponse](t, data).Error
+				if want := (ErrorBody{Code: "internal", Message: "internal server error"}); got != want {
+					t.Errorf("GET %s: error = %+v, want %+v", tt.path, got, want)
+				}
+				if strings.Contains(string(data), "secret") {
+					t.Errorf("GET %s leaks the panic to the client: %s", tt.path, data)
+				}
+			}
+
+			logs := buf.String()
+			if got := strings.Contains(logs, `"msg":"panic"`); got != tt.wantLog {
+				t.Errorf("GET %s: panic logged = %v, want %v: %s", tt.path, got, tt.wantLog, logs)
+			}
+			if tt.wantLog && (!strings.Contains(logs, "secret detail") || !strings.Contains(logs, "goroutine")) {
+				t.Errorf("GET %s: log has no panic value and stack trace: %s", tt.path, logs)
+			}
+
+			// panicの後もサーバは応答し続ける
+			if res, data := ts.do(http.MethodGet, "/ok", ""); res.StatusCode != http.StatusOK {
+				t.Errorf("GET /ok after a panic: status = %d: %s", res.StatusCode, data)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name string
+		mws  []string
+		want []string
+	}{
+		{"none", nil, []string{"handler"}},
+		{"one", []string{"a"}, []string{"a before", "handler", "a after"}},
+		// 先頭のミドルウェアが一番外側になる
+		{"outer to inner", []string{"a", "b", "c"}, []string{
+			"a before", "b before", "c before", "handler", "c after", "b after", "a after",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			mws := make([]middleware, len(tt.mws))
+			for i, name := range tt.mws {
+				mws[i] = func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						calls = append(calls, name+" before")
+						next.ServeHTTP(w, r)
+						calls = append(calls, name+" after")
+					})
+				}
+			}
+			h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "handler")
+			}), mws...)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if !slices.Equal(calls, tt.want) {
+				t.Errorf("calls = %v, want %v", calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ndjsonMediaType は改行区切りのJSON（NDJSON）のメディアタイプ
+const ndjsonMediaType = "application/x-ndjson"
+
+// ndjsonFlushEvery は何件書き込むごとにクライアントへ送り出すか
+const ndjsonFlushEvery = 100
+
+// writeNDJSON はusersを1行に1つのJSONオブジェクトとして書き込む。
+// 一覧全体をメモリ上で1つのJSONにまとめず、一定の件数ごとにクライアントへ送り出す。
+// fieldsがnilでない場合は各ユーザーのfieldsのフィールドだけを書き込む。
+// 送り出すたびにクライアントが切断していないかを確かめ、切断していれば残りを書き込まずに終える。
+func writeNDJSON(w http.ResponseWriter, r *http.Request, users []User, fields []string) {
+	w.Header().Set("Content-Type", ndjsonMediaType)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for i, u := range users {
+		var v any = u
+		if fields != nil {
+			v = projectUser(u, fields)
+		}
+		if err := enc.Encode(v); err != nil {
+			// ヘッダは送信済みのため、エラーレスポンスには切り替えられない
+			log.Printf("failed to write user %d: %v", u.ID, err)
+			return
+		}
+		if (i+1)%ndjsonFlushEvery == 0 {
+			// 途中で送り出せないResponseWriterの場合は最後にまとめて送られる
+			rc.Flush()
+			if clientGone(r) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// TestGetAllUsersNDJSON はAccept: application/x-ndjsonの一覧を1行ずつ読み、
+// 各行がJSONの一覧の同じ位置のユーザーと一致することを確かめる。
+func TestGetAllUsersNDJSON(t *testing.T) {
+	// 途中で送り出す件数を超えるユーザーを用意する
+	const total = ndjsonFlushEvery*2 + 50
+	ts := newTestServer(t)
+	for i := 1; i <= total; i++ {
+		ts.CreateUser(fmt.Sprintf("user%03d", i))
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantLines  int
+		wantCursor string // X-Next-Cursorヘッダ（空の場合はヘッダがないこと）
+	}{
+		{"all users", "?limit=" + strconv.Itoa(total), total, ""},
+		{"one page", "?limit=10&offset=5", 10, ""},
+		{"selected fields", "?limit=" + strconv.Itoa(total) + "&fields=id,name", total, ""},
+		{"cursor", "?limit=10&cursor=", 10, ts.app.encodeCursor(User{ID: 10})},
+		{"no users in range", "?offset=" + strconv.Itoa(total), 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, data := ts.do(http.MethodGet, "/users"+tt.query, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users%s: status = %d: %s", tt.query, res.StatusCode, data)
+			}
+			want := decodeTestJSON[struct{ Users []json.RawMessage }](t, data).Users
+
+			res, data = ts.doWithHeader(http.MethodGet, "/users"+tt.query, "", http.Header{"Accept": {ndjsonMediaType}})
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /users%s as NDJSON: status = %d: %s", tt.query, res.StatusCode, data)
+			}
+			if got := res.Header.Get("Content-Type"); got != ndjsonMediaType {
+				t.Errorf("Content-Type = %q, want %s", got, ndjsonMediaType)
+			}
+			if got := res.Header.Get("X-Total-Count"); got != strconv.Itoa(total) {
+				t.Errorf("X-Total-Count = %q, want %d", got, total)
+			}
+			if got := res.Header.Get("X-Next-Cursor"); got != tt.wantCursor {
+				t.Errorf("X-Next-Cursor = %q, want %q", got, tt.wantCursor)
+			}
+
+			var lines int
+			sc := bufio.NewScanner(bytes.NewReader(data))
+			for sc.Scan() {
+				if lines >= len(want) {
+					t.Fatalf("NDJSON has more than %d lines: %s", len(want), sc.Bytes())
+				}
+				var compact bytes.Buffer
+				if err := json.Compact(&compact, want[lines]); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(sc.Bytes(), compact.Bytes()) {
+					t.Errorf("line %d = %s, want %s", lines+1, sc.Bytes(), compact.Bytes())
+				}
+				if u := decodeTestJSON[User](t, sc.Bytes()); u.ID == 0 {
+					t.Errorf("line %d = %s has no id", lines+1, sc.Bytes())
+				}
+				lines++
+			}
+			if err := sc.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if lines != tt.wantLines {
+				t.Errorf("NDJSON has %d lines, want %d", lines, tt.wantLines)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// jsonMediaType はJSONのメディアタイプ
+const jsonMediaType = "application/json"
+
+// negotiate はAcceptヘッダに従い、offeredのうちクライアントが最も望むメディアタイプを選ぶ。
+// 品質値（q）が高いものを優先し、同じ場合はAcceptで先に書かれたものを選ぶ。
+// */* やapplication/* のようなワイルドカードには、offeredのうち一致する先頭のものを当てる。
+// Acceptがない場合はofferedの先頭を返し、どれも受け付けられない場合はfalseを返す。
+func negotiate(r *http.Request, offered ...string) (string, bool) {
+	accept := strings.TrimSpace(r.Header.Get("Accept"))
+	if accept == "" {
+		return offered[0], true
+	}
+
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []acceptRange
+	refused := map[string]bool{} // q=0で明示的に拒否されたメディアタイプ
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			refused[mt] = true
+			continue
+		}
+		ranges = append(ranges, acceptRange{mt, q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, ar := range ranges {
+		if ar.q <= bestQ {
+			continue
+		}
+		for _, o := range offered {
+			if !refused[o] && mediaTypeMatches(ar.mediaType, o) {
+				best, bestQ = o, ar.q
+				break
+			}
+		}
+	}
+	return best, best != ""
+}
+
+// mediaTypeMatches はAcceptの1つの範囲patternがメディアタイプmtを含むかを返す。
+func mediaTypeMatches(pattern, mt string) bool {
+	if pattern == "*/*" || pattern == mt {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(mt, prefix+"/")
+}
+
+// writeNotAcceptable はofferedのどれもAcceptに合わない場合の406を書き込む。
+// クライアントが選び直せるよう、返せるメディアタイプを示す。
+func writeNotAcceptable(w http.ResponseWriter, offered ...string) {
+	writeError(w, http.StatusNotAcceptable, "not_acceptable", "Accept must allow one of "+strings.Join(offered, ", "))
+}
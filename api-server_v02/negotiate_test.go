@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string // 空の場合は受け付けられないこと
+	}{
+		{"", jsonMediaType},
+		{"application/json", jsonMediaType},
+		{"text/csv", csvMediaType},
+		{"application/x-ndjson", ndjsonMediaType},
+		{"*/*", jsonMediaType},
+		{"text/*", csvMediaType},
+		{"application/*", jsonMediaType},
+		{"Application/JSON", jsonMediaType},
+		{"text/csv;q=0.5, application/json", jsonMediaType},
+		{"application/json;q=0.5, text/csv", csvMediaType},
+		// 品質値が同じ場合は先に書かれたものを選ぶ
+		{"text/csv, application/json", csvMediaType},
+		{"*/*;q=0.1, text/csv", csvMediaType},
+		{"application/json;q=0, */*", ndjsonMediaType},
+		{"text/html, application/xhtml+xml, */*;q=0.8", jsonMediaType},
+		{"application/xml", ""},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+		{"not a media type", ""},
+	}
+	offered := []string{jsonMediaType, ndjsonMediaType, csvMediaType}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set("Accept", tt.accept)
+		got, ok := negotiate(r, offered...)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("negotiate(Accept: %q) = %q, %v, want %q", tt.accept, got, ok, tt.want)
+		}
+	}
+}
+
+func TestGetAllUsersNotAcceptable(t *testing.T) {
+	tests := []struct {
+		path       string
+		accept     string
+		wantStatus int
+		wantType   string // Content-Typeの先頭
+	}{
+		{"/users", "application/json", http.StatusOK, jsonMediaType},
+		{"/users", "text/csv", http.StatusOK, csvMediaType},
+		{"/users", "*/*", http.StatusOK, jsonMediaType},
+		{"/users", "application/xml", http.StatusNotAcceptable, jsonMediaType},
+		// ユーザー1人の取得はJSONだけを返す
+		{"/users/1", "application/json", http.StatusOK, jsonMediaType},
+		{"/users/1", "text/csv", http.StatusNotAcceptable, jsonMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+			res, data := ts.doWithHeader(http.MethodGet, tt.path, "", http.Header{"Accept": {tt.accept}})
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s with Accept %q: status = %d, want %d: %s", tt.path, tt.accept, res.StatusCode, tt.wantStatus, data)
+			}
+			if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, tt.wantType) {
+				t.Errorf("GET %s with Accept %q: Content-Type = %q, want %s", tt.path, tt.accept, got, tt.wantType)
+			}
+			if tt.wantStatus == http.StatusNotAcceptable {
+				if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "not_acceptable" {
+					t.Errorf("GET %s with Accept %q: code = %q, want not_acceptable", tt.path, tt.accept, got)
+				}
+			}
+		})
+	}
+}
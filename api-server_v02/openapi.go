@@ -0,0 +1,533 @@
+package main
+
+import "net/http"
+
+// object はOpenAPIドキュメントを組み立てるためのJSONオブジェクト
+type object = map[string]any
+
+// schemaRef はcomponents.schemasに定義したスキーマへの参照を返す。
+func schemaRef(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonResponse はスキーマnameのJSONを返すレスポンスの定義を返す。
+func jsonResponse(description, name string) object {
+	return object{
+		"description": description,
+		"content":     object{"application/json": object{"schema": schemaRef(name)}},
+	}
+}
+
+// jsonRequestBody はスキーマnameのJSONを受け取るリクエストボディの定義を返す。
+func jsonRequestBody(name string) object {
+	return object{
+		"required": true,
+		"content":  object{"application/json": object{"schema": schemaRef(name)}},
+	}
+}
+
+// queryParam はクエリパラメータの定義を返す。
+func queryParam(name, typ, description string) object {
+	return object{"name": name, "in": "query", "schema": object{"type": typ}, "description": description}
+}
+
+// idParam はパスに含まれるユーザIDの定義
+// ID_STRATEGY=uuidの場合は整数のIDを受け付けず、UUIDだけで指定する
+var idParam = object{"name": "id", "in": "path", "required": true, "schema": object{"type": "string"}, "description": "integer id, or uuid (case-insensitive) only when ID_STRATEGY=uuid"}
+
+// ifUnmodifiedSinceParam は更新や削除の条件とするIf-Unmodified-Sinceヘッダの定義
+var ifUnmodifiedSinceParam = object{"name": "If-Unmodified-Since", "in": "header", "schema": object{"type": "string"}, "description": "HTTP date; fail with 412 if the user was updated after it"}
+
+// userRefSchema はリクエストボディでユーザーを指す値の定義で、パスのidと同じく整数のIDかUUIDを受け付ける
+var userRefSchema = object{"oneOf": []object{{"type": "integer"}, {"type": "string", "format": "uuid"}}, "description": "integer id, or uuid (only a uuid when ID_STRATEGY=uuid)"}
+
+// fieldsParam は返すフィールドを選ぶfieldsクエリパラメータの定義
+var fieldsParam = queryParam("fields", "string", "comma-separated field names to return, such as id,name (unknown names return 400; ignored for CSV)")
+
+// openAPISpec はこのAPIのOpenAPI 3.0のドキュメント
+// ハンドラを追加・変更した場合はあわせて更新する
+var openAPISpec = object{
+	"openapi": "3.0.3",
+	"info": object{
+		"title":   "api-server_v02",
+		"version": "0.2.0",
+	},
+	"paths": object{
+		"/users": object{
+			"get": object{
+				"summary": "List users",
+				"parameters": []object{
+					queryParam("limit", "integer", "maximum number of users to return (default 20)"),
+					queryParam("offset", "integer", "number of users to skip (default 0)"),
+					queryParam("include_deleted", "boolean", "include soft-deleted users"),
+					queryParam("cursor", "string", "next_cursor of the previous page; empty for the first page (overrides offset)"),
+					queryParam("name", "string", "case-insensitive substring to filter by name"),
+					{"name": "format", "in": "query", "schema": object{"type": "string", "enum": []string{"csv"}}, "description": "return CSV (same as Accept: text/csv)"},
+					{"name": "sort", "in": "query", "schema": object{"type": "string", "enum": []string{"id", "-id", "name", "-name"}}},
+					fieldsParam,
+				},
+				"responses": object{
+					"200": object{
+						"description": "users (one JSON object per line when Accept is application/x-ndjson, id and name as CSV when Accept is text/csv)",
+						"content": object{
+							"application/json":     object{"schema": schemaRef("UsersResponse")},
+							"application/x-ndjson": object{"schema": schemaRef("User")},
+							"text/csv":             object{"schema": object{"type": "string"}},
+						},
+					},
+					"400": jsonResponse("invalid parameter", "ErrorResponse"),
+					"406": jsonResponse("Accept allows none of JSON, NDJSON or CSV", "ErrorResponse"),
+				},
+			},
+			"head": object{
+				"summary": "Same as GET without the response body",
+				"responses": object{
+					"200": object{"description": "users exist"},
+					"400": object{"description": "invalid parameter"},
+				},
+			},
+			"delete": object{
+				"summary": "Delete all users (only when ALLOW_RESET=true)",
+				"responses": object{
+					"204": object{"description": "all users deleted"},
+					"403": jsonResponse("reset is disabled", "ErrorResponse"),
+				},
+			},
+			"post": object{
+				"summary": "Create a user",
+				"parameters": []object{
+					queryParam("dry_run", "boolean", "validate without saving and return 200 with id 0"),
+					{"name": "unless_exists", "in": "query", "schema": object{"type": "string", "enum": []string{"name"}}, "description": "return 200 with the existing user instead of 409 if a user with the same name exists"},
+					{"name": "Idempotency-Key", "in": "header", "schema": object{"type": "string"}, "description": "retries with the same key, query and body return the first response instead of creating another user"},
+					{"name": "Prefer", "in": "header", "schema": object{"type": "string", "enum": []string{"return=minimal", "return=representation"}}, "description": "return=minimal answers 201 with only the Location header and an empty body"},
+				},
+				"requestBody": jsonRequestBody("UserInput"),
+				"responses": object{
+					"200": jsonResponse("user that would be created (dry_run=true), the existing user with the same name (unless_exists=name), or the user created by the same body within DEDUP_WINDOW", "User"),
+					"201": jsonResponse("created user (empty body with Prefer: return=minimal)", "User"),
+					"400": jsonResponse("invalid JSON or unless_exists", "ErrorResponse"),
+					"409": jsonResponse("duplicate name, or a request with the same Idempotency-Key is in progress", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+					"422": jsonResponse("validation failed (ValidationErrorResponse), or the Idempotency-Key was used for a different request (ErrorResponse)", "ValidationErrorResponse"),
+					"507": jsonResponse("maximum number of users reached", "ErrorResponse"),
+				},
+			},
+		},
+		"/users/bulk": object{
+			"post": object{
+				"summary": "Create multiple users at once",
+				"requestBody": object{
+					"required": true,
+					"content": object{"application/json": object{"schema": object{
+						"type":  "array",
+						"items": schemaRef("UserInput"),
+					}}},
+				},
+				"responses": object{
+					"201": object{
+						"description": "created users",
+						"content": object{"application/json": object{"schema": object{
+							"type":  "array",
+							"items": schemaRef("User"),
+						}}},
+					},
+					"400": jsonResponse("invalid JSON", "ErrorResponse"),
+					"409": jsonResponse("duplicate name", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+					"422": jsonResponse("validation failed", "ValidationErrorResponse"),
+					"507": jsonResponse("maximum number of users reached", "ErrorResponse"),
+				},
+			},
+		},
+		"/users/count": object{
+			"get": object{
+				"summary": "Count users",
+				"responses": object{
+					"200": jsonResponse("number of users", "CountResponse"),
+				},
+			},
+		},
+		"/users/recent": object{
+			"get": object{
+				"summary": "Most recently created users, newest first",
+				"parameters": []object{
+					queryParam("n", "integer", "number of users to return (default 10, capped at 100)"),
+				},
+				"responses": object{
+					"200": jsonResponse("recently created users", "RecentResponse"),
+					"400": jsonResponse("n is not a positive integer", "ErrorResponse"),
+				},
+			},
+		},
+		"/users/search": object{
+			"get": object{
+				"summary": "Search users by name and id range",
+				"parameters": []object{
+					queryParam("name", "string", "case-insensitive substring to filter by name"),
+					queryParam("id_min", "integer", "minimum id (inclusive; 400 when ID_STRATEGY=uuid)"),
+					queryParam("id_max", "integer", "maximum id (inclusive; 400 when ID_STRATEGY=uuid)"),
+				},
+				"responses": object{
+					"200": object{
+						"description": "matching users ordered by id",
+						"content": object{"application/json": object{"schema": object{
+							"type":  "array",
+							"items": schemaRef("User"),
+						}}},
+					},
+					"400": jsonResponse("invalid parameter", "ErrorResponse"),
+				},
+			},
+		},
+		"/users/batch": object{
+			"get": object{
+				"summary": "Get multiple users by id",
+				"parameters": []object{
+					{"name": "ids", "in": "query", "required": true, "schema": object{"type": "string"}, "description": "comma-separated ids such as 1,2,3"},
+				},
+				"responses": object{
+					"200": jsonResponse("found users and missing ids", "BatchResponse"),
+					"400": jsonResponse("invalid ids, or ID_STRATEGY is uuid", "ErrorResponse"),
+				},
+			},
+		},
+		"/users/{id}": object{
+			"parameters": []object{idParam},
+			"get": object{
+				"summary": "Get a user",
+				"parameters": []object{
+					queryParam("include_deleted", "boolean", "return the user even if it has been soft-deleted"),
+					fieldsParam,
+				},
+				"responses": object{
+					"200": jsonResponse("user (only the requested fields when fields is given); the ETag header is W/\"<version>\" and can be sent as If-Match on PUT", "User"),
+					"304": object{"description": "not modified (If-None-Match matched the ETag)"},
+					"400": jsonResponse("id is not an integer or a UUID (only a UUID when ID_STRATEGY=uuid), or fields is invalid", "ErrorResponse"),
+					"404": jsonResponse("user not found", "ErrorResponse"),
+					"406": jsonResponse("Accept does not allow JSON", "ErrorResponse"),
+				},
+			},
+			"head": object{
+				"summary": "Check whether a user exists without encoding the user (same headers as GET except Content-Length, without the body)",
+				"responses": object{
+					"200": object{"description": "user exists"},
+					"304": object{"description": "not modified (If-None-Match matched the ETag)"},
+					"400": object{"description": "id is not an integer or a UUID (only a UUID when ID_STRATEGY=uuid)"},
+					"404": object{"description": "user not found"},
+				},
+			},
+			"put": object{
+				"summary": "Replace a user",
+				"parameters": []object{
+					queryParam("upsert", "boolean", "create the user with the given id if it does not exist; replacing an existing user still checks If-Match or version"),
+					{"name": "If-Match", "in": "header", "schema": object{"type": "string"}, "description": "comma-separated ETags from GET (W/\"<version>\") or version numbers the update is based on, or * for any current version (or send version in the body)"},
+					ifUnmodifiedSinceParam,
+				},
+				"requestBody": jsonRequestBody("UserInput"),
+				"responses": object{
+					"200": jsonResponse("updated user", "User"),
+					"201": jsonResponse("created user (upsert=true)", "User"),
+					"400": jsonResponse("invalid id or JSON", "ErrorResponse"),
+					"404": jsonResponse("user not found", "ErrorResponse"),
+					"409": jsonResponse("duplicate name or stale version", "ErrorResponse"),
+					"412": jsonResponse("modified after If-Unmodified-Since", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+					"422": jsonResponse("validation failed", "ValidationErrorResponse"),
+					"428": jsonResponse("version is required", "ErrorResponse"),
+					"507": jsonResponse("maximum number of users reached (upsert=true)", "ErrorResponse"),
+				},
+			},
+			"patch": object{
+				"summary": "Update some fields of a user",
+				"requestBody": object{
+					"required": true,
+					"content": object{
+						"application/json":             object{"schema": schemaRef("UserPatch")},
+						"application/merge-patch+json": object{"schema": schemaRef("UserPatch")},
+					},
+				},
+				"responses": object{
+					"200": jsonResponse("updated user", "User"),
+					"400": jsonResponse("invalid id or JSON", "ErrorResponse"),
+					"404": jsonResponse("user not found", "ErrorResponse"),
+					"409": jsonResponse("duplicate name", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+					"422": jsonResponse("validation failed", "ValidationErrorResponse"),
+				},
+			},
+			"delete": object{
+				"summary":    "Soft-delete a user",
+				"parameters": []object{ifUnmodifiedSinceParam},
+				"responses": object{
+					"200": jsonResponse("the deleted user", "User"),
+					"404": jsonResponse("user not found or already deleted", "ErrorResponse"),
+					"412": jsonResponse("modified after If-Unmodified-Since", "ErrorResponse"),
+				},
+			},
+		},
+		"/healthz": object{
+			"get": object{
+				"summary":  "Health check",
+				"security": []object{},
+				"responses": object{
+					"200": jsonResponse("server is healthy", "HealthResponse"),
+					"503": jsonResponse("server is draining (POST /admin/drain)", "HealthResponse"),
+				},
+			},
+		},
+		"/events": object{
+			"get": object{
+				"summary": "Stream created users as Server-Sent Events (one data line with the user JSON per event)",
+				"responses": object{
+					"200": object{
+						"description": "event stream that stays open until the client disconnects",
+						"content":     object{"text/event-stream": object{"schema": object{"type": "string"}}},
+					},
+				},
+			},
+		},
+		"/version": object{
+			"get": object{
+				"summary": "Build information of the running server",
+				"responses": object{
+					"200": jsonResponse("version, git commit and build time", "VersionResponse"),
+				},
+			},
+		},
+		"/admin/readonly": object{
+			"get": object{
+				"summary": "Whether the server is in read-only mode",
+				"responses": object{
+					"200": jsonResponse("current mode", "ReadOnly"),
+				},
+			},
+			"put": object{
+				"summary":     "Turn read-only mode on or off (writes return 503 while it is on; only when ALLOW_SET_READ_ONLY=true)",
+				"requestBody": jsonRequestBody("ReadOnly"),
+				"responses": object{
+					"200": jsonResponse("new mode", "ReadOnly"),
+					"400": jsonResponse("invalid JSON", "ErrorResponse"),
+					"403": jsonResponse("read-only toggle is disabled", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+				},
+			},
+		},
+		"/admin/drain": object{
+			"post": object{
+				"summary": "Start draining: /healthz returns 503 and the server shuts down after DRAIN_GRACE_PERIOD (only when ALLOW_DRAIN=true)",
+				"responses": object{
+					"202": jsonResponse("server is draining", "HealthResponse"),
+					"403": jsonResponse("drain is disabled", "ErrorResponse"),
+				},
+			},
+		},
+		"/admin/export": object{
+			"get": object{
+				"summary": "Export all users, including deleted ones, and the next ID as a backup (only when ALLOW_EXPORT=true; without integer ids when ID_STRATEGY=uuid)",
+				"responses": object{
+					"200": jsonResponse("backup that POST /admin/import accepts", "Backup"),
+					"403": jsonResponse("export is disabled", "ErrorResponse"),
+				},
+			},
+		},
+		"/admin/import": object{
+			"post": object{
+				"summary":     "Replace all users with a backup from GET /admin/export (only when ALLOW_IMPORT=true; when ID_STRATEGY=uuid, users without an id get new ids in order)",
+				"requestBody": jsonRequestBody("Backup"),
+				"responses": object{
+					"204": object{"description": "users replaced"},
+					"400": jsonResponse("invalid JSON", "ErrorResponse"),
+					"403": jsonResponse("import is disabled", "ErrorResponse"),
+					"409": jsonResponse("duplicate name among users that are not deleted", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+					"422": jsonResponse("invalid user, or duplicate id or uuid", "ValidationErrorResponse"),
+					"507": jsonResponse("backup exceeds MAX_USERS", "ErrorResponse"),
+				},
+			},
+		},
+		"/admin/reindex": object{
+			"post": object{
+				"summary": "Renumber live users 1..N in id order and deleted users after them (only when ALLOW_REINDEX=true)",
+				"responses": object{
+					"200": jsonResponse("mapping from old ids to new ids", "ReindexResponse"),
+					"403": jsonResponse("reindex is disabled", "ErrorResponse"),
+				},
+			},
+		},
+		"/admin/merge": object{
+			"post": object{
+				"summary":     "Soft-delete merge_ids and keep keep_id as the single user for the same person (only when ALLOW_MERGE=true)",
+				"requestBody": jsonRequestBody("MergeRequest"),
+				"responses": object{
+					"200": jsonResponse("the kept user", "User"),
+					"400": jsonResponse("invalid JSON, or keep_id or one of merge_ids does not exist (nothing is deleted)", "ErrorResponse"),
+					"403": jsonResponse("merge is disabled", "ErrorResponse"),
+					"415": jsonResponse("Content-Type is not application/json", "ErrorResponse"),
+					"422": jsonResponse("missing, duplicate or non-positive ids, or integer ids when ID_STRATEGY=uuid", "ValidationErrorResponse"),
+				},
+			},
+		},
+		"/debug/stats": object{
+			"get": object{
+				"summary": "Runtime statistics (only when DEBUG=true, otherwise 404)",
+				"responses": object{
+					"200": jsonResponse("goroutine, memory and user counts", "DebugStats"),
+					"404": object{"description": "debug endpoints are disabled"},
+				},
+			},
+		},
+		"/metrics": object{
+			"get": object{
+				"summary": "Prometheus metrics",
+				"responses": object{
+					"200": object{
+						"description": "metrics in the Prometheus text format",
+						"content":     object{"text/plain": object{"schema": object{"type": "string"}}},
+					},
+				},
+			},
+		},
+	},
+	"components": object{
+		"securitySchemes": object{
+			"apiKey":     object{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			"bearerAuth": object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		},
+		"schemas": object{
+			"User": object{
+				"type": "object",
+				"properties": object{
+					"id":         object{"type": "integer", "description": "omitted when ID_STRATEGY=uuid"},
+					"uuid":       object{"type": "string", "format": "uuid"},
+					"name":       object{"type": "string"},
+					"email":      object{"type": "string", "format": "email"},
+					"version":    object{"type": "integer"},
+					"created_at": object{"type": "string", "format": "date-time"},
+					"updated_at": object{"type": "string", "format": "date-time"},
+					"deleted":    object{"type": "boolean"},
+					"deleted_at": object{"type": "string", "format": "date-time"},
+				},
+				"required": []string{"id", "name", "version", "created_at", "updated_at"},
+			},
+			"UserInput": object{
+				"type": "object",
+				"properties": object{
+					"name":    object{"type": "string"},
+					"email":   object{"type": "string", "format": "email"},
+					"version": object{"type": "integer", "description": "current version (PUT only)"},
+				},
+				"required": []string{"name"},
+			},
+			"UserPatch": object{
+				"type": "object",
+				"properties": object{
+					"name":  object{"type": "string"},
+					"email": object{"type": "string", "format": "email"},
+				},
+			},
+			"UsersResponse": object{
+				"type": "object",
+				"properties": object{
+					"total":       object{"type": "integer"},
+					"users":       object{"type": "array", "items": schemaRef("User")},
+					"next_cursor": object{"type": "string"},
+				},
+			},
+			"BatchResponse": object{
+				"type": "object",
+				"properties": object{
+					"users":   object{"type": "array", "items": schemaRef("User")},
+					"missing": object{"type": "array", "items": object{"type": "integer"}},
+				},
+			},
+			"RecentResponse": object{
+				"type":       "object",
+				"properties": object{"users": object{"type": "array", "items": schemaRef("User")}},
+			},
+			"CountResponse": object{
+				"type":       "object",
+				"properties": object{"count": object{"type": "integer"}},
+			},
+			"VersionResponse": object{
+				"type": "object",
+				"properties": object{
+					"version":    object{"type": "string"},
+					"commit":     object{"type": "string"},
+					"build_time": object{"type": "string"},
+				},
+			},
+			"ReadOnly": object{
+				"type":       "object",
+				"properties": object{"read_only": object{"type": "boolean"}},
+			},
+			"Backup": object{
+				"type": "object",
+				"properties": object{
+					"users":   object{"type": "array", "items": schemaRef("User")},
+					"next_id": object{"type": "integer"},
+				},
+			},
+			"ReindexResponse": object{
+				"type": "object",
+				"properties": object{
+					"ids": object{"type": "object", "additionalProperties": object{"type": "integer"}, "description": "old id (as a string key) to new id"},
+				},
+			},
+			"MergeRequest": object{
+				"type":     "object",
+				"required": []string{"keep_id", "merge_ids"},
+				"properties": object{
+					"keep_id":   userRefSchema,
+					"merge_ids": object{"type": "array", "items": userRefSchema},
+				},
+			},
+			"DebugStats": object{
+				"type": "object",
+				"properties": object{
+					"goroutines":  object{"type": "integer"},
+					"heap_alloc":  object{"type": "integer"},
+					"total_alloc": object{"type": "integer"},
+					"num_gc":      object{"type": "integer"},
+					"user_count":  object{"type": "integer"},
+				},
+			},
+			"HealthResponse": object{
+				"type":       "object",
+				"properties": object{"status": object{"type": "string"}},
+			},
+			"ErrorResponse": object{
+				"type": "object",
+				"properties": object{
+					"error": object{
+						"type": "object",
+						"properties": object{
+							"code":    object{"type": "string"},
+							"message": object{"type": "string"},
+						},
+					},
+				},
+			},
+			"ValidationErrorResponse": object{
+				"type": "object",
+				"properties": object{
+					"errors": object{
+						"type": "array",
+						"items": object{
+							"type": "object",
+							"properties": object{
+								"field":   object{"type": "string"},
+								"message": object{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	"security": []object{{"apiKey": []string{}}},
+}
+
+// openAPI はAPIの仕様をOpenAPI 3.0のドキュメントとして返すハンドラ
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec)
+}
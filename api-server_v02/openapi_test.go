@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// openAPIDoc はテストで確かめるOpenAPIドキュメントの一部
+type openAPIDoc struct {
+	OpenAPI    string                    `json:"openapi"`
+	Paths      map[string]map[string]any `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]any `json:"properties"`
+			Required   []string       `json:"required"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestGetOpenAPISpec(t *testing.T) {
+	ts := newTestServer(t)
+	res, data := ts.do(http.MethodGet, "/openapi.json", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status = %d: %s", res.StatusCode, data)
+	}
+	doc := decodeTestJSON[openAPIDoc](t, data)
+	if !strings.HasPrefix(doc.OpenAPI, "3.0.") {
+		t.Errorf("openapi = %q, want 3.0.x", doc.OpenAPI)
+	}
+
+	tests := []struct {
+		path   string
+		method string
+	}{
+		{"/users", "get"},
+		{"/users", "post"},
+		{"/users", "delete"},
+		{"/users/bulk", "post"},
+		{"/users/count", "get"},
+		{"/users/recent", "get"},
+		{"/users/search", "get"},
+		{"/users/batch", "get"},
+		{"/users/{id}", "get"},
+		{"/users/{id}", "put"},
+		{"/users/{id}", "patch"},
+		{"/users/{id}", "delete"},
+		{"/healthz", "get"},
+		{"/version", "get"},
+	}
+	for _, tt := range tests {
+		if _, ok := doc.Paths[tt.path][tt.method]; !ok {
+			t.Errorf("GET /openapi.json has no %s %s", strings.ToUpper(tt.method), tt.path)
+		}
+	}
+
+	// Userのスキーマは構造体のJSONのフィールドと一致させる
+	user, ok := doc.Components.Schemas["User"]
+	if !ok {
+		t.Fatal("GET /openapi.json has no User schema")
+	}
+	var want []string
+	for _, f := range reflect.VisibleFields(reflect.TypeFor[User]()) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		want = append(want, name)
+	}
+	var got []string
+	for name := range user.Properties {
+		got = append(got, name)
+	}
+	slices.Sort(want)
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("User schema properties = %v, want %v", got, want)
+	}
+	if !slices.Contains(user.Required, "name") {
+		t.Errorf("User schema required = %v, want it to include name", user.Required)
+	}
+
+	// 参照しているスキーマは全てcomponents.schemasに定義されている
+	for _, ref := range strings.Split(string(data), `"$ref":"#/components/schemas/`)[1:] {
+		name, _, _ := strings.Cut(ref, `"`)
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("GET /openapi.json refers to undefined schema %q", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// usersFile はユーザー情報を永続化するファイルのデフォルトのパス
+const usersFile = "users.json"
+
+// loadUsers は起動時にファイルからユーザー情報を読み込む。
+// ファイルが存在しない場合は空の状態から開始し、
+// 内容が壊れている場合はログを出力したうえで空の状態から開始する。
+func (s *InMemoryStore) loadUsers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to read %s: %v", s.file, err)
+		return
+	}
+
+	var loaded []User
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		log.Printf("failed to parse %s, starting with no users: %v", s.file, err)
+		return
+	}
+	if loaded == nil {
+		loaded = []User{}
+	}
+
+	// 既存の最大IDの次の値から採番を再開する
+	// バージョンを持たない以前の形式のユーザーはバージョン1、更新日時は作成日時とみなす
+	s.users = loaded
+	s.nextID.Store(1)
+	for i, u := range s.users {
+		s.reserveID(u.ID)
+		if u.Version == 0 {
+			s.users[i].Version = 1
+		}
+		if u.UpdatedAt.IsZero() {
+			s.users[i].UpdatedAt = u.CreatedAt
+		}
+	}
+}
+
+// saveUsers はdataをユーザー情報としてファイルに書き出す。
+func (s *InMemoryStore) saveUsers(data []byte) error {
+	// 書き込み途中で落ちてもファイルが壊れないよう、一時ファイルに書いてから置き換える
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// persist は更新系の操作でユーザー情報を変更したことを記録する。
+// 書き出しはロックを解放した後にsaveChangesで行う。
+// s.muをロックした状態で呼び出すこと。
+func (s *InMemoryStore) persist() {
+	s.gen++
+}
+
+// saveChanges は更新系の操作でs.muを解放した後に、変更をファイルへ書き出す。
+// 定期的なスナップショットに切り替えている場合は、スナップショットに任せて何もしない。
+func (s *InMemoryStore) saveChanges() {
+	if s.file == "" {
+		return
+	}
+	s.mu.RLock()
+	snapshotting := s.snapshotting
+	s.mu.RUnlock()
+	if !snapshotting {
+		s.writeFile()
+	}
+}
+
+// writeFile は前回書き出してから変更があれば、ユーザー情報をファイルへ書き出す。
+// 一時的な失敗で更新を失わないよう、s.saveAttempts回まで間隔を空けて再試行する。
+// それでも失敗した場合もメモリ上の更新は有効なため、ログの出力にとどめる。
+// 再試行を待つ間も他の操作を止めないよう、s.muは内容を読み取る間だけロックする。
+// 書き出しはs.saveMuで1つずつ行い、常にその時点の最新の内容を読み取るため、
+// 先に読み取った古い内容が後から読み取った新しい内容を上書きすることはない。
+// s.muをロックした状態で呼び出さないこと。
+func (s *InMemoryStore) writeFile() {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	gen := s.gen
+	if gen == s.savedGen {
+		s.mu.RUnlock()
+		return
+	}
+	data, err := json.Marshal(s.users)
+	s.mu.RUnlock()
+	if err != nil {
+		log.Printf("failed to save users: %v", err)
+		return
+	}
+
+	if err := retry(s.saveAttempts, s.saveRetryDelay, func() error { return s.saveUsers(data) }); err != nil {
+		log.Printf("failed to save users: %v", err)
+		return
+	}
+	s.savedGen = gen
+}
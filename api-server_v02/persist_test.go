@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadUsers(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		content    *string // ファイルの内容（nilの場合はファイルを作らない）
+		wantUsers  []User
+		wantNextID int
+	}{
+		{"missing file", nil, []User{}, 1},
+		{"corrupt file", ptr(`[{"id":1,"name":`), []User{}, 1},
+		{"null", ptr(`null`), []User{}, 1},
+		{"empty list", ptr(`[]`), []User{}, 1},
+		{"next id after the highest, including deleted", ptr(`[
+			{"id":3,"name":"alice","version":2,"created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"},
+			{"id":7,"name":"bob","version":1,"created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z","deleted":true}
+		]`), []User{
+			{ID: 3, Name: "alice", Version: 2, CreatedAt: created, UpdatedAt: created},
+			{ID: 7, Name: "bob", Version: 1, CreatedAt: created, UpdatedAt: created, Deleted: true},
+		}, 8},
+		// バージョンと更新日時を持たない以前の形式のファイルも読み込める
+		{"legacy format", ptr(`[{"id":1,"name":"alice","created_at":"2024-01-01T00:00:00Z"}]`), []User{
+			{ID: 1, Name: "alice", Version: 1, CreatedAt: created, UpdatedAt: created},
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), usersFile)
+			if tt.content != nil {
+				if err := os.WriteFile(file, []byte(*tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := NewInMemoryStore(file)
+			if !reflect.DeepEqual(s.users, tt.wantUsers) {
+				t.Errorf("loaded users = %+v, want %+v", s.users, tt.wantUsers)
+			}
+			if got := int(s.nextID.Load()); got != tt.wantNextID {
+				t.Errorf("next id = %d, want %d", got, tt.wantNextID)
+			}
+		})
+	}
+}
+
+// ptr はvを指すポインタを返す。
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// TestInMemoryStorePersistsAcrossRestart は更新系の操作の結果が、同じファイルで開き直した保存先に残ることを確かめる。
+func TestInMemoryStorePersistsAcrossRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		write       func(t *testing.T, s *InMemoryStore)
+		wantNames   []string
+		wantDeleted []string
+		wantNextID  int
+	}{
+		{"add", func(t *testing.T, s *InMemoryStore) {
+			addTestUsers(t, s, "carol")
+		}, []string{"alice", "bob", "carol"}, nil, 4},
+		{"add many", func(t *testing.T, s *InMemoryStore) {
+			if _, err := s.AddMany([]User{{Name: "carol"}, {Name: "dave"}}); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"alice", "bob", "carol", "dave"}, nil, 5},
+		{"modify", func(t *testing.T, s *InMemoryStore) {
+			if _, err := s.Modify(2, func(u *User) error { u.Name = "bob2"; return nil }); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"alice", "bob2"}, nil, 3},
+		{"upsert", func(t *testing.T, s *InMemoryStore) {
+			if _, _, err := s.Upsert(User{ID: 10, Name: "carol"}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}, []string{"alice", "bob", "carol"}, nil, 11},
+		// 削除したユーザーのIDは開き直しても再び割り当てない
+		{"delete", func(t *testing.T, s *InMemoryStore) {
+			s.Delete(2)
+		}, []string{"alice"}, []string{"bob"}, 3},
+		{"reset", func(t *testing.T, s *InMemoryStore) {
+			if err := s.Reset(); err != nil {
+				t.Fatal(err)
+			}
+		}, nil, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), usersFile)
+			s := NewInMemoryStore(file)
+			addTestUsers(t, s, "alice", "bob")
+			tt.write(t, s)
+
+			reopened := NewInMemoryStore(file)
+			if got := userNames(reopened.All()); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("All() after reopening = %v, want %v", got, tt.wantNames)
+			}
+			if got := userNames(reopened.Deleted()); !slices.Equal(got, tt.wantDeleted) {
+				t.Errorf("Deleted() after reopening = %v, want %v", got, tt.wantDeleted)
+			}
+			if !reflect.DeepEqual(reopened.All(), s.All()) {
+				t.Errorf("All() after reopening = %+v, want %+v", reopened.All(), s.All())
+			}
+			if got := int(reopened.nextID.Load()); got != tt.wantNextID {
+				t.Errorf("next id after reopening = %d, want %d", got, tt.wantNextID)
+			}
+			if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left after saving: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// レート制限のデフォルト値
+const (
+	rateLimitPerSecond = 10              // クライアントごとに1秒あたりに補充されるリクエスト数
+	rateLimitBurst     = 20              // クライアントごとに連続して受け付けるリクエスト数の上限
+	rateLimitIdleTTL   = 3 * time.Minute // この時間リクエストがないクライアントの情報は破棄する
+)
+
+// tokenBucket はクライアントごとのトークンバケットの状態を表す構造体。
+type tokenBucket struct {
+	tokens float64   // 現在残っているトークン数
+	last   time.Time // 最後にトークンを補充した時刻
+}
+
+// rateLimiter はクライアントのIPアドレスごとにトークンバケット方式でリクエスト数を制限する。
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 1秒あたりに補充するトークン数
+	burst   float64 // バケットに貯められるトークン数の上限
+	clients map[string]*tokenBucket
+}
+
+// newRateLimiter は1秒あたりrate回、最大burst回まで連続してリクエストを受け付けるrateLimiterを生成する。
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+// allow はkeyのクライアントのリクエストを受け付けてよいかを返す。
+// 受け付けられない場合は、次にトークンが補充されるまでの時間も返す。
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := now()
+	b, ok := l.clients[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: t}
+		l.clients[key] = b
+	}
+
+	// 前回からの経過時間に応じてトークンを補充する
+	b.tokens = math.Min(l.burst, b.tokens+t.Sub(b.last).Seconds()*l.rate)
+	b.last = t
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup はidleより長くリクエストがないクライアントの情報を破棄する。
+// トークンは時間とともに上限まで補充されるため、破棄しても制限の結果は変わらない。
+func (l *rateLimiter) cleanup(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t := now()
+	for key, b := range l.clients {
+		if t.Sub(b.last) > idle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// cleanupLoop はinterval間隔でcleanupを呼び出し、クライアントの情報が際限なく増えるのを防ぐ。
+// サーバが動いている間ずっと実行されることを想定している。
+func (l *rateLimiter) cleanupLoop(interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.cleanup(idle)
+	}
+}
+
+// withRateLimit はクライアントのIPアドレスごとにリクエスト数を制限するミドルウェア
+// 制限を超えた場合は429とRetry-Afterヘッダを返す
+func withRateLimit(next http.Handler, l *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(clientIP(r))
+		if !ok {
+			// Retry-Afterは秒単位のため切り上げる
+			secs := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "Too Many Requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteIP はリクエスト元のIPアドレスを返す。
+// r.RemoteAddrがポート番号を含まない場合はそのまま返す。
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
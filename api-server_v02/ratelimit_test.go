@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setTestNow(t, &clock)
+	h := withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), newRateLimiter(rateLimitPerSecond, rateLimitBurst))
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 上限まではそのまま受け付ける。ポート番号が違っても同じクライアントとして数える
+	for i := 0; i < rateLimitBurst; i++ {
+		if rec := get(fmt.Sprintf("192.0.2.1:%d", 1000+i)); rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d within the burst: status = %d, want %d", i+1, rec.Code, http.StatusNoContent)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		advance        time.Duration // 送る前に進める時間
+		remoteAddr     string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"past the burst", 0, "192.0.2.1:1234", http.StatusTooManyRequests, "1"},
+		{"another client", 0, "192.0.2.2:1234", http.StatusNoContent, ""},
+		{"before a token is refilled", time.Second / rateLimitPerSecond / 2, "192.0.2.1:1234", http.StatusTooManyRequests, "1"},
+		{"after a token is refilled", time.Second / rateLimitPerSecond / 2, "192.0.2.1:1234", http.StatusNoContent, ""},
+		{"refilled token is used up", 0, "192.0.2.1:1234", http.StatusTooManyRequests, "1"},
+	}
+	for _, tt := range tests {
+		clock = clock.Add(tt.advance)
+		rec := get(tt.remoteAddr)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", tt.name, got, tt.wantRetryAfter)
+		}
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setTestNow(t, &clock)
+	l := newRateLimiter(rateLimitPerSecond, rateLimitBurst)
+	l.allow("192.0.2.1")
+	clock = clock.Add(rateLimitIdleTTL / 2)
+	l.allow("192.0.2.2")
+
+	// 最後のリクエストからidleより長く経ったクライアントだけを破棄する
+	clock = clock.Add(rateLimitIdleTTL/2 + time.Second)
+	l.cleanup(rateLimitIdleTTL)
+	if _, ok := l.clients["192.0.2.1"]; ok {
+		t.Error("cleanup kept a client that has been idle longer than the TTL")
+	}
+	if _, ok := l.clients["192.0.2.2"]; !ok {
+		t.Error("cleanup dropped a client that made a request within the TTL")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// readOnlyRetryAfter は読み取り専用の間に書き込みを断るときに、再試行まで待つよう返す時間
+const readOnlyRetryAfter = time.Minute
+
+// readOnlyPath は読み取り専用の状態を確認・切り替えるエンドポイントのパス
+// 読み取り専用の間も解除できるよう、書き込みの制限の対象から外す
+const readOnlyPath = "/admin/readonly"
+
+// ReadOnlyState は読み取り専用の状態の確認と切り替えで受け渡す構造体。
+type ReadOnlyState struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// withReadOnly は読み取り専用の間、書き込みのリクエストを503とRetry-Afterヘッダで断るミドルウェア
+// メンテナンス中も一覧の取得などの読み取りは続けて受け付ける
+func (a *App) withReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.readOnly.Load() && isWriteMethod(r.Method) && r.URL.Path != readOnlyPath && r.URL.Path != drainPath {
+			w.Header().Set("Retry-After", strconv.Itoa(int(readOnlyRetryAfter.Seconds())))
+			writeError(w, http.StatusServiceUnavailable, "read_only", "server is in read-only mode")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getReadOnly は読み取り専用の状態を返すエンドポイントのハンドラ
+func (a *App) getReadOnly(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ReadOnlyState{ReadOnly: a.readOnly.Load()})
+}
+
+// setReadOnly は読み取り専用の状態を切り替えるエンドポイントのハンドラ
+// 処理中の書き込みは止めず、切り替えた後に届いた書き込みから断る
+// 全ての書き込みを止められるため、allowSetReadOnlyが有効な場合（ALLOW_SET_READ_ONLY=true）だけ受け付ける
+func (a *App) setReadOnly(w http.ResponseWriter, r *http.Request) {
+	if !a.allowSetReadOnly {
+		writeError(w, http.StatusForbidden, "forbidden", "read-only toggle is disabled")
+		return
+	}
+	var state ReadOnlyState
+	if err := decodeJSON(w, r, &state); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	a.readOnly.Store(state.ReadOnly)
+	writeJSON(w, http.StatusOK, state)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetReadOnlyDisabled(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, data := ts.do(http.MethodPut, readOnlyPath, `{"read_only":true}`)
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("PUT %s without ALLOW_SET_READ_ONLY: status = %d, want %d: %s", readOnlyPath, res.StatusCode, http.StatusForbidden, data)
+	}
+	if ts.app.readOnly.Load() {
+		t.Error("PUT without ALLOW_SET_READ_ONLY turned read-only mode on")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	// withReadOnlyはmainでハンドラの外側に重ねるため、テストでも同じように重ねる
+	app := NewApp(NewInMemoryStore(""))
+	app.allowSetReadOnly = true
+	srv := httptest.NewServer(app.withReadOnly(app.newMux()))
+	t.Cleanup(srv.Close)
+	ts := &testServer{Server: srv, t: t, app: app}
+	ts.CreateUser("alice")
+	if res, data := ts.do(http.MethodPut, readOnlyPath, `{"read_only":true}`); res.StatusCode != http.StatusOK {
+		t.Fatalf("PUT %s: status = %d: %s", readOnlyPath, res.StatusCode, data)
+	}
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{http.MethodPost, "/users", `{"name":"bob"}`, http.StatusServiceUnavailable},
+		{http.MethodPut, "/users/1", `{"name":"alice2","version":1}`, http.StatusServiceUnavailable},
+		{http.MethodPatch, "/users/1", `{"name":"alice2"}`, http.StatusServiceUnavailable},
+		{http.MethodDelete, "/users/1", "", http.StatusServiceUnavailable},
+		{http.MethodGet, "/users", "", http.StatusOK},
+		{http.MethodGet, "/users/1", "", http.StatusOK},
+		{http.MethodGet, "/users/count", "", http.StatusOK},
+		{http.MethodGet, readOnlyPath, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s in read-only mode: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && res.Header.Get("Retry-After") == "" {
+				t.Errorf("%s %s in read-only mode: no Retry-After header", tt.method, tt.path)
+			}
+		})
+	}
+	if u, _ := ts.GetUser(1); u.Name != "alice" || ts.app.store.Count() != 1 {
+		t.Errorf("writes in read-only mode changed the store: user 1 is %+v, Count() = %d", u, ts.app.store.Count())
+	}
+
+	// 読み取り専用の間も解除でき、解除した後は書き込める
+	if res, data := ts.do(http.MethodPut, readOnlyPath, `{"read_only":false}`); res.StatusCode != http.StatusOK {
+		t.Fatalf("PUT %s in read-only mode: status = %d: %s", readOnlyPath, res.StatusCode, data)
+	}
+	ts.CreateUser("bob")
+}
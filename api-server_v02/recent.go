@@ -0,0 +1,43 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// GET /users/recentで返すユーザー数
+const (
+	defaultRecent = 10  // nを指定しない場合の件数
+	maxRecent     = 100 // nに指定できる最大の件数（超える場合はこの件数に丸める）
+)
+
+// RecentResponse は最近追加されたユーザーの取得のレスポンスを表す構造体。
+type RecentResponse struct {
+	Users []User `json:"users"` // 作成日時の新しい順
+}
+
+// getRecentUsers は最近追加されたユーザーを作成日時の新しい順にn人返すエンドポイントのハンドラ
+// アクティビティの表示のためのもので、nが保存されているユーザー数より多い場合は全員を返す
+func (a *App) getRecentUsers(w http.ResponseWriter, r *http.Request) {
+	n := defaultRecent
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_parameter", "n must be a positive integer")
+			return
+		}
+	}
+	n = min(n, maxRecent)
+
+	// 同時に作成されたユーザーは後から追加されたIDの大きい方を先にする
+	users := a.store.All()
+	slices.SortFunc(users, func(a, b User) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	writeJSON(w, http.StatusOK, RecentResponse{Users: a.publicUsers(users[:min(n, len(users))])})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGetRecentUsers(t *testing.T) {
+	// newest はIDがlastから新しい順にn人分のIDを返す
+	newest := func(last, n int) []int {
+		ids := idRange(last-n+1, last)
+		slices.Reverse(ids)
+		return ids
+	}
+	tests := []struct {
+		name       string
+		users      int
+		query      string
+		wantStatus int
+		wantIDs    []int
+	}{
+		{"within range", maxRecent + 20, "?n=5", http.StatusOK, newest(maxRecent+20, 5)},
+		{"default", maxRecent + 20, "", http.StatusOK, newest(maxRecent+20, defaultRecent)},
+		{"larger than the store", 3, "?n=5", http.StatusOK, newest(3, 3)},
+		{"no users", 0, "?n=5", http.StatusOK, []int{}},
+		// 上限を超える場合は上限の件数に丸める
+		{"exceeding the cap", maxRecent + 20, fmt.Sprintf("?n=%d", maxRecent+1), http.StatusOK, newest(maxRecent+20, maxRecent)},
+		{"zero", 3, "?n=0", http.StatusBadRequest, nil},
+		{"negative", 3, "?n=-1", http.StatusBadRequest, nil},
+		{"not a number", 3, "?n=five", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			setTestNow(t, &clock)
+			ts := newTestServer(t)
+			for i := 1; i <= tt.users; i++ {
+				clock = clock.Add(time.Second)
+				ts.CreateUser(fmt.Sprintf("user%03d", i))
+			}
+
+			res, data := ts.do(http.MethodGet, "/users/recent"+tt.query, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /users/recent%s: status = %d, want %d: %s", tt.query, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "invalid_parameter" {
+					t.Errorf("GET /users/recent%s: code = %q, want invalid_parameter", tt.query, got)
+				}
+				return
+			}
+			if got := userIDs(decodeTestJSON[RecentResponse](t, data).Users); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("GET /users/recent%s = %v, want %v", tt.query, got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// TestGetRecentUsersOrder はIDの順ではなく作成日時の新しい順に並べ、
+// 作成日時が同じ場合はIDの大きい方を先にすることを確かめる。
+func TestGetRecentUsersOrder(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setTestNow(t, &clock)
+	ts := newTestServer(t)
+	start := clock
+	for i, offset := range []time.Duration{2, 3, 1, 3} {
+		clock = start.Add(offset * time.Second)
+		ts.CreateUser(fmt.Sprintf("user%d", i+1))
+	}
+	want := []int{4, 2, 1, 3}
+	_, data := ts.do(http.MethodGet, "/users/recent", "")
+	if got := userIDs(decodeTestJSON[RecentResponse](t, data).Users); !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /users/recent = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// errUnsupportedMediaType はリクエストのContent-TypeがJSONではないことを表すエラー
+var errUnsupportedMediaType = errors.New("Content-Type must be application/json")
+
+// errEmptyBody はリクエストボディが空か空白だけであることを表すエラー
+var errEmptyBody = errors.New("request body is empty")
+
+// clientGone はクライアントの切断などでrのコンテキストが終わっているかを返す。終わっている場合はその旨をログに残す。
+// trueの場合、呼び出し側は受け取る相手のいないレスポンスを書き込まずに処理を打ち切る。
+// 件数の多い一覧や一括処理で、処理の区切りごとに呼び出す。
+func clientGone(r *http.Request) bool {
+	err := r.Context().Err()
+	if err == nil {
+		return false
+	}
+	slog.Info("request canceled", "method", r.Method, "path", r.URL.Path, "request_id", requestIDFromContext(r.Context()), "error", err)
+	return true
+}
+
+// requireJSONContentType はリクエストのContent-Typeがapplication/jsonかを確認する。
+// "application/json; charset=utf-8" のようなパラメータ付きの指定も受け付ける。
+func requireJSONContentType(r *http.Request) error {
+	if requestMediaType(r) != "application/json" {
+		return errUnsupportedMediaType
+	}
+	return nil
+}
+
+// requestMediaType はリクエストのContent-Typeからパラメータを除いたメディアタイプを返す。
+// 指定がない場合や解釈できない場合は空文字を返す。
+func requestMediaType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// preferReturn はPreferヘッダ（RFC 7240）のreturnの値を返す。
+// "return=minimal; foo, respond-async" のように複数の指定やパラメータが付いていても取り出せる。
+// 指定がない場合は空文字を返す。
+func preferReturn(r *http.Request) string {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, ok := strings.Cut(strings.TrimSpace(pref), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "return") {
+				return strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`))
+			}
+		}
+	}
+	return ""
+}
+
+// decodeJSON はリクエストボディのJSONをdstにデコードする。
+// 綴りの誤りなどを見逃さないよう未知のフィールドを拒否し、
+// JSONの後ろに余計なデータが続く場合もエラーとする。
+// Content-TypeがJSONでない場合は読み込む前にerrUnsupportedMediaTypeを返す。
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	if err := requireJSONContentType(r); err != nil {
+		return err
+	}
+	return decodeBody(w, r, dst)
+}
+
+// decodeBody はContent-Typeを確認せずにリクエストボディのJSONをdstにデコードする。
+// 未知のフィールドや後続のデータの扱いはdecodeJSONと同じ。
+// ボディのサイズはwithBodyLimitで制限し、上限を超えた場合は*http.MaxBytesErrorを返す。
+// ボディが空か空白だけの場合は、JSONの誤りと区別できるようerrEmptyBodyを返す。
+func decodeBody(w http.ResponseWriter, r *http.Request, dst any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errEmptyBody
+		}
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		// 後続のデータを読む途中でサイズの上限に達した場合はその旨を返す
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return err
+		}
+		return errors.New("request body must contain a single JSON object")
+	}
+	return nil
+}
+
+// writeDecodeError はdecodeJSONが返したエラーを対応するステータスコードのエラーレスポンスとして書き込む。
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnsupportedMediaType) {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", err.Error())
+		return
+	}
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, "payload_too_large", err.Error())
+		return
+	}
+	if errors.Is(err, errEmptyBody) {
+		writeError(w, http.StatusBadRequest, "empty_body", err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_json", decodeErrorMessage(err))
+}
+
+// decodeErrorMessage はJSONのデコードエラーを、内部の詳細を含まない分かりやすいメッセージに変換する。
+// 構文の誤りは位置を、型の誤りはフィールド名と期待する型を示す。
+func decodeErrorMessage(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at byte offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON: unexpected end of input"
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Sprintf("request body must be %s", jsonTypeName(typeErr.Type))
+		}
+		return fmt.Sprintf("%s must be %s", typeErr.Field, jsonTypeName(typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// DisallowUnknownFieldsによるエラーは専用の型がないためメッセージで判別する
+		return strings.TrimPrefix(err.Error(), "json: ")
+	}
+	return err.Error()
+}
+
+// jsonTypeName はGoの型に対応するJSONでの型の呼び方を返す。
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a valid value"
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int64 // ボディの上限（0の場合は制限しない）
+		wantStatus  int
+		wantCode    string
+		wantMessage string // エラーのメッセージに含まれる文字列
+	}{
+		{"valid", "application/json", `{"name":"alice"}`, 0, http.StatusOK, "", ""},
+		{"valid with charset", "application/json; charset=utf-8", ` {"name":"alice"} `, 0, http.StatusOK, "", ""},
+		{"missing content type", "", `{"name":"alice"}`, 0, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json"},
+		{"wrong content type", "text/plain", `{"name":"alice"}`, 0, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json"},
+		{"empty body", "application/json", ``, 0, http.StatusBadRequest, "empty_body", "request body is empty"},
+		{"whitespace body", "application/json", " \n\t", 0, http.StatusBadRequest, "empty_body", "request body is empty"},
+		{"syntax error", "application/json", `{"name":}`, 0, http.StatusBadRequest, "invalid_json", "malformed JSON at byte offset 9"},
+		{"truncated", "application/json", `{"name":"alice"`, 0, http.StatusBadRequest, "invalid_json", "unexpected end of input"},
+		{"wrong field type", "application/json", `{"name":1}`, 0, http.StatusBadRequest, "invalid_json", "name must be a string"},
+		{"not an object", "application/json", `["alice"]`, 0, http.StatusBadRequest, "invalid_json", "request body must be an object"},
+		{"unknown field", "application/json", `{"nmae":"alice"}`, 0, http.StatusBadRequest, "invalid_json", `unknown field "nmae"`},
+		{"trailing object", "application/json", `{"name":"alice"}{}`, 0, http.StatusBadRequest, "invalid_json", "single JSON object"},
+		{"trailing garbage", "application/json", `{"name":"alice"} x`, 0, http.StatusBadRequest, "invalid_json", "single JSON object"},
+		{"too large", "application/json", `{"name":"` + strings.Repeat("a", 100) + `"}`, 32, http.StatusRequestEntityTooLarge, "payload_too_large", "too large"},
+		{"too large trailing data", "application/json", `{"name":"alice"}` + strings.Repeat(" ", 100) + `x`, 32, http.StatusRequestEntityTooLarge, "payload_too_large", "too large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var u User
+				if err := decodeJSON(w, r, &u); err != nil {
+					writeDecodeError(w, err)
+					return
+				}
+				writeJSON(w, http.StatusOK, u)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			if tt.limit > 0 {
+				req.Body = http.MaxBytesReader(rec, req.Body, tt.limit)
+			}
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var u User
+				if err := json.Unmarshal(rec.Body.Bytes(), &u); err != nil || u.Name != "alice" {
+					t.Errorf("decoded user = %+v (%v), want alice", u, err)
+				}
+				return
+			}
+			var res ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("decoding error response %s: %v", rec.Body, err)
+			}
+			if res.Error.Code != tt.wantCode || !strings.Contains(res.Error.Message, tt.wantMessage) {
+				t.Errorf("error = %+v, want code %q with a message containing %q", res.Error, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
+
+// cancelOnReadStore は読み取りの回数を数え、読み取るたびにcancelを呼ぶUserStore
+type cancelOnReadStore struct {
+	UserStore
+	cancel func()
+	reads  *int
+}
+
+func (s cancelOnReadStore) Get(id int) (User, bool) {
+	*s.reads++
+	s.cancel()
+	return s.UserStore.Get(id)
+}
+
+func (s cancelOnReadStore) All() []User {
+	*s.reads++
+	s.cancel()
+	return s.UserStore.All()
+}
+
+// cancelOnFlushRecorder はクライアントへ送り出すたびにcancelを呼ぶResponseRecorder
+type cancelOnFlushRecorder struct {
+	*httptest.ResponseRecorder
+	cancel func()
+}
+
+func (rec cancelOnFlushRecorder) Flush() {
+	rec.ResponseRecorder.Flush()
+	rec.cancel()
+}
+
+// TestHandlersStopWhenClientGone は一覧や一括処理の途中でクライアントが切断した場合に、
+// 残りの処理とレスポンスの書き込みを打ち切ることを確かめる。
+func TestHandlersStopWhenClientGone(t *testing.T) {
+	const users = 250
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		accept    string
+		body      string
+		cancelOn  string // 切断させる時点（"read"は保存先の読み取り、"flush"は送り出し、"validate"は検証）
+		wantReads int    // 打ち切るまでに保存先を読み取る回数
+		wantLines int    // 打ち切るまでに書き込まれる行数
+	}{
+		{"list", http.MethodGet, "/users?limit=1000", "", "", "read", 1, 0},
+		{"list as NDJSON", http.MethodGet, "/users?limit=1000", ndjsonMediaType, "", "flush", 1, ndjsonFlushEvery},
+		{"batch", http.MethodGet, "/users/batch?ids=1,2,3,4,5", "", "", "read", 1, 0},
+		{"bulk", http.MethodPost, "/users/bulk", "", `[{"name":"x1"},{"name":"x2"},{"name":"x3"}]`, "validate", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cancelOn := func(at string) func() {
+				return func() {
+					if at == tt.cancelOn {
+						cancel()
+					}
+				}
+			}
+
+			store := NewInMemoryStore("")
+			for i := 0; i < users; i++ {
+				if _, err := store.Add(User{Name: fmt.Sprintf("user%d", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			reads := 0
+			a := NewApp(cancelOnReadStore{UserStore: store, cancel: cancelOn("read"), reads: &reads})
+			a.AddValidator("name", func(string) error {
+				cancelOn("validate")()
+				return nil
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)).WithContext(ctx)
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := cancelOnFlushRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancelOn("flush")}
+			a.newMux().ServeHTTP(rec, req)
+
+			if reads != tt.wantReads {
+				t.Errorf("%s %s read the store %d times after the client was gone, want %d", tt.method, tt.path, reads, tt.wantReads)
+			}
+			if got := strings.Count(rec.Body.String(), "\n"); got != tt.wantLines {
+				t.Errorf("%s %s wrote %d lines after the client was gone, want %d: %q", tt.method, tt.path, got, tt.wantLines, rec.Body)
+			}
+			if got := store.Count(); got != users {
+				t.Errorf("Count() = %d after %s %s, want %d", got, tt.method, tt.path, users)
+			}
+		})
+	}
+}
+
+// TestRejectUnknownFields は作成と更新で、知らないフィールドや続けて送られたJSONを400で拒否し、保存しないことを確かめる。
+func TestRejectUnknownFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		wantMessage string
+	}{
+		{"unknown field", http.MethodPost, "/users", `{"name":"bob","role":"admin"}`, `unknown field "role"`},
+		{"misspelled field", http.MethodPost, "/users", `{"naem":"bob"}`, `unknown field "naem"`},
+		{"two objects", http.MethodPost, "/users", `{"name":"bob"}{"name":"carol"}`, "single JSON object"},
+		{"misspelled field on update", http.MethodPut, "/users/1", `{"naem":"bob","version":1}`, `unknown field "naem"`},
+		{"two objects on update", http.MethodPut, "/users/1", `{"name":"bob","version":1} {}`, "single JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("%s %s %s: status = %d, want %d: %s", tt.method, tt.path, tt.body, res.StatusCode, http.StatusBadRequest, data)
+			}
+			if got := decodeTestJSON[ErrorResponse](t, data).Error; got.Code != "invalid_json" || !strings.Contains(got.Message, tt.wantMessage) {
+				t.Errorf("%s %s %s: error = %+v, want invalid_json mentioning %q", tt.method, tt.path, tt.body, got, tt.wantMessage)
+			}
+			if got := ts.app.store.All(); len(got) != 1 || got[0].Name != "alice" || got[0].Version != 1 {
+				t.Errorf("users after a rejected body = %+v, want alice unchanged", got)
+			}
+		})
+	}
+}
+
+// TestAddUserMalformedJSON はPOST /usersに壊れたJSONや型の異なる値を送ると、
+// デコーダの内部のメッセージではなく位置やフィールドを示す400を共通の形式で返すことを確かめる。
+func TestAddUserMalformedJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"syntax error", `{"name":"alice",}`, "malformed JSON at byte offset 17"},
+		{"unquoted key", `{name:"alice"}`, "malformed JSON at byte offset 2"},
+		{"id is a string", `{"id":"1","name":"alice"}`, "id must be a number"},
+		{"name is a number", `{"name":1}`, "name must be a string"},
+		{"deleted is a string", `{"name":"alice","deleted":"yes"}`, "deleted must be a boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			res, data := ts.do(http.MethodPost, "/users", tt.body)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Fatalf("POST /users %s: status = %d, want 400: %s", tt.body, res.StatusCode, data)
+			}
+			got := decodeTestJSON[ErrorResponse](t, data).Error
+			if got.Code != "invalid_json" || got.Message != tt.want {
+				t.Errorf("POST /users %s: error = %+v, want invalid_json %q", tt.body, got, tt.want)
+			}
+			if strings.Contains(got.Message, "json:") || strings.Contains(got.Message, "Go ") {
+				t.Errorf("POST /users %s: message %q leaks decoder details", tt.body, got.Message)
+			}
+		})
+	}
+}
+
+// TestJSONEndpointsRequireContentType はJSONのボディを受け取る各エンドポイントが、
+// Content-Typeがapplication/jsonでない場合に415を返して何も変更しないことを確かめる。
+func TestJSONEndpointsRequireContentType(t *testing.T) {
+	endpoints := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, "/users", `{"name":"bob"}`},
+		{http.MethodPost, "/users/bulk", `[{"name":"bob"}]`},
+		{http.MethodPut, "/users/1", `{"name":"bob","version":1}`},
+		{http.MethodPatch, "/users/1", `{"name":"bob"}`},
+	}
+	contentTypes := []struct {
+		name        string
+		contentType []string // nilの場合はContent-Typeを送らない
+		wantStatus  int
+	}{
+		{"missing", nil, http.StatusUnsupportedMediaType},
+		{"form", []string{"application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"xml", []string{"application/xml"}, http.StatusUnsupportedMediaType},
+		{"malformed", []string{"application/json;;"}, http.StatusUnsupportedMediaType},
+		{"json", []string{"application/json"}, 0},
+		{"json with charset", []string{"application/json; charset=utf-8"}, 0},
+		{"json in upper case", []string{"Application/JSON"}, 0},
+	}
+	for _, ep := range endpoints {
+		for _, ct := range contentTypes {
+			t.Run(ep.method+" "+ep.path+" "+ct.name, func(t *testing.T) {
+				ts := newTestServer(t)
+				ts.CreateUser("alice")
+
+				res, data := ts.doWithHeader(ep.method, ep.path, ep.body, http.Header{"Content-Type": ct.contentType})
+				if ct.wantStatus == 0 {
+					if res.StatusCode >= 300 {
+						t.Errorf("%s %s with Content-Type %q: status = %d, want success: %s", ep.method, ep.path, ct.contentType, res.StatusCode, data)
+					}
+					return
+				}
+				if res.StatusCode != ct.wantStatus {
+					t.Fatalf("%s %s with Content-Type %q: status = %d, want %d: %s", ep.method, ep.path, ct.contentType, res.StatusCode, ct.wantStatus, data)
+				}
+				if got := decodeTestJSON[ErrorResponse](t, data).Error.Code; got != "unsupported_media_type" {
+					t.Errorf("%s %s with Content-Type %q: code = %q, want unsupported_media_type", ep.method, ep.path, ct.contentType, got)
+				}
+				if got := userNames(ts.app.store.All()); !slices.Equal(got, []string{"alice"}) {
+					t.Errorf("users after a rejected %s %s = %v, want only alice", ep.method, ep.path, got)
+				}
+			})
+		}
+	}
+}
+
+// TestJSONEndpointsRejectEmptyBody はJSONのボディを受け取る各エンドポイントが、
+// 空のボディをJSONの誤りと区別して400のempty_bodyで断り、何も変更しないことを確かめる。
+func TestJSONEndpointsRejectEmptyBody(t *testing.T) {
+	endpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/users"},
+		{http.MethodPost, "/users/bulk"},
+		{http.MethodPut, "/users/1"},
+		{http.MethodPatch, "/users/1"},
+	}
+	bodies := []struct {
+		name     string
+		body     string
+		wantCode string
+	}{
+		{"empty", "", "empty_body"},
+		{"whitespace only", " \r\n\t ", "empty_body"},
+		{"malformed", "{", "invalid_json"},
+	}
+	for _, ep := range endpoints {
+		for _, b := range bodies {
+			t.Run(ep.method+" "+ep.path+" "+b.name, func(t *testing.T) {
+				ts := newTestServer(t)
+				ts.CreateUser("alice")
+
+				res, data := ts.doWithHeader(ep.method, ep.path, b.body, http.Header{"Content-Type": {"application/json"}})
+				if res.StatusCode != http.StatusBadRequest {
+					t.Fatalf("%s %s with body %q: status = %d, want 400: %s", ep.method, ep.path, b.body, res.StatusCode, data)
+				}
+				got := decodeTestJSON[ErrorResponse](t, data).Error
+				if got.Code != b.wantCode {
+					t.Errorf("%s %s with body %q: code = %q, want %s", ep.method, ep.path, b.body, got.Code, b.wantCode)
+				}
+				if b.wantCode == "empty_body" && got.Message != "request body is empty" {
+					t.Errorf("%s %s with body %q: message = %q, want request body is empty", ep.method, ep.path, b.body, got.Message)
+				}
+				if got := userNames(ts.app.store.All()); !slices.Equal(got, []string{"alice"}) {
+					t.Errorf("users after %s %s with body %q = %v, want only alice", ep.method, ep.path, b.body, got)
+				}
+			})
+		}
+	}
+}
+
+func TestPreferReturn(t *testing.T) {
+	tests := []struct {
+		prefer []string // Preferヘッダの値（複数の場合は複数行で送る）
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"return=minimal"}, "minimal"},
+		{[]string{"return=representation"}, "representation"},
+		{[]string{`Return = "Minimal"`}, "minimal"},
+		{[]string{"return=minimal; foo, respond-async"}, "minimal"},
+		{[]string{"respond-async, return=representation"}, "representation"},
+		{[]string{"respond-async", "return=minimal"}, "minimal"},
+		{[]string{"respond-async"}, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/users", nil)
+		r.Header["Prefer"] = tt.prefer
+		if got := preferReturn(r); got != tt.want {
+			t.Errorf("preferReturn(Prefer: %q) = %q, want %q", tt.prefer, got, tt.want)
+		}
+	}
+}
+
+func TestAddUserPreferReturn(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefer      string // 空の場合はヘッダを送らない
+		wantBody    bool
+		wantApplied string // Preference-Appliedヘッダ（空の場合はヘッダがないこと）
+	}{
+		{"default", "", true, ""},
+		{"return=representation", "return=representation", true, "return=representation"},
+		{"return=minimal", "return=minimal", false, "return=minimal"},
+		// 知らない値は既定の扱いにする
+		{"unknown value", "return=headers-only", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			header := http.Header{}
+			if tt.prefer != "" {
+				header.Set("Prefer", tt.prefer)
+			}
+			res, data := ts.doWithHeader(http.MethodPost, "/users", `{"name":"alice"}`, header)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("POST /users with Prefer %q: status = %d, want 201: %s", tt.prefer, res.StatusCode, data)
+			}
+			if got := res.Header.Get("Preference-Applied"); got != tt.wantApplied {
+				t.Errorf("POST /users with Prefer %q: Preference-Applied = %q, want %q", tt.prefer, got, tt.wantApplied)
+			}
+			location := res.Header.Get("Location")
+			if location != "/users/1" {
+				t.Errorf("POST /users with Prefer %q: Location = %q, want /users/1", tt.prefer, location)
+			}
+			if !tt.wantBody {
+				if len(data) != 0 {
+					t.Errorf("POST /users with Prefer %q: body = %q, want empty", tt.prefer, data)
+				}
+			} else if u := decodeTestJSON[User](t, data); u.ID != 1 || u.Name != "alice" {
+				t.Errorf("POST /users with Prefer %q returned %+v, want alice", tt.prefer, u)
+			}
+
+			// どちらの場合もユーザーは追加されている
+			if u, ok := ts.GetUser(1); !ok || u.Name != "alice" {
+				t.Errorf("GET %s after POST with Prefer %q = %+v, %v, want alice", location, tt.prefer, u, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// writeJSON はContent-Typeヘッダを設定したうえで、ステータスコードとvをJSONとして書き込む。
+// ヘッダはWriteHeaderの後に設定しても反映されないため、必ずこの順序で書き込む。
+// withPrettyJSONで整形を指定されたリクエストでは2文字の空白でインデントする（isPretty）。
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	var data []byte
+	var err error
+	if isPretty(w) {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Printf("failed to encode response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// prettyWriter は整形したJSONを返すよう指定されたリクエストのResponseWriter。
+// writeJSONはこの型を含むかどうかでインデントの有無を判断する。
+type prettyWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap はhttp.ResponseControllerがFlushなどを元のResponseWriterに委ねられるよう、元のResponseWriterを返す
+func (pw *prettyWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// isPretty はwか、wがUnwrapで包んでいるResponseWriterのいずれかがprettyWriterかを返す。
+// ハンドラの手前でResponseWriterを包むミドルウェアがあっても整形の指定を引き継ぐ。
+func isPretty(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(*prettyWriter); ok {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// withPrettyJSON は?pretty=trueが指定されたリクエスト、またはalwaysがtrueの場合に
+// レスポンスのJSONを整形して返すようにする。
+// 本番では転送量を抑えるため、既定では整形しない。
+func withPrettyJSON(next http.Handler, always bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if always || r.URL.Query().Get("pretty") == "true" {
+			w = &prettyWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ErrorResponse はエラーレスポンスを表す構造体。
+// 成功時と同じくJSONで返すことで、クライアントが一貫した方法で扱えるようにする。
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody はエラーの内容を表す構造体。
+type ErrorBody struct {
+	Code    string `json:"code"`    // not_found のような機械的に判別するためのコード
+	Message string `json:"message"` // 人が読むためのメッセージ
+}
+
+// writeError はエラーレスポンスを共通の形式のJSONとして書き込む。
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Error: ErrorBody{Code: code, Message: message}})
+}
+
+// withMuxErrors はmuxに一致するパターンがない場合に返す404と405を、writeErrorの形式で返す。
+// 一致するパターンがある場合は、ハンドラが書き込んだ応答をそのまま返す。
+// 全てのパスに一致する"/"のパターンを登録すると405を返せなくなるため、muxの応答を書き換える。
+func withMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// ServeMuxが書き込むテキストのエラーは捨て、ステータスコードとAllowヘッダだけを使う
+		rec := &muxErrorRecorder{header: http.Header{}, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+		switch rec.status {
+		case http.StatusNotFound:
+			writeError(w, http.StatusNotFound, "not_found", "no route for "+r.URL.Path)
+		case http.StatusMethodNotAllowed:
+			w.Header().Set("Allow", rec.header.Get("Allow"))
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", r.Method+" is not allowed for "+r.URL.Path)
+		default:
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+		}
+	})
+}
+
+// muxErrorRecorder はServeMuxが一致するパターンのないリクエストに書き込む応答を、クライアントに送らずに記録するResponseWriter
+type muxErrorRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// Header は記録用のヘッダを返す
+func (rec *muxErrorRecorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader は最初に書き込まれたステータスコードを記録する
+func (rec *muxErrorRecorder) WriteHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = code
+}
+
+// Write はボディを記録する
+func (rec *muxErrorRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
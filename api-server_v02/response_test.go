@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestJSONContentType は各エンドポイントが、エラーを含むJSONのレスポンスにContent-Typeを付けることを確かめる。
+func TestJSONContentType(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{http.MethodGet, "/users", "", http.StatusOK},
+		{http.MethodPost, "/users", `{"name":"bob"}`, http.StatusCreated},
+		{http.MethodPost, "/users", `{"name":""}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/users/bulk", `[{"name":"bob"}]`, http.StatusCreated},
+		{http.MethodGet, "/users/count", "", http.StatusOK},
+		{http.MethodGet, "/users/recent", "", http.StatusOK},
+		{http.MethodGet, "/users/search?name=alice", "", http.StatusOK},
+		{http.MethodGet, "/users/batch?ids=1,2", "", http.StatusOK},
+		{http.MethodGet, "/users/1", "", http.StatusOK},
+		{http.MethodGet, "/users/9", "", http.StatusNotFound},
+		{http.MethodGet, "/users/abc", "", http.StatusBadRequest},
+		{http.MethodPut, "/users/1", `{"name":"alice2","version":1}`, http.StatusOK},
+		{http.MethodPatch, "/users/1", `{"name":"alice2"}`, http.StatusOK},
+		{http.MethodDelete, "/users/1", "", http.StatusOK},
+		{http.MethodPost, "/users/1", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/healthz", "", http.StatusOK},
+		{http.MethodGet, "/version", "", http.StatusOK},
+		{http.MethodGet, "/openapi.json", "", http.StatusOK},
+		{http.MethodGet, "/admin/export", "", http.StatusForbidden},
+		{http.MethodGet, "/no-such-path", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			if got := res.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("%s %s: Content-Type = %q, want application/json", tt.method, tt.path, got)
+			}
+		})
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{"missing user", http.MethodGet, "/users/9", http.StatusNotFound, `{"error":{"code":"not_found","message":"User not found"}}`, ""},
+		{"invalid id", http.MethodGet, "/users/abc", http.StatusBadRequest, `{"error":{"code":"invalid_id","message":"id must be an integer or a UUID"}}`, ""},
+		{"unknown route", http.MethodGet, "/nope", http.StatusNotFound, `{"error":{"code":"not_found","message":"no route for /nope"}}`, ""},
+		{"wrong method", http.MethodPost, "/users/1", http.StatusMethodNotAllowed, "", "DELETE, GET, HEAD, PATCH, PUT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			res, data := ts.do(tt.method, tt.path, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s: status = %d, want %d: %s", tt.method, tt.path, res.StatusCode, tt.wantStatus, data)
+			}
+			// エラーの形式以外のフィールドを含まない
+			var envelope map[string]map[string]string
+			if err := json.Unmarshal(data, &envelope); err != nil || len(envelope) != 1 || len(envelope["error"]) != 2 || envelope["error"]["code"] == "" {
+				t.Errorf("%s %s = %s (%v), want an error envelope with a code and a message", tt.method, tt.path, data, err)
+			}
+			if got := strings.TrimSpace(string(data)); tt.wantBody != "" && got != tt.wantBody {
+				t.Errorf("%s %s = %s, want %s", tt.method, tt.path, got, tt.wantBody)
+			}
+			if got := res.Header.Get("Allow"); got != tt.wantAllow {
+				t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestWithPrettyJSON(t *testing.T) {
+	compact := "{\"status\":\"ok\"}\n"
+	pretty := "{\n  \"status\": \"ok\"\n}\n"
+	tests := []struct {
+		name   string
+		query  string
+		always bool // -prettyを指定した場合
+		want   string
+	}{
+		{"default", "", false, compact},
+		{"pretty=true", "?pretty=true", false, pretty},
+		{"pretty=false", "?pretty=false", false, compact},
+		{"pretty=1", "?pretty=1", false, compact},
+		{"-pretty", "", true, pretty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := NewApp(NewInMemoryStore(""))
+			// ResponseWriterを包む他のミドルウェアの内側でも整形の指定を引き継ぐ
+			srv := httptest.NewServer(withPrettyJSON(withGzip(app.newMux()), tt.always))
+			t.Cleanup(srv.Close)
+			ts := &testServer{Server: srv, t: t, app: app}
+
+			res, data := ts.do(http.MethodGet, "/healthz"+tt.query, "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /healthz%s: status = %d: %s", tt.query, res.StatusCode, data)
+			}
+			if got := string(data); got != tt.want {
+				t.Errorf("GET /healthz%s = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// 永続化の再試行のデフォルト値
+const (
+	defaultSaveAttempts   = 3                      // 諦めるまでに試す回数
+	defaultSaveRetryDelay = 100 * time.Millisecond // 最初の再試行までの待ち時間
+)
+
+// sleep は再試行の間に待機する。テストから待ち時間をなくせるよう変数にしている。
+var sleep = time.Sleep
+
+// retry はfnが成功するまで最大attempts回呼び出す。
+// 一時的な障害が収まるのを待てるよう、待ち時間をbaseDelayから失敗のたびに倍にしていく。
+// 全て失敗した場合は最後のエラーを返す。
+func retry(attempts int, baseDelay time.Duration, fn func() error) error {
+	var err error
+	delay := baseDelay
+	for i := 1; ; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i >= attempts {
+			return err
+		}
+		log.Printf("attempt %d/%d failed, retrying in %s: %v", i, attempts, delay, err)
+		sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordSleeps はsleepを待たずに待ち時間を記録する関数に差し替え、記録先を返す。
+// afterは記録するたびに、それまでの回数を渡して呼び出す（nilの場合は呼ばない）。
+func recordSleeps(t *testing.T, after func(n int)) *[]time.Duration {
+	t.Helper()
+	var delays []time.Duration
+	orig := sleep
+	sleep = func(d time.Duration) {
+		delays = append(delays, d)
+		if after != nil {
+			after(len(delays))
+		}
+	}
+	t.Cleanup(func() { sleep = orig })
+	return &delays
+}
+
+func TestRetry(t *testing.T) {
+	const base = 10 * time.Millisecond
+	tests := []struct {
+		name       string
+		attempts   int
+		failures   int // 成功するまでに失敗する回数
+		wantErr    bool
+		wantCalls  int
+		wantDelays []time.Duration
+	}{
+		{"first try", 3, 0, false, 1, nil},
+		{"fails twice then succeeds", 3, 2, false, 3, []time.Duration{base, 2 * base}},
+		{"gives up", 3, 5, true, 3, []time.Duration{base, 2 * base}},
+		{"more attempts", 5, 4, false, 5, []time.Duration{base, 2 * base, 4 * base, 8 * base}},
+		{"single attempt", 1, 1, true, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays := recordSleeps(t, nil)
+			errFail := errors.New("disk busy")
+			calls := 0
+			err := retry(tt.attempts, base, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errFail
+				}
+				return nil
+			})
+			if tt.wantErr != (err != nil) || (err != nil && !errors.Is(err, errFail)) {
+				t.Errorf("retry() = %v, want error %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("retry() called fn %d times, want %d", calls, tt.wantCalls)
+			}
+			if !slices.Equal(*delays, tt.wantDelays) {
+				t.Errorf("retry() waited %v, want %v", *delays, tt.wantDelays)
+			}
+		})
+	}
+}
+
+// TestInMemoryStoreRetriesSave はファイルへの書き出しが2回失敗しても、3回目に書き出せれば更新が残ることを確かめる。
+func TestInMemoryStoreRetriesSave(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int // 書き出しに失敗する回数
+		wantSaved bool
+	}{
+		{"fails twice", 2, true},
+		{"fails every attempt", defaultSaveAttempts, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), usersFile)
+			s := NewInMemoryStore(file)
+
+			// 一時ファイルの場所にディレクトリを置いて書き出しを失敗させ、所定の回数の後に取り除く
+			tmp := file + ".tmp"
+			if err := os.Mkdir(tmp, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			recordSleeps(t, func(n int) {
+				if n == tt.failures {
+					os.Remove(tmp)
+				}
+			})
+
+			addTestUsers(t, s, "alice")
+			names := userNames(NewInMemoryStore(file).All())
+			if saved := slices.Equal(names, []string{"alice"}); saved != tt.wantSaved {
+				t.Errorf("users in %s = %v, want saved %v", file, names, tt.wantSaved)
+			}
+			// 書き出せなくてもメモリ上の更新は有効
+			if got := userNames(s.All()); !slices.Equal(got, []string{"alice"}) {
+				t.Errorf("users in memory = %v, want [alice]", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// searchUsers は複数の条件に一致するユーザーをIDの順に返すエンドポイントのハンドラ
+// name（名前の部分一致）、id_min、id_max（IDの範囲、両端を含む）はいずれも任意で、
+// 指定された条件を全て満たすユーザーだけを返す
+func (a *App) searchUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	// 整数のIDの範囲で絞り込めると件数を推測できるため、IDの割り当て方がuuidの場合は受け付けない
+	if a.idStrategy == idStrategyUUID && (q.Has("id_min") || q.Has("id_max")) {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "id_min and id_max cannot be used when ID_STRATEGY is uuid")
+		return
+	}
+	idMin, ok := queryBound(w, q.Get("id_min"), "id_min", 0)
+	if !ok {
+		return
+	}
+	idMax, ok := queryBound(w, q.Get("id_max"), "id_max", math.MaxInt)
+	if !ok {
+		return
+	}
+	if idMin > idMax {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "id_min must not be greater than id_max")
+		return
+	}
+
+	users := a.store.All()
+	if name := strings.TrimSpace(q.Get("name")); name != "" {
+		users = filterByName(users, name)
+	}
+	matched := []User{}
+	for _, u := range users {
+		if idMin <= u.ID && u.ID <= idMax {
+			matched = append(matched, u)
+		}
+	}
+	slices.SortFunc(matched, compareByID)
+	writeJSON(w, http.StatusOK, a.publicUsers(matched))
+}
+
+// queryBound はIDの範囲を表すクエリパラメータの値を整数として返す。
+// 指定がない場合はdefを返し、整数として解釈できない場合は400を書き込んでfalseを返す。
+func queryBound(w http.ResponseWriter, v, key string, def int) (int, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", key+" must be an integer")
+		return 0, false
+	}
+	return n, true
+}
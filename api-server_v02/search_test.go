@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestSearchUsers(t *testing.T) {
+	ts := newTestServer(t)
+	for _, name := range []string{"alice", "bob", "Alicia", "carol", "malice", "dave"} {
+		ts.CreateUser(name)
+	}
+	ts.do(http.MethodDelete, "/users/6", "")
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantIDs     []int
+		wantMessage string
+	}{
+		{"no filters", "", http.StatusOK, []int{1, 2, 3, 4, 5}, ""},
+		{"name", "?name=ali", http.StatusOK, []int{1, 3, 5}, ""},
+		{"name and id range", "?name=ali&id_min=2&id_max=4", http.StatusOK, []int{3}, ""},
+		{"id range only", "?id_min=2&id_max=4", http.StatusOK, []int{2, 3, 4}, ""},
+		{"id_min only", "?id_min=4", http.StatusOK, []int{4, 5}, ""},
+		{"id_max only", "?id_max=2", http.StatusOK, []int{1, 2}, ""},
+		{"single id", "?id_min=3&id_max=3", http.StatusOK, []int{3}, ""},
+		// 削除済みのユーザーは範囲に含まれていても返さない
+		{"deleted user in range", "?id_min=6", http.StatusOK, []int{}, ""},
+		{"no matches", "?name=zed", http.StatusOK, []int{}, ""},
+		{"min greater than max", "?id_min=4&id_max=2", http.StatusBadRequest, nil, "id_min must not be greater than id_max"},
+		{"invalid id_min", "?id_min=abc", http.StatusBadRequest, nil, "id_min must be an integer"},
+		{"invalid id_max", "?id_max=1.5", http.StatusBadRequest, nil, "id_max must be an integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, data := ts.do(http.MethodGet, "/users/search"+tt.query, "")
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /users/search%s: status = %d, want %d: %s", tt.query, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := decodeTestJSON[ErrorResponse](t, data).Error; got.Code != "invalid_parameter" || got.Message != tt.wantMessage {
+					t.Errorf("GET /users/search%s: error = %+v, want invalid_parameter %q", tt.query, got, tt.wantMessage)
+				}
+				return
+			}
+			// 一致するユーザーがいない場合もnullではなく空の配列を返す
+			users := decodeTestJSON[[]User](t, data)
+			if users == nil {
+				t.Errorf("GET /users/search%s = %s, want an array", tt.query, data)
+			}
+			if got := userIDs(users); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("GET /users/search%s: ids = %v, want %v", tt.query, got, tt.wantIDs)
+			}
+		})
+	}
+}
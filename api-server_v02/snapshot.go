@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// startSnapshots は更新のたびにファイルへ書き出すのをやめ、tickを受け取るたびに
+// 前回から変更があった場合だけ書き出すよう切り替える。
+// 書き込みの多い状況でのスループットを優先する代わりに、直近の更新を失う可能性がある。
+// 返す関数を呼び出すとループを止め、最後の状態を書き出してから戻る。
+func (s *InMemoryStore) startSnapshots(tick <-chan time.Time) (stop func()) {
+	s.mu.Lock()
+	s.snapshotting = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-tick:
+				s.snapshot()
+			case <-done:
+				s.Flush()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// snapshot は前回書き出してから変更があればユーザー情報をファイルへ書き出す。
+func (s *InMemoryStore) snapshot() {
+	s.writeFile()
+}
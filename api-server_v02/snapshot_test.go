@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestStartSnapshots はtickを受け取るたびと停止時にだけユーザー情報をファイルへ書き出し、
+// 更新のたびには書き出さないことを確かめる。
+func TestStartSnapshots(t *testing.T) {
+	tests := []struct {
+		name      string
+		before    []string // tickの前に追加するユーザー
+		ticks     int
+		after     []string // tickの後に追加するユーザー
+		stop      bool
+		wantNames []string // ファイルに書き出されたユーザー（nilの場合はファイルがないこと）
+	}{
+		{"no tick", []string{"alice"}, 0, nil, false, nil},
+		{"tick", []string{"alice"}, 1, nil, false, []string{"alice"}},
+		{"tick without changes", nil, 1, nil, false, nil},
+		{"several ticks", []string{"alice", "bob"}, 3, nil, false, []string{"alice", "bob"}},
+		{"stop", []string{"alice"}, 0, nil, true, []string{"alice"}},
+		// 最後のtickより後の更新も停止時に書き出す
+		{"stop after a tick", []string{"alice"}, 1, []string{"bob"}, true, []string{"alice", "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), usersFile)
+			s := NewInMemoryStore(file)
+			tick := make(chan time.Time)
+			stop := s.startSnapshots(tick)
+			stopped := false
+			t.Cleanup(func() {
+				if !stopped {
+					stop()
+				}
+			})
+
+			addTestUsers(t, s, tt.before...)
+			for range tt.ticks {
+				tick <- time.Now()
+			}
+			if tt.ticks > 0 {
+				// tickは1つずつ受け取るため、もう1つ送れば直前の書き出しが終わるまで待てる
+				tick <- time.Now()
+			}
+			addTestUsers(t, s, tt.after...)
+			if tt.stop {
+				stop()
+				stopped = true
+			}
+
+			if tt.wantNames == nil {
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("%s exists (%v), want no snapshot yet", file, err)
+				}
+				return
+			}
+			saved := NewInMemoryStore(file)
+			if got := userNames(saved.All()); !slices.Equal(got, tt.wantNames) {
+				t.Errorf("snapshot has users %v, want %v", got, tt.wantNames)
+			}
+		})
+	}
+}
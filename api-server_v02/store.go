@@ -0,0 +1,511 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// now は現在時刻を返す。テストから時刻を固定できるよう変数にしている。
+var now = time.Now
+
+// ストアの操作で返すエラー
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrDuplicateName = errors.New("a user with the same name already exists")
+	ErrStoreFull     = errors.New("the maximum number of users has been reached")
+)
+
+// UserStore はユーザー情報の保存先を抽象化したインターフェース。
+// ハンドラはこのインターフェースを通してのみユーザー情報にアクセスする。
+type UserStore interface {
+	// Add は新しいIDとバージョン1を割り当ててユーザーを追加し、追加されたユーザーを返す。
+	// 同じ名前のユーザーが既に存在する場合はErrDuplicateName、ユーザー数が上限に達している場合はErrStoreFullを返す。
+	Add(u User) (User, error)
+	// AddMany は複数のユーザーにIDを割り当ててまとめて追加し、追加されたユーザーを返す。
+	// 名前が重複するユーザーが含まれる場合はErrDuplicateName、上限を超える場合はErrStoreFullを返し、どのユーザーも追加しない。
+	AddMany(us []User) ([]User, error)
+	// Get は指定されたIDのユーザーを返す。存在しない場合や削除済みの場合はfalseを返す。
+	Get(id int) (User, bool)
+	// All は削除済みのものを除く全てのユーザーを追加順に返す。
+	// ユーザーがいない場合もnilではなく空のスライスを返す。
+	All() []User
+	// Deleted は削除済みのユーザーを追加順に返す。
+	Deleted() []User
+	// IDForUUID はUUIDが一致するユーザーのIDを返す。削除済みのユーザーも対象とし、存在しない場合はfalseを返す。
+	IDForUUID(uuid string) (int, bool)
+	// Count は削除済みのものを除くユーザーの数を返す。
+	Count() int
+	// Upsert はIDが一致するユーザーを置き換え、存在しない場合はそのIDで新しく追加する。
+	// 新しく追加したユーザーのバージョンは1、置き換えたユーザーのバージョンは1つ進める。
+	// checkがnilでない場合は置き換える前に現在のユーザーを渡して呼び出し、エラーを返した場合はそのエラーを返してユーザーを変更しない。
+	// 新しく追加した場合はtrueを返す。他のユーザーと名前が重複する場合はErrDuplicateNameを返す。
+	// 新しく追加するときにユーザー数が上限に達している場合はErrStoreFullを返す。
+	Upsert(u User, check func(cur User) error) (User, bool, error)
+	// Modify はIDが一致するユーザーをfnで書き換え、バージョンを1つ進めて更新日時を記録する。読み取りから書き込みまでを不可分に行う。
+	// fnがエラーを返した場合はそのエラーを返し、ユーザーは変更しない。
+	Modify(id int, fn func(u *User) error) (User, error)
+	// Delete は指定されたIDのユーザーを削除済みにし、削除したユーザーを返す。存在しない場合はfalseを返す。
+	// 記録を残すため、ユーザーは取り除かずに削除日時を設定する。削除済みのユーザーの名前は再び使える。
+	Delete(id int) (User, bool)
+	// Reset は削除済みのものを含む全てのユーザーを取り除き、IDの割り当てを1からやり直す。
+	Reset() error
+	// Export は削除済みのものを含む全てのユーザーと、次に割り当てるIDを返す。
+	Export() (Backup, error)
+	// Import は削除済みのものを含む全てのユーザーをbのユーザーに置き換える。
+	// 次に割り当てるIDはb.NextIDとし、bのユーザーのIDより小さい場合は最大のIDの次とする。
+	// IDの重複は呼び出し側で確認しておくこと。削除済みでないユーザーの名前が重複する場合はErrDuplicateName、
+	// 上限を超える場合はErrStoreFullを返し、現在の状態は変更しない。
+	Import(b Backup) error
+	// Reindex は削除済みでないユーザーにIDの順で1から詰めてIDを振り直し、続けて削除済みのユーザーにも振り直す。
+	// 次に割り当てるIDは全てのユーザーの数の次とする。元のIDから新しいIDへの対応を返す。
+	Reindex() (map[int]int, error)
+	// Merge はmergeIDsのユーザーを削除済みにしてkeepIDのユーザーに1つにまとめ、残したユーザーと削除したユーザーを返す。
+	// いずれかのユーザーが存在しない場合はErrUserNotFoundを返し、どのユーザーも変更しない。
+	Merge(keepID int, mergeIDs []int) (User, []User, error)
+}
+
+// Backup は保存先の全ての状態を表す構造体。
+// GET /admin/exportで書き出した内容をPOST /admin/importでそのまま読み込める。
+type Backup struct {
+	Users  []User `json:"users"`             // 削除済みのものを含む全てのユーザー
+	NextID int    `json:"next_id,omitempty"` // 次に追加されるユーザーに割り当てるID（ID_STRATEGY=uuidの場合は含めない）
+}
+
+// backupNextID はbのユーザーのIDと重ならない、次に割り当てるIDを返す。
+func backupNextID(b Backup) int {
+	next := max(b.NextID, 1)
+	for _, u := range b.Users {
+		next = max(next, u.ID+1)
+	}
+	return next
+}
+
+// InMemoryStore はユーザー情報をメモリ上に保持するUserStoreの実装。
+// fileを指定した場合は更新のたびにファイルへ書き出す。
+type InMemoryStore struct {
+	mu     sync.RWMutex // usersの排他制御のためのmutex（読み取りは並行に行える）
+	users  []User       // 保存しているユーザー情報のスライス
+	nextID atomic.Int64 // 次に追加されるユーザーに割り当てるID（muを取らずに採番する）
+	file   string       // 永続化先のファイルのパス（空の場合は永続化しない）
+
+	maxUsers int // 削除済みのものを除いて保存できるユーザー数の上限（0の場合は無制限）
+
+	snapshotting bool // 更新のたびではなく定期的にファイルへ書き出すか
+	gen          int  // usersを変更するたびに1ずつ増える世代（muで保護）
+
+	saveMu   sync.Mutex // ファイルへの書き出しを1つずつ行うためのmutex
+	savedGen int        // 最後にファイルへ書き出した世代（saveMuで保護）
+
+	saveAttempts   int           // ファイルへの書き出しを試す最大回数
+	saveRetryDelay time.Duration // 書き出しを再試行するまでの最初の待ち時間
+}
+
+// NewInMemoryStore は空のInMemoryStoreを生成する。
+// fileが空でない場合は、そのファイルから前回保存したユーザー情報を読み込む。
+func NewInMemoryStore(file string) *InMemoryStore {
+	s := &InMemoryStore{
+		users: []User{},
+		file:  file,
+
+		saveAttempts:   defaultSaveAttempts,
+		saveRetryDelay: defaultSaveRetryDelay,
+	}
+	s.nextID.Store(1)
+	if file != "" {
+		s.loadUsers()
+	}
+	return s
+}
+
+// Add は新しいIDを割り当ててユーザーを追加する。
+// 同時に同じ名前で追加されても重複しないよう、名前の確認もロック内で行う。
+// IDはロックを取る前に採番するため、追加に失敗した場合はそのIDを使わずに飛ばす。
+func (s *InMemoryStore) Add(u User) (User, error) {
+	id := s.allocateIDs(1) // 新しいIDを割り当て
+	defer s.saveChanges()  // ロックを解放した後にファイルへ書き出す
+	s.mu.Lock()            // 排他制御の開始
+	defer s.mu.Unlock()    // 排他制御の終了
+	if s.nameTaken(u.Name, 0) {
+		return User{}, ErrDuplicateName
+	}
+	if s.full(1) {
+		return User{}, ErrStoreFull
+	}
+	u.ID = s.untakenID(id)              // Upsertに先に使われていれば採番し直す
+	u.CreatedAt = now().UTC()           // 作成日時の記録
+	u.UpdatedAt = u.CreatedAt           // 更新日時は作成日時から始める
+	u.Version = 1                       // 最初のバージョン
+	u.Deleted, u.DeletedAt = false, nil // 削除されていない状態
+	s.users = append(s.users, u)        // ユーザーの追加
+	s.persist()                         // ファイルへの書き出し
+	return u, nil
+}
+
+// AddMany は複数のユーザーを1回のロックでまとめて追加する。
+// 途中で失敗した場合に一部だけが追加された状態にならないよう、先に全件の名前を確認する。
+func (s *InMemoryStore) AddMany(us []User) ([]User, error) {
+	first := s.allocateIDs(len(us))
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 既存のユーザーとの重複に加え、追加するユーザー同士の重複も確認する
+	seen := make(map[string]bool, len(us))
+	for _, u := range us {
+		key := strings.ToLower(u.Name)
+		if seen[key] || s.nameTaken(u.Name, 0) {
+			return nil, ErrDuplicateName
+		}
+		seen[key] = true
+	}
+	if s.full(len(us)) {
+		return nil, ErrStoreFull
+	}
+
+	added := make([]User, 0, len(us))
+	for i, u := range us {
+		u.ID = s.untakenID(first + i)
+		u.CreatedAt = now().UTC()
+		u.UpdatedAt = u.CreatedAt
+		u.Version = 1
+		u.Deleted, u.DeletedAt = false, nil
+		added = append(added, u)
+	}
+	s.users = append(s.users, added...)
+	s.persist()
+	return added, nil
+}
+
+// Get は指定されたIDのユーザーを返す。
+func (s *InMemoryStore) Get(id int) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if i := s.indexOf(id); i >= 0 {
+		return s.users[i], true
+	}
+	return User{}, false
+}
+
+// All は削除済みのものを除く全てのユーザーのコピーを返す。
+// 呼び出し側がロックの外で扱えるよう、内部のスライスは共有しない。
+func (s *InMemoryStore) All() []User {
+	return s.collect(false)
+}
+
+// Deleted は削除済みのユーザーのコピーを返す。
+func (s *InMemoryStore) Deleted() []User {
+	return s.collect(true)
+}
+
+// collect は削除済みかどうかがdeletedに一致するユーザーのコピーを返す。
+func (s *InMemoryStore) collect(deleted bool) []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := []User{}
+	for _, u := range s.users {
+		if u.Deleted == deleted {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// IDForUUID はUUIDが一致するユーザーのIDを返す。
+func (s *InMemoryStore) IDForUUID(uuid string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.UUID != "" && u.UUID == uuid {
+			return u.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Count は削除済みのものを除くユーザーの数を返す。スライスのコピーは作らない。
+func (s *InMemoryStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, u := range s.users {
+		if !u.Deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// Upsert はIDが一致するユーザーを置き換え、存在しない場合はそのIDで追加する。
+// 追加したIDを後のAddで再び割り当てないよう、必要に応じてnextIDを進める。
+// 削除済みのユーザーと同じIDの場合は、そのユーザーを新しい内容で作り直す。
+func (s *InMemoryStore) Upsert(u User, check func(cur User) error) (User, bool, error) {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(u.ID)
+	if i >= 0 && check != nil {
+		if err := check(s.users[i]); err != nil {
+			return User{}, false, err
+		}
+	}
+	if s.nameTaken(u.Name, u.ID) {
+		return User{}, false, ErrDuplicateName
+	}
+	u.Deleted, u.DeletedAt = false, nil
+	if i >= 0 {
+		u.UUID = s.users[i].UUID
+		u.CreatedAt = s.users[i].CreatedAt
+		u.UpdatedAt = now().UTC()
+		u.Version = s.users[i].Version + 1
+		s.users[i] = u
+		s.persist()
+		return u, false, nil
+	}
+	if s.full(1) {
+		return User{}, false, ErrStoreFull
+	}
+	u.CreatedAt = now().UTC()
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
+	if i := slices.IndexFunc(s.users, func(d User) bool { return d.ID == u.ID }); i >= 0 {
+		s.users[i] = u
+		s.persist()
+		return u, true, nil
+	}
+	s.users = append(s.users, u)
+	s.reserveID(u.ID)
+	s.persist()
+	return u, true, nil
+}
+
+// Modify はIDが一致するユーザーのコピーをfnで書き換えてから保存する。
+// 読み取りと書き込みの間に他の更新が割り込まないよう、まとめて排他制御する。
+func (s *InMemoryStore) Modify(id int, fn func(u *User) error) (User, error) {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return User{}, ErrUserNotFound
+	}
+	u := s.users[i]
+	if err := fn(&u); err != nil {
+		return User{}, err
+	}
+	// ID、UUID、作成日時、バージョン、削除の状態は書き換えの対象にしない
+	u.ID = id
+	u.UUID = s.users[i].UUID
+	u.CreatedAt = s.users[i].CreatedAt
+	u.UpdatedAt = now().UTC()
+	u.Version = s.users[i].Version + 1
+	u.Deleted, u.DeletedAt = false, nil
+	if s.nameTaken(u.Name, id) {
+		return User{}, ErrDuplicateName
+	}
+	s.users[i] = u
+	s.persist()
+	return u, nil
+}
+
+// Delete は指定されたIDのユーザーを削除済みにする。
+// 検索と削除の間に他の更新が割り込まないよう、まとめて排他制御する。
+func (s *InMemoryStore) Delete(id int) (User, bool) {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.indexOf(id)
+	if i < 0 {
+		return User{}, false
+	}
+	deletedAt := now().UTC()
+	s.users[i].Deleted = true
+	s.users[i].DeletedAt = &deletedAt
+	s.persist()
+	return s.users[i], true
+}
+
+// Reset は全てのユーザーを削除し、次に割り当てるIDを1に戻す。
+func (s *InMemoryStore) Reset() error {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = []User{}
+	s.nextID.Store(1)
+	s.persist()
+	return nil
+}
+
+// Export は保存しているユーザーのコピーと次に割り当てるIDを返す。
+// 採番の途中の状態を書き出さないよう、ロックを取ってから読み取る。
+func (s *InMemoryStore) Export() (Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Backup{Users: slices.Clone(s.users), NextID: int(s.nextID.Load())}, nil
+}
+
+// Import は全てのユーザーをbのユーザーに置き換える。
+// 置き換えの途中の状態が読み取られないよう、確認から置き換えまでをロック内で行う。
+func (s *InMemoryStore) Import(b Backup) error {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(b.Users))
+	active := 0
+	for _, u := range b.Users {
+		if u.Deleted {
+			continue
+		}
+		key := strings.ToLower(u.Name)
+		if seen[key] {
+			return ErrDuplicateName
+		}
+		seen[key] = true
+		active++
+	}
+	if s.maxUsers > 0 && active > s.maxUsers {
+		return ErrStoreFull
+	}
+	s.users = slices.Clone(b.Users)
+	if s.users == nil {
+		s.users = []User{}
+	}
+	s.nextID.Store(int64(backupNextID(b)))
+	s.persist()
+	return nil
+}
+
+// Reindex は削除済みでないユーザー、削除済みのユーザーの順にIDを1から振り直す。
+// 振り直しの途中の状態が読み取られないよう、ロック内で行う。
+func (s *InMemoryStore) Reindex() (map[int]int, error) {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := slices.Clone(s.users)
+	ids := renumberUsers(users)
+	s.users = users
+	s.nextID.Store(int64(len(users) + 1))
+	s.persist()
+	return ids, nil
+}
+
+// renumberUsers はusersを削除済みでないユーザー、削除済みのユーザーの順に、それぞれIDの順で並べ替え、
+// 先頭から1、2、…とIDを振り直す。削除済みのユーザーも取り除かずに残す。元のIDから新しいIDへの対応を返す。
+func renumberUsers(users []User) map[int]int {
+	slices.SortFunc(users, func(a, b User) int {
+		if a.Deleted != b.Deleted {
+			if a.Deleted {
+				return 1
+			}
+			return -1
+		}
+		return a.ID - b.ID
+	})
+	ids := make(map[int]int, len(users))
+	for i := range users {
+		ids[users[i].ID] = i + 1
+		users[i].ID = i + 1
+	}
+	return ids
+}
+
+// Merge はmergeIDsのユーザーを削除済みにし、keepIDのユーザーを残す。
+// 一部のユーザーだけが削除された状態にならないよう、全てのIDを確認してからロック内でまとめて削除する。
+func (s *InMemoryStore) Merge(keepID int, mergeIDs []int) (User, []User, error) {
+	defer s.saveChanges()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := s.indexOf(keepID)
+	if keep < 0 {
+		return User{}, nil, fmt.Errorf("%w: %d", ErrUserNotFound, keepID)
+	}
+	indexes := make([]int, len(mergeIDs))
+	for i, id := range mergeIDs {
+		if indexes[i] = s.indexOf(id); indexes[i] < 0 {
+			return User{}, nil, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+		}
+	}
+
+	deletedAt := now().UTC()
+	merged := make([]User, len(indexes))
+	for i, idx := range indexes {
+		s.users[idx].Deleted = true
+		s.users[idx].DeletedAt = &deletedAt
+		merged[i] = s.users[idx]
+	}
+	s.persist()
+	return s.users[keep], merged, nil
+}
+
+// Flush は前回書き出してから変更があれば、現在のユーザー情報をファイルへ書き出す。
+// サーバ停止時など、明示的に永続化したいときに呼び出す。
+func (s *InMemoryStore) Flush() {
+	if s.file != "" {
+		s.writeFile()
+	}
+}
+
+// full はn人のユーザーを追加するとmaxUsersを超えるかを返す。削除済みのユーザーは数えない。
+// 同時に追加されても上限を超えないよう、s.muをロックした状態で呼び出すこと。
+func (s *InMemoryStore) full(n int) bool {
+	if s.maxUsers <= 0 {
+		return false
+	}
+	active := 0
+	for _, u := range s.users {
+		if !u.Deleted {
+			active++
+		}
+	}
+	return active+n > s.maxUsers
+}
+
+// allocateIDs は連続するn個のIDを採番し、その先頭のIDを返す。
+// ロックを取らずに呼び出せるため、IDの採番がスライスの操作と競合しない。
+func (s *InMemoryStore) allocateIDs(n int) int {
+	return int(s.nextID.Add(int64(n))) - n
+}
+
+// reserveID はidを後で採番しないよう、必要に応じてnextIDをidの次まで進める。
+func (s *InMemoryStore) reserveID(id int) {
+	for {
+		next := s.nextID.Load()
+		if int64(id) < next || s.nextID.CompareAndSwap(next, int64(id)+1) {
+			return
+		}
+	}
+}
+
+// untakenID は採番したidを返す。ロックを取るまでの間にUpsertが同じIDを使っていた場合は採番し直す。
+// s.muをロックした状態で呼び出すこと。
+func (s *InMemoryStore) untakenID(id int) int {
+	for slices.ContainsFunc(s.users, func(u User) bool { return u.ID == id }) {
+		id = s.allocateIDs(1)
+	}
+	return id
+}
+
+// indexOf はIDが一致する削除済みでないユーザーのusersでの位置を返す。見つからない場合は-1を返す。
+// s.muをロックした状態で呼び出すこと。
+func (s *InMemoryStore) indexOf(id int) int {
+	return slices.IndexFunc(s.users, func(u User) bool {
+		return u.ID == id && !u.Deleted
+	})
+}
+
+// nameTaken はexceptID以外のユーザーが同じ名前を使っているかを返す。大文字と小文字は区別しない。
+// 削除済みのユーザーの名前は使われていないものとして扱う。
+// s.muをロックした状態で呼び出すこと。
+func (s *InMemoryStore) nameTaken(name string, exceptID int) bool {
+	for _, u := range s.users {
+		if u.ID != exceptID && !u.Deleted && strings.EqualFold(u.Name, name) {
+			return true
+		}
+	}
+	return false
+}
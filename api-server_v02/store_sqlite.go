@@ -0,0 +1,687 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStore はユーザー情報をSQLiteのデータベースに保存するUserStoreの実装。
+// IDはデータベースの自動採番で割り当てる。
+//
+// UserStoreのメソッドのうちエラーを返せないもの（GetやAllなど）は、
+// データベースのエラーをログに出力したうえで見つからなかったものとして扱う。
+type SQLiteStore struct {
+	db *sql.DB
+
+	maxUsers int // 削除済みのものを除いて保存できるユーザー数の上限（0の場合は無制限）
+
+	insertStmt       *sql.Stmt
+	insertWithIDStmt *sql.Stmt
+	getStmt          *sql.Stmt
+	allStmt          *sql.Stmt
+	countStmt        *sql.Stmt
+	updateStmt       *sql.Stmt
+	deletedStmt      *sql.Stmt
+	uuidStmt         *sql.Stmt
+	deleteStmt       *sql.Stmt
+}
+
+// createUsersTable はusersテーブルを作成するSQL
+// 削除済みのユーザーはdeleted_atに削除日時を設定して残す
+// name_keyには名前の重複を確かめるため、nameKeyで大文字と小文字をそろえた名前を入れる
+const createUsersTable = `
+CREATE TABLE IF NOT EXISTS users (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT    NOT NULL,
+	email      TEXT    NOT NULL DEFAULT '',
+	created_at TEXT    NOT NULL,
+	deleted_at TEXT,
+	version    INTEGER NOT NULL DEFAULT 1,
+	uuid       TEXT    NOT NULL DEFAULT '',
+	updated_at TEXT    NOT NULL DEFAULT '',
+	name_key   TEXT    NOT NULL DEFAULT ''
+)`
+
+// createUsersNameIndex は名前の重複を防ぐインデックスを作成するSQL
+// 大文字と小文字をそろえたname_keyで比べ、削除済みのユーザーの名前は再び使えるよう対象から外す
+const createUsersNameIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS users_name_key ON users (name_key) WHERE deleted_at IS NULL`
+
+// nameKeyConstraint は名前の重複でユニーク制約に違反したときに、SQLiteのエラーが示す列
+const nameKeyConstraint = "users.name_key"
+
+// nameKey は名前の重複を大文字と小文字を区別せずに確かめるため、大文字と小文字をそろえた名前を返す。
+// SQLiteのNOCASEはASCIIの文字しかそろえないため、InMemoryStoreのstrings.EqualFoldに合わせてGoでそろえる。
+func nameKey(name string) string {
+	return strings.ToLower(strings.ToUpper(name))
+}
+
+// createUsersUUIDIndex はUUIDでユーザーを探すためのインデックスを作成するSQL
+// UUIDを割り当てていないユーザーは空文字のため対象から外す
+const createUsersUUIDIndex = `
+CREATE UNIQUE INDEX IF NOT EXISTS users_uuid ON users (uuid) WHERE uuid <> ''`
+
+// userColumns はユーザー情報を読み込むときに取得する列で、scanUserの引数の順に並べる
+const userColumns = `id, name, email, version, created_at, deleted_at, uuid, updated_at`
+
+// NewSQLiteStore はpathのデータベースを開き、usersテーブルがなければ作成する。
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLiteは同時に1つの書き込みしか行えないため、接続を1つに絞って操作を直列化する
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLiteStore{db: db}
+	stmts := []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&s.insertStmt, `INSERT INTO users (name, name_key, email, created_at, uuid, updated_at) VALUES (?, ?, ?, ?, ?, ?)`},
+		// 削除済みのユーザーと同じIDの場合は、そのユーザーを新しい内容で作り直す
+		{&s.insertWithIDStmt, `INSERT INTO users (id, name, name_key, email, created_at, uuid, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET name = excluded.name, name_key = excluded.name_key, email = excluded.email, created_at = excluded.created_at,
+			deleted_at = NULL, version = 1, uuid = excluded.uuid, updated_at = excluded.updated_at`},
+		{&s.getStmt, `SELECT ` + userColumns + ` FROM users WHERE id = ? AND deleted_at IS NULL`},
+		{&s.allStmt, `SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NULL ORDER BY id`},
+		{&s.deletedStmt, `SELECT ` + userColumns + ` FROM users WHERE deleted_at IS NOT NULL ORDER BY id`},
+		{&s.uuidStmt, `SELECT id FROM users WHERE uuid = ? AND uuid <> ''`},
+		{&s.countStmt, `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL`},
+		{&s.updateStmt, `UPDATE users SET name = ?, name_key = ?, email = ?, version = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`},
+		{&s.deleteStmt, `UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL RETURNING ` + userColumns},
+	}
+	for _, st := range stmts {
+		if *st.dst, err = db.Prepare(st.query); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// migrate はusersテーブルとインデックスを作成する。
+// 削除日時の列がない以前のテーブルは、名前のユニーク制約を削除済みのユーザーを除く
+// インデックスに置き換えるため、内容と採番の状態を引き継いで作り直す。
+// バージョンやUUID、更新日時の列がない以前のテーブルには列を追加し、
+// 既存のユーザーをバージョン1、UUIDなし、更新日時は作成日時とする。
+// name_keyの列がない以前のテーブルには列を追加して既存のユーザーの値を埋め、
+// 名前のインデックスをnameの列からname_keyの列のものに置き換える。
+func migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists, hasDeletedAt, hasVersion, hasUUID, hasUpdatedAt, hasNameKey bool
+	err = tx.QueryRow(`SELECT
+		EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'users'),
+		EXISTS (SELECT 1 FROM pragma_table_info('users') WHERE name = 'deleted_at'),
+		EXISTS (SELECT 1 FROM pragma_table_info('users') WHERE name = 'version'),
+		EXISTS (SELECT 1 FROM pragma_table_info('users') WHERE name = 'uuid'),
+		EXISTS (SELECT 1 FROM pragma_table_info('users') WHERE name = 'updated_at'),
+		EXISTS (SELECT 1 FROM pragma_table_info('users') WHERE name = 'name_key')`).Scan(&exists, &hasDeletedAt, &hasVersion, &hasUUID, &hasUpdatedAt, &hasNameKey)
+	if err != nil {
+		return err
+	}
+	steps := []string{createUsersTable}
+	switch {
+	case exists && !hasDeletedAt:
+		steps = []string{
+			`ALTER TABLE users RENAME TO users_old`,
+			createUsersTable,
+			`INSERT INTO users (id, name, email, created_at, updated_at) SELECT id, name, email, created_at, created_at FROM users_old`,
+			`DELETE FROM sqlite_sequence WHERE name = 'users'`,
+			`INSERT INTO sqlite_sequence (name, seq) SELECT 'users', seq FROM sqlite_sequence WHERE name = 'users_old'`,
+			`DROP TABLE users_old`,
+		}
+	case exists:
+		steps = nil
+		if !hasVersion {
+			steps = append(steps, `ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1`)
+		}
+		if !hasUUID {
+			steps = append(steps, `ALTER TABLE users ADD COLUMN uuid TEXT NOT NULL DEFAULT ''`)
+		}
+		if !hasUpdatedAt {
+			steps = append(steps,
+				`ALTER TABLE users ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`,
+				`UPDATE users SET updated_at = created_at`)
+		}
+		if !hasNameKey {
+			steps = append(steps, `ALTER TABLE users ADD COLUMN name_key TEXT NOT NULL DEFAULT ''`)
+		}
+	}
+	steps = append(steps, `DROP INDEX IF EXISTS users_name`)
+	for _, q := range steps {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	// 大文字と小文字はGoでそろえるため、name_keyを追加した場合は既存の行ごとに埋める
+	if exists && !hasNameKey {
+		if err := fillNameKeys(tx); err != nil {
+			return err
+		}
+	}
+	for _, q := range []string{createUsersNameIndex, createUsersUUIDIndex} {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// fillNameKeys はtxの中で、全てのユーザーのname_keyをnameKeyで求めた値に設定する。
+func fillNameKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, name FROM users`)
+	if err != nil {
+		return err
+	}
+	keys := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = nameKey(name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err := tx.Exec(`UPDATE users SET name_key = ? WHERE id = ?`, key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close はプリペアドステートメントとデータベースを閉じる。
+func (s *SQLiteStore) Close() error {
+	for _, st := range []*sql.Stmt{s.insertStmt, s.insertWithIDStmt, s.getStmt, s.allStmt, s.deletedStmt, s.uuidStmt, s.countStmt, s.updateStmt, s.deleteStmt} {
+		if st != nil {
+			st.Close()
+		}
+	}
+	return s.db.Close()
+}
+
+// Add は新しいユーザーを追加する。IDはデータベースの自動採番で割り当てる。
+// 上限の確認と追加の間に他の追加が割り込まないよう、AddManyと同じく1つのトランザクションで行う。
+func (s *SQLiteStore) Add(u User) (User, error) {
+	added, err := s.AddMany([]User{u})
+	if err != nil {
+		return User{}, err
+	}
+	return added[0], nil
+}
+
+// AddMany は複数のユーザーを1つのトランザクションでまとめて追加する。
+func (s *SQLiteStore) AddMany(us []User) ([]User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := s.checkCapacity(tx, len(us)); err != nil {
+		return nil, err
+	}
+	stmt := tx.Stmt(s.insertStmt)
+	added := make([]User, 0, len(us))
+	for _, u := range us {
+		u, err := s.insert(stmt, u)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, u)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// checkCapacity はn人のユーザーを追加するとmaxUsersを超える場合にErrStoreFullを返す。
+// 接続を1つに絞っているため、同じトランザクションで追加するまで他の追加は割り込まない。
+func (s *SQLiteStore) checkCapacity(tx *sql.Tx, n int) error {
+	if s.maxUsers <= 0 {
+		return nil
+	}
+	var count int
+	if err := tx.Stmt(s.countStmt).QueryRow().Scan(&count); err != nil {
+		return err
+	}
+	if count+n > s.maxUsers {
+		return ErrStoreFull
+	}
+	return nil
+}
+
+// insert はstmtでユーザーを1件追加し、割り当てられたIDを設定して返す。
+func (s *SQLiteStore) insert(stmt *sql.Stmt, u User) (User, error) {
+	u.CreatedAt = now().UTC()
+	u.UpdatedAt = u.CreatedAt
+	u.Version = 1
+	u.Deleted, u.DeletedAt = false, nil
+	createdAt := u.CreatedAt.Format(time.RFC3339Nano)
+	res, err := stmt.Exec(u.Name, nameKey(u.Name), u.Email, createdAt, u.UUID, createdAt)
+	if err != nil {
+		return User{}, translateSQLiteError(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	u.ID = int(id)
+	return u, nil
+}
+
+// Get は指定されたIDのユーザーを返す。
+func (s *SQLiteStore) Get(id int) (User, bool) {
+	u, err := scanUser(s.getStmt.QueryRow(id))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to get user %d: %v", id, err)
+		}
+		return User{}, false
+	}
+	return u, true
+}
+
+// All は削除済みのものを除く全てのユーザーをIDの順に返す。
+func (s *SQLiteStore) All() []User {
+	all, err := queryUsers(s.allStmt)
+	if err != nil {
+		log.Printf("failed to list users: %v", err)
+		return []User{}
+	}
+	return all
+}
+
+// queryUsers はstmtで取得した全ての行をユーザー情報として返す。
+func queryUsers(stmt *sql.Stmt) ([]User, error) {
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Deleted は削除済みのユーザーをIDの順に返す。
+func (s *SQLiteStore) Deleted() []User {
+	deleted, err := queryUsers(s.deletedStmt)
+	if err != nil {
+		log.Printf("failed to list deleted users: %v", err)
+		return []User{}
+	}
+	return deleted
+}
+
+// IDForUUID はUUIDが一致するユーザーのIDを返す。
+func (s *SQLiteStore) IDForUUID(uuid string) (int, bool) {
+	var id int
+	if err := s.uuidStmt.QueryRow(uuid).Scan(&id); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to find user by uuid: %v", err)
+		}
+		return 0, false
+	}
+	return id, true
+}
+
+// Count は削除済みのものを除くユーザーの数を返す。
+func (s *SQLiteStore) Count() int {
+	var n int
+	if err := s.countStmt.QueryRow().Scan(&n); err != nil {
+		log.Printf("failed to count users: %v", err)
+		return 0
+	}
+	return n
+}
+
+// Upsert はIDが一致するユーザーを置き換え、存在しない場合はそのIDで追加する。
+// AUTOINCREMENTの採番は明示したIDより後から続くため、後のAddで同じIDが割り当てられることはない。
+func (s *SQLiteStore) Upsert(u User, check func(cur User) error) (User, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, false, err
+	}
+	defer tx.Rollback()
+
+	cur, err := scanUser(tx.Stmt(s.getStmt).QueryRow(u.ID))
+	created := errors.Is(err, sql.ErrNoRows)
+	u.Deleted, u.DeletedAt = false, nil
+	switch {
+	case created:
+		if err := s.checkCapacity(tx, 1); err != nil {
+			return User{}, false, err
+		}
+		u.CreatedAt = now().UTC()
+		u.UpdatedAt = u.CreatedAt
+		u.Version = 1
+		createdAt := u.CreatedAt.Format(time.RFC3339Nano)
+		_, err = tx.Stmt(s.insertWithIDStmt).Exec(u.ID, u.Name, nameKey(u.Name), u.Email, createdAt, u.UUID, createdAt)
+	case err != nil:
+		return User{}, false, err
+	default:
+		if check != nil {
+			if err := check(cur); err != nil {
+				return User{}, false, err
+			}
+		}
+		u.UUID = cur.UUID
+		u.CreatedAt = cur.CreatedAt
+		u.UpdatedAt = now().UTC()
+		u.Version = cur.Version + 1
+		_, err = tx.Stmt(s.updateStmt).Exec(u.Name, nameKey(u.Name), u.Email, u.Version, u.UpdatedAt.Format(time.RFC3339Nano), u.ID)
+	}
+	if err != nil {
+		return User{}, false, translateSQLiteError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, false, err
+	}
+	return u, created, nil
+}
+
+// Modify はIDが一致するユーザーをfnで書き換える。
+// 読み取りと書き込みを1つのトランザクションで行う。
+func (s *SQLiteStore) Modify(id int, fn func(u *User) error) (User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	u, err := scanUser(tx.Stmt(s.getStmt).QueryRow(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	cur := u
+	if err := fn(&u); err != nil {
+		return User{}, err
+	}
+	// ID、UUID、作成日時、バージョン、削除の状態は書き換えの対象にしない
+	u.ID = id
+	u.UUID = cur.UUID
+	u.CreatedAt = cur.CreatedAt
+	u.UpdatedAt = now().UTC()
+	u.Version = cur.Version + 1
+	u.Deleted, u.DeletedAt = false, nil
+
+	if _, err := tx.Stmt(s.updateStmt).Exec(u.Name, nameKey(u.Name), u.Email, u.Version, u.UpdatedAt.Format(time.RFC3339Nano), id); err != nil {
+		return User{}, translateSQLiteError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+// Delete は指定されたIDのユーザーに削除日時を設定し、削除したユーザーを返す。
+func (s *SQLiteStore) Delete(id int) (User, bool) {
+	u, err := scanUser(s.deleteStmt.QueryRow(now().UTC().Format(time.RFC3339Nano), id))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to delete user %d: %v", id, err)
+		}
+		return User{}, false
+	}
+	return u, true
+}
+
+// Reset は全てのユーザーを削除し、自動採番の値も初期状態に戻す。
+func (s *SQLiteStore) Reset() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM users`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = 'users'`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Export は削除済みのものを含む全てのユーザーをIDの順に返す。
+// 次に割り当てるIDは自動採番の値から求め、ユーザーと同じトランザクションで読み取る。
+func (s *SQLiteStore) Export() (Backup, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Backup{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer rows.Close()
+	b := Backup{Users: []User{}}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return Backup{}, err
+		}
+		b.Users = append(b.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return Backup{}, err
+	}
+	var seq int
+	err = tx.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name = 'users'`).Scan(&seq)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Backup{}, err
+	}
+	b.NextID = seq + 1
+	return b, tx.Commit()
+}
+
+// Import は全てのユーザーをbのユーザーに置き換え、自動採番の値をb.NextIDの手前に合わせる。
+// 失敗した場合に元の状態が失われないよう、削除から追加までを1つのトランザクションで行う。
+func (s *SQLiteStore) Import(b Backup) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceUsers(tx, b.Users); err != nil {
+		return err
+	}
+	if err := s.checkCapacity(tx, 0); err != nil {
+		return err
+	}
+	if err := setNextID(tx, backupNextID(b)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reindex は削除済みでないユーザー、削除済みのユーザーの順にIDを1から振り直す。
+// 読み取りから書き換えまでを1つのトランザクションで行う。
+func (s *SQLiteStore) Reindex() (map[int]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ` + userColumns + ` FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := renumberUsers(users)
+	if err := replaceUsers(tx, users); err != nil {
+		return nil, err
+	}
+	if err := setNextID(tx, len(users)+1); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Merge はmergeIDsのユーザーを削除済みにし、keepIDのユーザーを残す。
+// 確認から削除までを1つのトランザクションで行い、存在しないIDがあればどのユーザーも削除しない。
+func (s *SQLiteStore) Merge(keepID int, mergeIDs []int) (User, []User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return User{}, nil, err
+	}
+	defer tx.Rollback()
+
+	kept, err := scanUser(tx.Stmt(s.getStmt).QueryRow(keepID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, nil, fmt.Errorf("%w: %d", ErrUserNotFound, keepID)
+	}
+	if err != nil {
+		return User{}, nil, err
+	}
+	deletedAt := now().UTC().Format(time.RFC3339Nano)
+	merged := make([]User, len(mergeIDs))
+	for i, id := range mergeIDs {
+		u, err := scanUser(tx.Stmt(s.deleteStmt).QueryRow(deletedAt, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, nil, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+		}
+		if err != nil {
+			return User{}, nil, err
+		}
+		merged[i] = u
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, nil, err
+	}
+	return kept, merged, nil
+}
+
+// replaceUsers はtxの中で全ての行を取り除き、usersをそれぞれのIDで書き込む。
+func replaceUsers(tx *sql.Tx, users []User) error {
+	if _, err := tx.Exec(`DELETE FROM users`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO users (id, name, name_key, email, created_at, deleted_at, version, uuid, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, u := range users {
+		var deletedAt sql.NullString
+		if u.DeletedAt != nil {
+			deletedAt = sql.NullString{String: u.DeletedAt.Format(time.RFC3339Nano), Valid: true}
+		}
+		_, err := stmt.Exec(u.ID, u.Name, nameKey(u.Name), u.Email, u.CreatedAt.Format(time.RFC3339Nano), deletedAt,
+			u.Version, u.UUID, u.UpdatedAt.Format(time.RFC3339Nano))
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+	}
+	return nil
+}
+
+// setNextID はtxの中で、次に自動採番するIDがnextIDになるよう自動採番の値を設定する。
+func setNextID(tx *sql.Tx, nextID int) error {
+	if _, err := tx.Exec(`DELETE FROM sqlite_sequence WHERE name = 'users'`); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES ('users', ?)`, nextID-1)
+	return err
+}
+
+// rowScanner は*sql.Rowと*sql.Rowsに共通するScanメソッドを表すインターフェース。
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser は1行分の結果をUserに読み込む。
+// 読み込む列の順はuserColumnsに合わせる。
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	var createdAt, updatedAt string
+	var deletedAt sql.NullString
+	if err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Version, &createdAt, &deletedAt, &u.UUID, &updatedAt); err != nil {
+		return User{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return User{}, err
+	}
+	u.CreatedAt = t
+	if u.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt); err != nil {
+		return User{}, err
+	}
+	if deletedAt.Valid {
+		t, err := time.Parse(time.RFC3339Nano, deletedAt.String)
+		if err != nil {
+			return User{}, err
+		}
+		u.Deleted = true
+		u.DeletedAt = &t
+	}
+	return u, nil
+}
+
+// translateSQLiteError は名前のユニーク制約違反をErrDuplicateNameに変換する。
+// UUIDなど他の列のユニーク制約違反は名前の重複ではないため、そのまま返す。
+func translateSQLiteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), nameKeyConstraint) {
+		return ErrDuplicateName
+	}
+	return err
+}
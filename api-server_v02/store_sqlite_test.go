@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testStores はUserStoreの実装ごとに、テスト用の空の保存先を開く関数
+var testStores = []struct {
+	name string
+	open func(t *testing.T) UserStore
+}{
+	{"memory", func(t *testing.T) UserStore { return NewInMemoryStore("") }},
+	{"sqlite", func(t *testing.T) UserStore { return openTestSQLiteStore(t, filepath.Join(t.TempDir(), "users.db")) }},
+}
+
+// openTestSQLiteStore はpathのデータベースを開く。開いたデータベースはテストの終了時に閉じる。
+func openTestSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// addTestUsers はnamesのユーザーを順に追加する。追加できない場合はテストを失敗させる。
+func addTestUsers(t *testing.T, s UserStore, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := s.Add(User{Name: name}); err != nil {
+			t.Fatalf("Add(%q): %v", name, err)
+		}
+	}
+}
+
+// TestUserStoreParity はメモリ上の保存先とSQLiteの保存先が同じ操作に同じ結果を返すことを確かめる。
+func TestUserStoreParity(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s UserStore)
+	}{
+		{"add assigns ids in order", func(t *testing.T, s UserStore) {
+			addTestUsers(t, s, "alice", "bob")
+			u, err := s.Add(User{Name: "carol", Email: "carol@example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.ID != 3 || u.Version != 1 || u.CreatedAt.IsZero() {
+				t.Errorf("Add(carol) = %+v, want id 3 with version 1 and a creation time", u)
+			}
+			if got, ok := s.Get(3); !ok || got.Name != "carol" || got.Email != "carol@example.com" {
+				t.Errorf("Get(3) = %+v, %t, want carol", got, ok)
+			}
+			if got, want := userNames(s.All()), []string{"alice", "bob", "carol"}; !slices.Equal(got, want) {
+				t.Errorf("All() = %v, want %v", got, want)
+			}
+			if got := s.Count(); got != 3 {
+				t.Errorf("Count() = %d, want 3", got)
+			}
+		}},
+		{"duplicate name ignoring case", func(t *testing.T, s UserStore) {
+			addTestUsers(t, s, "alice")
+			if _, err := s.Add(User{Name: "ALICE"}); !errors.Is(err, ErrDuplicateName) {
+				t.Errorf("Add(ALICE) error = %v, want %v", err, ErrDuplicateName)
+			}
+			if _, err := s.AddMany([]User{{Name: "bob"}, {Name: "Alice"}}); !errors.Is(err, ErrDuplicateName) {
+				t.Errorf("AddMany with a duplicate error = %v, want %v", err, ErrDuplicateName)
+			}
+			if got := s.Count(); got != 1 {
+				t.Errorf("Count() after rejected adds = %d, want 1", got)
+			}
+		}},
+		{"delete keeps the user as deleted", func(t *testing.T, s UserStore) {
+			addTestUsers(t, s, "alice", "bob")
+			if u, ok := s.Delete(1); !ok || !u.Deleted || u.DeletedAt == nil {
+				t.Fatalf("Delete(1) = %+v, %t, want alice marked as deleted", u, ok)
+			}
+			if _, ok := s.Delete(1); ok {
+				t.Error("Delete(1) twice: found the deleted user again")
+			}
+			if _, ok := s.Get(1); ok {
+				t.Error("Get(1) found a deleted user")
+			}
+			if got := userNames(s.Deleted()); !slices.Equal(got, []string{"alice"}) {
+				t.Errorf("Deleted() = %v, want [alice]", got)
+			}
+			// 削除済みのユーザーの名前とIDは再び使える
+			u, err := s.Add(User{Name: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.ID != 3 {
+				t.Errorf("Add(alice) after deleting alice assigned id %d, want 3", u.ID)
+			}
+		}},
+		{"modify", func(t *testing.T, s UserStore) {
+			addTestUsers(t, s, "alice", "bob")
+			u, err := s.Modify(1, func(u *User) error { u.Email = "alice@example.com"; return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Version != 2 || u.Email != "alice@example.com" {
+				t.Errorf("Modify(1) = %+v, want version 2 with the new email", u)
+			}
+			if _, err := s.Modify(1, func(u *User) error { u.Name = "Bob"; return nil }); !errors.Is(err, ErrDuplicateName) {
+				t.Errorf("Modify(1) to a taken name error = %v, want %v", err, ErrDuplicateName)
+			}
+			if _, err := s.Modify(9, func(u *User) error { return nil }); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("Modify(9) error = %v, want %v", err, ErrUserNotFound)
+			}
+			if got, _ := s.Get(1); got.Name != "alice" || got.Version != 2 {
+				t.Errorf("Get(1) after a rejected modify = %+v, want alice at version 2", got)
+			}
+		}},
+		{"upsert", func(t *testing.T, s UserStore) {
+			addTestUsers(t, s, "alice")
+			u, created, err := s.Upsert(User{ID: 10, Name: "bob"}, nil)
+			if err != nil || !created || u.Version != 1 {
+				t.Fatalf("Upsert(10) = %+v, %t, %v, want bob created at version 1", u, created, err)
+			}
+			u, created, err = s.Upsert(User{ID: 10, Name: "bob2"}, nil)
+			if err != nil || created || u.Version != 2 {
+				t.Errorf("Upsert(10) again = %+v, %t, %v, want bob2 replaced at version 2", u, created, err)
+			}
+			// 明示したIDは後のAddで再び割り当てない
+			if u, _ := s.Add(User{Name: "carol"}); u.ID != 11 {
+				t.Errorf("Add after Upsert(10) assigned id %d, want 11", u.ID)
+			}
+		}},
+		{"reset", func(t *testing.T, s UserStore) {
+			addTestUsers(t, s, "alice", "bob")
+			s.Delete(2)
+			if err := s.Reset(); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Count(); got != 0 || len(s.Deleted()) != 0 {
+				t.Errorf("after Reset: Count() = %d, Deleted() = %v, want none", got, s.Deleted())
+			}
+			if u, _ := s.Add(User{Name: "alice"}); u.ID != 1 {
+				t.Errorf("Add after Reset assigned id %d, want 1", u.ID)
+			}
+		}},
+		{"uuid", func(t *testing.T, s UserStore) {
+			if _, err := s.Add(User{Name: "alice", UUID: "0b5e7a6c-2d1f-4c3e-9a8b-7f6e5d4c3b2a"}); err != nil {
+				t.Fatal(err)
+			}
+			if id, ok := s.IDForUUID("0b5e7a6c-2d1f-4c3e-9a8b-7f6e5d4c3b2a"); !ok || id != 1 {
+				t.Errorf("IDForUUID = %d, %t, want 1", id, ok)
+			}
+			if _, ok := s.IDForUUID(""); ok {
+				t.Error("IDForUUID(\"\") found a user")
+			}
+		}},
+	}
+	for _, st := range testStores {
+		for _, tt := range tests {
+			t.Run(st.name+"/"+tt.name, func(t *testing.T) {
+				tt.run(t, st.open(t))
+			})
+		}
+	}
+}
+
+func TestSQLiteStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestUsers(t, s, "alice", "bob", "carol")
+	s.Delete(3)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 開き直しても削除済みのものを含めて残り、削除したユーザーのIDは再び割り当てない
+	s = openTestSQLiteStore(t, path)
+	if got := userNames(s.All()); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("All() after reopening = %v, want [alice bob]", got)
+	}
+	if got := userNames(s.Deleted()); !slices.Equal(got, []string{"carol"}) {
+		t.Errorf("Deleted() after reopening = %v, want [carol]", got)
+	}
+	if u, err := s.Add(User{Name: "dave"}); err != nil || u.ID != 4 {
+		t.Errorf("Add after reopening = %+v, %v, want id 4", u, err)
+	}
+}
+
+func TestSQLiteStoreMaxUsers(t *testing.T) {
+	s := openTestSQLiteStore(t, filepath.Join(t.TempDir(), "users.db"))
+	s.maxUsers = 2
+	addTestUsers(t, s, "alice", "bob")
+	if _, err := s.Add(User{Name: "carol"}); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Add over the limit error = %v, want %v", err, ErrStoreFull)
+	}
+	// 削除済みのユーザーは上限に数えない
+	s.Delete(1)
+	if _, err := s.Add(User{Name: "carol"}); err != nil {
+		t.Errorf("Add after deleting a user: %v", err)
+	}
+}
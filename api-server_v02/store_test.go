@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInMemoryStoreConcurrentIDsUnique(t *testing.T) {
+	const workers, perWorker = 16, 50
+	tests := []struct {
+		name string
+		add  func(s *InMemoryStore, w, i int) error
+	}{
+		{"Add", func(s *InMemoryStore, w, i int) error {
+			_, err := s.Add(User{Name: fmt.Sprintf("w%d-%d", w, i)})
+			return err
+		}},
+		{"AddMany", func(s *InMemoryStore, w, i int) error {
+			_, err := s.AddMany([]User{{Name: fmt.Sprintf("w%d-%da", w, i)}, {Name: fmt.Sprintf("w%d-%db", w, i)}})
+			return err
+		}},
+		// 採番した後にロックを取るまでの間に、UpsertがそのIDを使う場合もIDが重ならないことを確かめる
+		{"Add and Upsert", func(s *InMemoryStore, w, i int) error {
+			if w%2 == 0 {
+				_, err := s.Add(User{Name: fmt.Sprintf("w%d-%d", w, i)})
+				return err
+			}
+			_, _, err := s.Upsert(User{ID: w*perWorker + i, Name: fmt.Sprintf("w%d-%d", w, i)}, nil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewInMemoryStore("")
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						if err := tt.add(s, w, i); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			seen := map[int]bool{}
+			for _, u := range s.All() {
+				if seen[u.ID] {
+					t.Fatalf("id %d was assigned to more than one user", u.ID)
+				}
+				seen[u.ID] = true
+			}
+		})
+	}
+}
+
+// BenchmarkAllocateID は並行に採番したときの、atomicによる採番とmutexで守った採番のスループットを比べる。
+func BenchmarkAllocateID(b *testing.B) {
+	b.Run("atomic", func(b *testing.B) {
+		s := NewInMemoryStore("")
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				s.allocateIDs(1)
+			}
+		})
+	})
+	b.Run("mutex", func(b *testing.B) {
+		var (
+			mu     sync.Mutex
+			nextID = 1
+		)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				nextID++
+				mu.Unlock()
+			}
+		})
+	})
+}
+
+// BenchmarkInMemoryStoreAddParallel は並行に追加したときのスループットを測る。
+func BenchmarkInMemoryStoreAddParallel(b *testing.B) {
+	s := NewInMemoryStore("")
+	var n atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := s.Add(User{Name: fmt.Sprintf("user%d", n.Add(1))}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestInMemoryStoreAllReturnsCopy(t *testing.T) {
+	s := NewInMemoryStore("")
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := s.Add(User{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := s.All()
+	snapshot[0].Name = "mallory"
+	if u, _ := s.Get(1); u.Name != "alice" {
+		t.Errorf("changing the result of All changed the store: user 1 is %q", u.Name)
+	}
+
+	// 後からの書き込みは、先に取得したコピーに影響しない
+	if _, err := s.Modify(2, func(u *User) error { u.Name = "bob2"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(User{Name: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := userNames(snapshot), []string{"mallory", "bob"}; !slices.Equal(got, want) {
+		t.Errorf("earlier result of All = %v after writes, want %v", got, want)
+	}
+	if got, want := userNames(s.All()), []string{"alice", "bob2", "carol"}; !slices.Equal(got, want) {
+		t.Errorf("All() = %v, want %v", got, want)
+	}
+}
+
+// TestGetAllUsersDuringWrites は一覧を返している間に追加が続いても、各レスポンスが一貫していることを確かめる。
+func TestGetAllUsersDuringWrites(t *testing.T) {
+	const writes = 200
+	ts := newTestServer(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < writes; i++ {
+			if _, err := ts.app.store.Add(User{Name: fmt.Sprintf("user%d", i)}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		res, data := ts.do(http.MethodGet, "/users?limit=1000", "")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET /users: status = %d: %s", res.StatusCode, data)
+		}
+		page := decodeTestJSON[UsersResponse](t, data)
+		// 読み取った時点の全員を、欠けや重複なくIDの順に返す
+		if got, want := userIDs(page.Users), idRange(1, page.Total); !slices.Equal(got, want) {
+			t.Fatalf("GET /users during writes returned ids %v with total %d", got, page.Total)
+		}
+	}
+	if got := ts.app.store.Count(); got != writes {
+		t.Errorf("Count() = %d, want %d", got, writes)
+	}
+}
+
+// BenchmarkListLockHold は一覧を読み取る間の書き込みの待ち時間を、
+// ロックを取ったままエンコードする場合と、コピーを取ってからロックの外でエンコードする場合で比べる。
+// write-wait-ns/opは読み取りと並行して行った書き込み1回あたりの平均の所要時間。
+func BenchmarkListLockHold(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		read func(s *InMemoryStore) error
+	}{
+		{"encode under lock", func(s *InMemoryStore) error {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			_, err := json.Marshal(s.users)
+			return err
+		}},
+		{"copy then encode", func(s *InMemoryStore) error {
+			_, err := json.Marshal(s.All())
+			return err
+		}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			s := NewInMemoryStore("")
+			for i := 0; i < 1000; i++ {
+				if _, err := s.Add(User{Name: fmt.Sprintf("user%d", i)}); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			stop := make(chan struct{})
+			measured := make(chan time.Duration)
+			go func() {
+				var total time.Duration
+				n := 0
+				for {
+					select {
+					case <-stop:
+						if n > 0 {
+							total /= time.Duration(n)
+						}
+						measured <- total
+						return
+					default:
+					}
+					start := time.Now()
+					s.Modify(1, func(u *User) error { return nil })
+					total += time.Since(start)
+					n++
+				}
+			}()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := bm.read(s); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			b.ReportMetric(float64(<-measured), "write-wait-ns/op")
+		})
+	}
+}
+
+func TestReindexKeepsDeletedUsers(t *testing.T) {
+	for _, st := range testStores {
+		t.Run(st.name, func(t *testing.T) {
+			s := st.open(t)
+			for _, name := range []string{"alice", "bob", "carol", "dave"} {
+				if _, err := s.Add(User{Name: name}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range []int{1, 3} {
+				if _, ok := s.Delete(id); !ok {
+					t.Fatalf("Delete(%d): not found", id)
+				}
+			}
+			// 削除済みのユーザーと同じ名前のユーザーがいても振り直せる
+			if _, err := s.Add(User{Name: "alice"}); err != nil {
+				t.Fatal(err)
+			}
+
+			ids, err := s.Reindex()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 削除済みでないユーザーをIDの順に1から詰め、削除済みのユーザーをその後に続ける
+			if want := map[int]int{2: 1, 4: 2, 5: 3, 1: 4, 3: 5}; !maps.Equal(ids, want) {
+				t.Errorf("Reindex() = %v, want %v", ids, want)
+			}
+
+			tests := []struct {
+				id          int
+				wantName    string
+				wantDeleted bool
+			}{
+				{1, "bob", false},
+				{2, "dave", false},
+				{3, "alice", false},
+				{4, "alice", true},
+				{5, "carol", true},
+			}
+			deleted := map[int]User{}
+			for _, u := range s.Deleted() {
+				deleted[u.ID] = u
+			}
+			for _, tt := range tests {
+				u, ok := s.Get(tt.id)
+				if tt.wantDeleted {
+					u, ok = deleted[tt.id]
+				}
+				if !ok || u.Name != tt.wantName || u.Deleted != tt.wantDeleted {
+					t.Errorf("user %d after Reindex = %+v (found %t), want %s with deleted %t", tt.id, u, ok, tt.wantName, tt.wantDeleted)
+				}
+			}
+			if got := s.Count(); got != 3 {
+				t.Errorf("Count() after Reindex = %d, want 3", got)
+			}
+
+			// 次に割り当てるIDは削除済みのユーザーも含めた数の次になる
+			u, err := s.Add(User{Name: "erin"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.ID != 6 {
+				t.Errorf("Add after Reindex assigned id %d, want 6", u.ID)
+			}
+		})
+	}
+}
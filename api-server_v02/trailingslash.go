@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 末尾のスラッシュの扱い方（TRAILING_SLASH）
+const (
+	trailingSlashKeep     = ""         // 何もせず、ServeMuxのパターンとの一致に任せる
+	trailingSlashStrip    = "strip"    // 末尾のスラッシュを取り除いたパスとして処理する
+	trailingSlashRedirect = "redirect" // 末尾のスラッシュを取り除いたパスへ308でリダイレクトする
+)
+
+// withTrailingSlash は "/users/" のように末尾にスラッシュが付いたパスをpolicyに従って扱うミドルウェア
+// ServeMuxでは "/users" と "/users/" が別のパスとなり、片方だけが404になるのを揃えるために使う
+// ルートの "/" はそのまま通す。リダイレクトではメソッドとボディを保つよう308を返し、クエリ文字列も引き継ぐ
+// 先頭の連続したスラッシュも1つにまとめる。"//evil.example/" のリダイレクト先が "//evil.example" となり、
+// ブラウザに別のホストとして解釈されないようにするため
+func withTrailingSlash(next http.Handler, policy string) http.Handler {
+	if policy == trailingSlashKeep {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || !strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u := *r.URL
+		u.Path = trimSlashes(u.Path)
+		if u.RawPath != "" {
+			u.RawPath = trimSlashes(u.RawPath)
+		}
+		if policy == trailingSlashRedirect {
+			// 相対的なURLとしてパスとクエリ文字列だけを返す
+			target := basePath(r) + u.EscapedPath()
+			if u.RawQuery != "" {
+				target += "?" + u.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL = &u
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// trimSlashes はpの末尾のスラッシュを取り除き、先頭の連続したスラッシュを1つにまとめる。
+func trimSlashes(p string) string {
+	return "/" + strings.TrimLeft(strings.TrimRight(p, "/"), "/")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithTrailingSlash(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		target       string
+		wantStatus   int
+		wantLocation string // リダイレクト先（redirectの場合）
+		wantPath     string // 次のハンドラが受け取るパスとクエリ文字列（redirect以外の場合）
+	}{
+		{"redirect", trailingSlashRedirect, "/users/", http.StatusPermanentRedirect, "/users", ""},
+		{"redirect keeps query", trailingSlashRedirect, "/users/?limit=5", http.StatusPermanentRedirect, "/users?limit=5", ""},
+		{"redirect keeps escaped path", trailingSlashRedirect, "/users/a%2Fb/", http.StatusPermanentRedirect, "/users/a%2Fb", ""},
+		{"redirect collapses leading slashes", trailingSlashRedirect, "//evil.example/", http.StatusPermanentRedirect, "/evil.example", ""},
+		{"redirect collapses many leading slashes", trailingSlashRedirect, "///evil.example//", http.StatusPermanentRedirect, "/evil.example", ""},
+		{"redirect only slashes", trailingSlashRedirect, "///", http.StatusPermanentRedirect, "/", ""},
+		{"redirect root", trailingSlashRedirect, "/", http.StatusOK, "", "/"},
+		{"redirect no trailing slash", trailingSlashRedirect, "/users?limit=5", http.StatusOK, "", "/users?limit=5"},
+		{"strip", trailingSlashStrip, "/users/?limit=5", http.StatusOK, "", "/users?limit=5"},
+		{"strip collapses leading slashes", trailingSlashStrip, "//evil.example/", http.StatusOK, "", "/evil.example"},
+		{"strip root", trailingSlashStrip, "/", http.StatusOK, "", "/"},
+		{"keep", trailingSlashKeep, "//users/", http.StatusOK, "", "//users/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			h := withTrailingSlash(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.RequestURI()
+			}), tt.policy)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("GET %s with %q: status = %d, want %d", tt.target, tt.policy, rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("GET %s with %q: Location = %q, want %q", tt.target, tt.policy, got, tt.wantLocation)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("GET %s with %q: next handler got %q, want %q", tt.target, tt.policy, gotPath, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// FieldError は入力値の検証で見つかったフィールドごとの誤りを表す構造体。
+type FieldError struct {
+	Field   string `json:"field"`   // 誤りのあるフィールドのJSONでの名前
+	Message string `json:"message"` // 誤りの内容
+}
+
+// ValidationErrors は入力値の検証で見つかった誤りの一覧。
+// 書き換え用の関数などからerrorとして返せるよう、errorインターフェースを実装する。
+type ValidationErrors []FieldError
+
+// Error は全ての誤りを1つの文字列にまとめて返す。
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Field + ": " + e.Message
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// withIndex は一括で扱うユーザーのうちi番目の誤りであることが分かるよう、フィールド名に添字を付ける。
+func (errs ValidationErrors) withIndex(i int) ValidationErrors {
+	indexed := make(ValidationErrors, len(errs))
+	for j, e := range errs {
+		indexed[j] = FieldError{Field: fmt.Sprintf("[%d].%s", i, e.Field), Message: e.Message}
+	}
+	return indexed
+}
+
+// ValidationErrorResponse は入力値の検証に失敗した場合のレスポンスを表す構造体。
+// フロントエンドが誤りのある入力欄を特定できるよう、フィールドごとの誤りを返す。
+type ValidationErrorResponse struct {
+	Errors ValidationErrors `json:"errors"`
+}
+
+// writeValidationErrors は検証で見つかった誤りの一覧を422として書き込む。
+func writeValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	writeJSON(w, http.StatusUnprocessableEntity, ValidationErrorResponse{Errors: errs})
+}
+
+// normalizeUser は保存前にユーザー情報の前後の空白を取り除く。
+// 見た目が同じ名前を重複の確認や検索で同じものとして扱えるよう、名前はUnicodeのNFCに正規化する。
+func normalizeUser(u *User) {
+	u.Name = norm.NFC.String(strings.TrimSpace(u.Name))
+	u.Email = strings.TrimSpace(u.Email)
+}
+
+// validateUser はユーザー情報の入力値を検証し、見つかった全ての誤りを返す。
+// 誤りがない場合はnilを返す。作成と更新の両方で共通して利用する。
+func validateUser(u User) ValidationErrors {
+	var errs ValidationErrors
+	if strings.TrimSpace(u.Name) == "" {
+		errs = append(errs, FieldError{Field: "name", Message: "required"})
+	}
+
+	// メールアドレスは任意項目のため、指定された場合のみ形式を検証する
+	// "Alice <alice@example.com>" のような表示名付きの形式は受け付けない
+	if u.Email != "" {
+		addr, err := mail.ParseAddress(u.Email)
+		if err != nil || addr.Address != u.Email {
+			errs = append(errs, FieldError{Field: "email", Message: "must be a valid email address"})
+		}
+	}
+	return errs
+}
+
+// FieldValidator はフィールドの値を検証する関数。値を受け付けない場合はその理由をエラーとして返す。
+type FieldValidator func(value string) error
+
+// fieldValidator はAddValidatorで登録された、検証するフィールドと関数の組
+type fieldValidator struct {
+	field string
+	fn    FieldValidator
+}
+
+// AddValidator はユーザーの追加と更新で、fieldのフィールドの値を検証するfnを登録する。
+// 名前の長さや使える文字など、配置ごとに異なる規則を組み込みの検証に加えるために使う。
+// fieldには "name" か "email" を指定する。fnが返したエラーはそのフィールドの誤りとして422で返す。
+// 組み込みの検証で既に誤りがあるフィールドや、指定されていない任意項目にはfnを呼び出さない。
+func (a *App) AddValidator(field string, fn FieldValidator) {
+	if field != "name" && field != "email" {
+		panic("AddValidator: unknown field " + field)
+	}
+	a.validatorsMu.Lock()
+	defer a.validatorsMu.Unlock()
+	a.validators = append(a.validators, fieldValidator{field: field, fn: fn})
+}
+
+// validateUser は組み込みの検証に続けて、名前の長さとAddValidatorで登録された関数でユーザー情報を検証する。
+// 誤りがない場合はnilを返す。
+func (a *App) validateUser(u User) ValidationErrors {
+	errs := validateUser(u)
+	// 極端に長い名前がメモリを無駄にしたり連携先を壊したりしないよう制限する
+	// マルチバイト文字を1文字と数えるよう、バイト数ではなく文字数で比べる
+	if n := utf8.RuneCountInString(u.Name); n > a.maxNameLen {
+		errs = append(errs, FieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters (got %d)", a.maxNameLen, n)})
+	}
+	a.validatorsMu.RLock()
+	validators := a.validators
+	a.validatorsMu.RUnlock()
+	for _, v := range validators {
+		value := u.Name
+		if v.field == "email" {
+			value = u.Email
+		}
+		if value == "" || slices.ContainsFunc(errs, func(e FieldError) bool { return e.Field == v.field }) {
+			continue
+		}
+		if err := v.fn(value); err != nil {
+			errs = append(errs, FieldError{Field: v.field, Message: err.Error()})
+		}
+	}
+	return errs
+}
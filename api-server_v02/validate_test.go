@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestValidateUserName(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		wantErr bool
+	}{
+		{"empty", "", true},
+		{"whitespace only", " \t\n", true},
+		{"valid", "alice", false},
+		{"valid with surrounding whitespace", " alice ", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateUser(User{Name: tt.user})
+			if tt.wantErr {
+				if want := (ValidationErrors{{Field: "name", Message: "required"}}); !reflect.DeepEqual(errs, want) {
+					t.Errorf("validateUser(%q) = %v, want %v", tt.user, errs, want)
+				}
+				return
+			}
+			if errs != nil {
+				t.Errorf("validateUser(%q) = %v, want no errors", tt.user, errs)
+			}
+		})
+	}
+}
+
+// TestUserNameRequired は作成と更新で、空の名前を拒否し、前後の空白を取り除いて保存することを確かめる。
+func TestUserNameRequired(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantName   string // ユーザー1の名前
+	}{
+		{"create", http.MethodPost, "/users", `{"name":" Bob "}`, http.StatusCreated, "alice"},
+		{"create empty", http.MethodPost, "/users", `{"name":""}`, http.StatusUnprocessableEntity, "alice"},
+		{"create whitespace only", http.MethodPost, "/users", `{"name":"   "}`, http.StatusUnprocessableEntity, "alice"},
+		{"create without a name", http.MethodPost, "/users", `{}`, http.StatusUnprocessableEntity, "alice"},
+		{"update", http.MethodPut, "/users/1", `{"name":" Alice2 ","version":1}`, http.StatusOK, "Alice2"},
+		{"update whitespace only", http.MethodPut, "/users/1", `{"name":" ","version":1}`, http.StatusUnprocessableEntity, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s %s: status = %d, want %d: %s", tt.method, tt.path, tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			switch tt.wantStatus {
+			case http.StatusCreated:
+				if u := decodeTestJSON[User](t, data); u.Name != "Bob" {
+					t.Errorf("POST /users %s created %q, want Bob without the whitespace", tt.body, u.Name)
+				}
+			case http.StatusUnprocessableEntity:
+				got := decodeTestJSON[ValidationErrorResponse](t, data).Errors
+				if want := (ValidationErrors{{Field: "name", Message: "required"}}); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s %s %s: errors = %v, want %v", tt.method, tt.path, tt.body, got, want)
+				}
+			}
+			if u, _ := ts.GetUser(1); u.Name != tt.wantName {
+				t.Errorf("user 1 after %s %s is named %q, want %q", tt.method, tt.path, u.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestUserEmail(t *testing.T) {
+	invalid := ValidationErrors{{Field: "email", Message: "must be a valid email address"}}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantEmail  string
+		wantErrors ValidationErrors
+	}{
+		{"valid", `{"name":"bob","email":"bob@example.com"}`, http.StatusCreated, "bob@example.com", nil},
+		{"valid with surrounding whitespace", `{"name":"bob","email":" bob@example.com "}`, http.StatusCreated, "bob@example.com", nil},
+		// メールアドレスは任意項目のため、指定しなくても作成できる
+		{"missing", `{"name":"bob"}`, http.StatusCreated, "", nil},
+		{"no at sign", `{"name":"bob","email":"bob.example.com"}`, http.StatusUnprocessableEntity, "", invalid},
+		{"no local part", `{"name":"bob","email":"@example.com"}`, http.StatusUnprocessableEntity, "", invalid},
+		{"display name", `{"name":"bob","email":"Bob <bob@example.com>"}`, http.StatusUnprocessableEntity, "", invalid},
+		{"with an invalid name", `{"name":"","email":"bob"}`, http.StatusUnprocessableEntity, "", ValidationErrors{
+			{Field: "name", Message: "required"},
+			{Field: "email", Message: "must be a valid email address"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			res, data := ts.do(http.MethodPost, "/users", tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST /users %s: status = %d, want %d: %s", tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.wantStatus == http.StatusCreated {
+				if u := decodeTestJSON[User](t, data); u.Email != tt.wantEmail {
+					t.Errorf("POST /users %s: email = %q, want %q", tt.body, u.Email, tt.wantEmail)
+				}
+				return
+			}
+			if got := decodeTestJSON[ValidationErrorResponse](t, data).Errors; !reflect.DeepEqual(got, tt.wantErrors) {
+				t.Errorf("POST /users %s: errors = %v, want %v", tt.body, got, tt.wantErrors)
+			}
+		})
+	}
+}
+
+// TestValidationErrorsListEveryField は2つの検証に失敗したユーザーを送ると、
+// 作成、更新、一括追加のいずれでも両方のフィールドの誤りを422で返すことを確かめる。
+func TestValidationErrorsListEveryField(t *testing.T) {
+	both := func(prefix string) ValidationErrors {
+		return ValidationErrors{
+			{Field: prefix + "name", Message: "required"},
+			{Field: prefix + "email", Message: "must be a valid email address"},
+		}
+	}
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   ValidationErrors
+	}{
+		{http.MethodPost, "/users", `{"name":"","email":"bob"}`, both("")},
+		{http.MethodPut, "/users/1", `{"name":" ","email":"bob","version":1}`, both("")},
+		{http.MethodPatch, "/users/1", `{"name":"","email":"bob"}`, both("")},
+		{http.MethodPost, "/users/bulk", `[{"name":"bob"},{"name":"","email":"bob"}]`, both("[1].")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Fatalf("%s %s %s: status = %d, want 422: %s", tt.method, tt.path, tt.body, res.StatusCode, data)
+			}
+			if got := decodeTestJSON[ValidationErrorResponse](t, data).Errors; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s %s %s: errors = %v, want %v", tt.method, tt.path, tt.body, got, tt.want)
+			}
+			if n := len(ts.app.store.All()); n != 1 {
+				t.Errorf("%s %s %s left %d users, want only alice", tt.method, tt.path, tt.body, n)
+			}
+		})
+	}
+}
+
+func TestNormalizeUserNFC(t *testing.T) {
+	const composed = "Caf\u00e9"    // é を1文字で表す
+	const decomposed = "Cafe\u0301" // e と結合用のアクセント記号
+	tests := []struct {
+		name string
+		user string
+		want string
+	}{
+		{"composed", composed, composed},
+		{"decomposed", decomposed, composed},
+		{"decomposed with whitespace", "  " + decomposed + "\t", composed},
+		{"ascii", " alice ", "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := User{Name: tt.user}
+			normalizeUser(&u)
+			if u.Name != tt.want {
+				t.Errorf("normalizeUser(%q) = %q, want %q", tt.user, u.Name, tt.want)
+			}
+		})
+	}
+}
+
+// TestUserNameNFC は見た目が同じで合成の仕方が異なる名前を同じ名前として保存し、重複の確認と検索で同じものとして扱うことを確かめる。
+func TestUserNameNFC(t *testing.T) {
+	const composed = "Caf\u00e9"
+	const decomposed = "Cafe\u0301"
+	tests := []struct {
+		name       string
+		first      string
+		second     string
+		wantStatus int // 2人目を作成したときのステータスコード
+	}{
+		{"composed then decomposed", composed, decomposed, http.StatusConflict},
+		{"decomposed then composed", decomposed, composed, http.StatusConflict},
+		{"different names", composed, "Cafe", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			if u := ts.CreateUser(tt.first); u.Name != norm.NFC.String(tt.first) {
+				t.Errorf("POST /users %q stored %q, want the NFC form", tt.first, u.Name)
+			}
+			body, _ := json.Marshal(User{Name: tt.second})
+			if res, data := ts.do(http.MethodPost, "/users", string(body)); res.StatusCode != tt.wantStatus {
+				t.Errorf("POST /users %q after %q: status = %d, want %d: %s", tt.second, tt.first, res.StatusCode, tt.wantStatus, data)
+			}
+
+			// 検索語も正規化するため、どちらの形で検索しても見つかる
+			for _, q := range []string{composed, decomposed} {
+				_, data := ts.do(http.MethodGet, "/users/search?name="+url.QueryEscape(q), "")
+				if got := decodeTestJSON[[]User](t, data); len(got) == 0 || got[0].Name != composed {
+					t.Errorf("GET /users/search?name=%q = %v, want the stored %q", q, got, composed)
+				}
+			}
+		})
+	}
+}
+
+func TestAddValidator(t *testing.T) {
+	maxLen := func(n int) FieldValidator {
+		return func(v string) error {
+			if utf8.RuneCountInString(v) > n {
+				return fmt.Errorf("must be at most %d characters", n)
+			}
+			return nil
+		}
+	}
+	exampleDomain := func(v string) error {
+		if !strings.HasSuffix(v, "@example.com") {
+			return errors.New("must be an example.com address")
+		}
+		return nil
+	}
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		want       ValidationErrors
+	}{
+		{http.MethodPost, "/users", `{"name":"bob"}`, http.StatusCreated, nil},
+		{http.MethodPost, "/users", `{"name":"bobby"}`, http.StatusCreated, nil},
+		{http.MethodPost, "/users", `{"name":"robert"}`, http.StatusUnprocessableEntity, ValidationErrors{{Field: "name", Message: "must be at most 5 characters"}}},
+		{http.MethodPut, "/users/1", `{"name":"robert","version":1}`, http.StatusUnprocessableEntity, ValidationErrors{{Field: "name", Message: "must be at most 5 characters"}}},
+		{http.MethodPatch, "/users/1", `{"name":"robert"}`, http.StatusUnprocessableEntity, ValidationErrors{{Field: "name", Message: "must be at most 5 characters"}}},
+		{http.MethodPost, "/users/bulk", `[{"name":"bob"},{"name":"robert"}]`, http.StatusUnprocessableEntity, ValidationErrors{{Field: "[1].name", Message: "must be at most 5 characters"}}},
+		// どちらのフィールドの誤りもまとめて返す
+		{http.MethodPost, "/users", `{"name":"robert","email":"bob@example.org"}`, http.StatusUnprocessableEntity, ValidationErrors{
+			{Field: "name", Message: "must be at most 5 characters"},
+			{Field: "email", Message: "must be an example.com address"},
+		}},
+		{http.MethodPost, "/users", `{"name":"bob","email":"bob@example.com"}`, http.StatusCreated, nil},
+		// 組み込みの検証で誤りがあるフィールドには登録した関数を呼ばない
+		{http.MethodPost, "/users", `{"name":"bob","email":"bob"}`, http.StatusUnprocessableEntity, ValidationErrors{{Field: "email", Message: "must be a valid email address"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.body, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) {
+				a.AddValidator("name", maxLen(5))
+				a.AddValidator("email", exampleDomain)
+			})
+			ts.CreateUser("alice")
+
+			res, data := ts.do(tt.method, tt.path, tt.body)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s %s: status = %d, want %d: %s", tt.method, tt.path, tt.body, res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got := decodeTestJSON[ValidationErrorResponse](t, data).Errors; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s %s %s: errors = %v, want %v", tt.method, tt.path, tt.body, got, tt.want)
+			}
+			if got, _ := ts.GetUser(1); got.Name != "alice" {
+				t.Errorf("%s %s %s changed alice to %q", tt.method, tt.path, tt.body, got.Name)
+			}
+		})
+	}
+}
+
+func TestAddValidatorUnknownField(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("AddValidator(\"age\", ...) did not panic")
+		}
+	}()
+	NewApp(NewInMemoryStore("")).AddValidator("age", func(string) error { return nil })
+}
+
+func TestMaxNameLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxNameLen int
+		user       string
+		wantStatus int
+		want       ValidationErrors
+	}{
+		{"at the default limit", defaultMaxNameLength, strings.Repeat("a", defaultMaxNameLength), http.StatusCreated, nil},
+		{"over the default limit", defaultMaxNameLength, strings.Repeat("a", defaultMaxNameLength+1), http.StatusUnprocessableEntity,
+			ValidationErrors{{Field: "name", Message: "must be at most 256 characters (got 257)"}}},
+		// バイト数ではなく文字数で数える
+		{"multibyte at the limit", 5, "あいうえお", http.StatusCreated, nil},
+		{"multibyte over the limit", 5, "あいうえおか", http.StatusUnprocessableEntity,
+			ValidationErrors{{Field: "name", Message: "must be at most 5 characters (got 6)"}}},
+		{"emoji over the limit", 3, "😀😀😀😀", http.StatusUnprocessableEntity,
+			ValidationErrors{{Field: "name", Message: "must be at most 3 characters (got 4)"}}},
+		{"empty", 5, "", http.StatusUnprocessableEntity, ValidationErrors{{Field: "name", Message: "required"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, func(a *App) { a.maxNameLen = tt.maxNameLen })
+			body, _ := json.Marshal(User{Name: tt.user})
+			res, data := ts.do(http.MethodPost, "/users", string(body))
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("POST /users with a %d-character name: status = %d, want %d: %s", utf8.RuneCountInString(tt.user), res.StatusCode, tt.wantStatus, data)
+			}
+			if tt.want == nil {
+				if got := decodeTestJSON[User](t, data).Name; got != tt.user {
+					t.Errorf("POST /users stored name %q, want %q", got, tt.user)
+				}
+				return
+			}
+			if got := decodeTestJSON[ValidationErrorResponse](t, data).Errors; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("POST /users with a %d-character name: errors = %v, want %v", utf8.RuneCountInString(tt.user), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "net/http"
+
+// ビルド時に -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=..." で埋め込む情報
+// go runなどで埋め込まずにビルドした場合はデフォルト値のままになる
+var (
+	version   = "dev"     // リリースのバージョン
+	commit    = "unknown" // ビルドしたgitのコミット
+	buildTime = "unknown" // ビルドした日時
+)
+
+// VersionResponse はビルド情報の取得のレスポンスを表す構造体。
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// getVersion は動作しているサーバのビルド情報を返すエンドポイントのハンドラ
+// デプロイしたビルドが意図したものかを確認するために使う
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, VersionResponse{Version: version, Commit: commit, BuildTime: buildTime})
+}
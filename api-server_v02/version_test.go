@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// setTestBuildInfo はテストの間だけビルド情報を差し替える。
+func setTestBuildInfo(t *testing.T, v, c, b string) {
+	t.Helper()
+	origVersion, origCommit, origBuildTime := version, commit, buildTime
+	version, commit, buildTime = v, c, b
+	t.Cleanup(func() { version, commit, buildTime = origVersion, origCommit, origBuildTime })
+}
+
+func TestGetVersion(t *testing.T) {
+	tests := []struct {
+		name                       string
+		version, commit, buildTime string
+		want                       string
+	}{
+		{"defaults", "dev", "unknown", "unknown", `{"version":"dev","commit":"unknown","build_time":"unknown"}`},
+		{"injected", "v1.2.3", "abc1234", "2024-01-01T00:00:00Z", `{"version":"v1.2.3","commit":"abc1234","build_time":"2024-01-01T00:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestBuildInfo(t, tt.version, tt.commit, tt.buildTime)
+			ts := newTestServer(t)
+			res, data := ts.do(http.MethodGet, "/version", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("GET /version: status = %d: %s", res.StatusCode, data)
+			}
+			if got := strings.TrimSpace(string(data)); got != tt.want {
+				t.Errorf("GET /version = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
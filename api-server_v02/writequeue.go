@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 書き込みのキューのデフォルト値
+const (
+	defaultWriteQueueSize    = 64          // 処理を待てる書き込みのリクエスト数
+	defaultWriteQueueTimeout = time.Second // キューが空くのを待つ最大時間
+)
+
+// writeJob はキューに入れた書き込みのリクエストの処理
+type writeJob struct {
+	fn   func()
+	done chan any // 処理が終わると閉じる。fnがpanicした場合はその値を送ってから閉じる
+}
+
+// writeQueue は書き込みのリクエストを1つのワーカーで順に処理するキュー。
+// 更新が集中したときに多数のゴルーチンがストアのロックを奪い合うのを避け、
+// 待ちきれないリクエストは早めに503で断る。
+type writeQueue struct {
+	jobs    chan writeJob
+	timeout time.Duration // キューが空くのを待つ最大時間
+}
+
+// newWriteQueue はsize件まで処理を待てるwriteQueueを生成し、ワーカーを起動する。
+func newWriteQueue(size int, timeout time.Duration) *writeQueue {
+	q := &writeQueue{
+		jobs:    make(chan writeJob, size),
+		timeout: timeout,
+	}
+	go q.work()
+	return q
+}
+
+// work はキューに入った処理を1件ずつ実行する。
+// サーバが動いている間ずっと実行されることを想定している。
+func (q *writeQueue) work() {
+	for job := range q.jobs {
+		func() {
+			defer close(job.done)
+			defer func() {
+				// panicはリクエストを処理しているゴルーチンで起こし直し、withRecoveryに任せる
+				if v := recover(); v != nil {
+					job.done <- v
+				}
+			}()
+			job.fn()
+		}()
+	}
+}
+
+// isWriteMethod はメソッドがユーザー情報を変更しうるかを返す。
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// withWriteQueue は書き込みのリクエストをqで順に処理するミドルウェア
+// 読み取りのリクエストはキューを通さずにそのまま処理する
+// キューが空くのを待ちきれない場合は503とRetry-Afterヘッダを返す
+// 処理を途中で見捨てると順に処理されなくなるため、withTimeoutより内側に置き、nextにはwithTimeoutを含めないこと
+func withWriteQueue(next http.Handler, q *writeQueue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWriteMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 遅いアップロードが他の書き込みを待たせないよう、ボディはキューに入れる前に読み込んでおく
+		// 上限を超えた場合などの読み込みのエラーは、ハンドラがボディを読んだときに同じエラーとして返す
+		bufferBody(r)
+
+		job := writeJob{
+			fn: func() {
+				// 待っている間にタイムアウトしたかクライアントが切断した場合は、誰も受け取らない書き込みをしない
+				if r.Context().Err() != nil {
+					return
+				}
+				next.ServeHTTP(w, r)
+			},
+			done: make(chan any, 1),
+		}
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		select {
+		case q.jobs <- job:
+		case <-timer.C:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "queue_full", "too many pending writes")
+			return
+		case <-r.Context().Done():
+			// 処理を始める前にクライアントが切断した場合は何もしない
+			return
+		}
+		if v, panicked := <-job.done; panicked {
+			panic(v)
+		}
+	})
+}
+
+// bufferBody はrのボディを全て読み込み、読み込んだ内容を返すボディに置き換える。
+// 読み込みがエラーで終わった場合は、読み込めた内容の後にそのエラーを返す。
+func bufferBody(r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	var body io.Reader = bytes.NewReader(data)
+	if err != nil {
+		body = io.MultiReader(body, errReader{err})
+	}
+	r.Body = io.NopCloser(body)
+}
+
+// errReader は常にerrを返すio.Reader
+type errReader struct{ err error }
+
+// Read はerrを返す。
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteQueueSaturated(t *testing.T) {
+	// 1件を処理中にし、もう1件でキューを埋めた状態にする
+	q := newWriteQueue(1, 20*time.Millisecond)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	h := withWriteQueue(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}), q)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(release)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", nil))
+		}()
+	}
+	<-started
+	for len(q.jobs) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		method     string
+		wantStatus int
+	}{
+		{http.MethodPost, http.StatusServiceUnavailable},
+		{http.MethodDelete, http.StatusServiceUnavailable},
+		// 読み取りはキューを通さないため、埋まっていても待たずに処理する
+		{http.MethodGet, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/users", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("%s with a saturated queue: status = %d, want %d", tt.method, rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("%s with a saturated queue: no Retry-After header", tt.method)
+			}
+		})
+	}
+}
+
+// BenchmarkAddUserParallel は書き込みが集中したときの追加のスループットを、キューを通す場合と通さない場合で比べる。
+func BenchmarkAddUserParallel(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		queue bool
+	}{
+		{"direct", false},
+		{"queued", true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			app := NewApp(NewInMemoryStore(""))
+			h := app.newMux()
+			if bm.queue {
+				h = withWriteQueue(h, newWriteQueue(defaultWriteQueueSize, time.Minute))
+			}
+			var n atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					body := fmt.Sprintf(`{"name":"user%d"}`, n.Add(1))
+					req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+					rec := httptest.NewRecorder()
+					h.ServeHTTP(rec, req)
+					if rec.Code != http.StatusCreated {
+						b.Errorf("POST /users: status = %d: %s", rec.Code, rec.Body)
+						return
+					}
+				}
+			})
+		})
+	}
+}